@@ -12,6 +12,8 @@ import (
 	"zkp-api/pkg/app/prover/service"
 	"zkp-api/pkg/config"
 	"zkp-api/pkg/http/grpc"
+	"zkp-api/pkg/ratelimit"
+	"zkp-api/pkg/zkp"
 )
 
 func main() {
@@ -26,16 +28,41 @@ func main() {
 		log.Fatalf("error loading prover config: %v", err)
 	}
 
+	protocol, err := zkp.GetProtocol(proverCfg.Protocol)
+	if err != nil {
+		log.Fatalf("error loading zkp protocol: %v", err)
+	}
+
+	kdf := proverCfg.KDF
+	if kdf == (zkp.KDFParams{}) {
+		kdf = zkp.DefaultKDFParams
+	}
+	if err = kdf.Validate(); err != nil {
+		log.Fatalf("invalid kdf config: %v", err)
+	}
+
+	var nonInteractive bool
+	switch proverCfg.ProverZKP.Mode {
+	case "", "interactive":
+	case "non-interactive":
+		nonInteractive = true
+	default:
+		log.Fatalf("unknown login mode '%s'", proverCfg.ProverZKP.Mode)
+	}
+
 	conn, errC := grpc.InitClient(proverCfg.GRPCClient.Target)
 	if errC != nil {
 		log.Fatalf("unable to init client: %s", errC.Error())
 	}
 
-	pSrv := service.NewServerProver(conn)
+	pSrv := service.NewServerProver(conn, protocol, kdf, nonInteractive)
 	ah := handler.NewAuthHandler(pSrv)
 	r := mux.NewRouter()
 	r.HandleFunc("/register", ah.RegisterUserHandler).Methods("POST")
 	r.HandleFunc("/login", ah.LoginUserHandler).Methods("POST")
+	r.HandleFunc("/refresh", ah.RefreshSessionHandler).Methods("POST")
+	r.HandleFunc("/change-password", ah.ChangePasswordHandler).Methods("POST")
+	r.Use(handler.RequestID, ratelimit.Middleware(ratelimit.NewLimiter(proverCfg.RateLimit), handler.UserName, handler.RateLimited))
 	fmt.Println("starting server")
 	// Fire up the server ":8080"
 	log.Fatal(http.ListenAndServe(proverCfg.Port, r))
@@ -1,13 +1,27 @@
 package main
 
 import (
+	"context"
+	"crypto/ed25519"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 	"zkp-api/pkg/app/verifier/handler"
 	"zkp-api/pkg/app/verifier/service"
 	"zkp-api/pkg/config"
 	"zkp-api/pkg/http/grpc"
+	"zkp-api/pkg/ratelimit"
+	"zkp-api/pkg/session"
+	"zkp-api/pkg/storage"
+	"zkp-api/pkg/storage/file"
+	"zkp-api/pkg/storage/postgres"
+	"zkp-api/pkg/storage/redis"
+	"zkp-api/pkg/storage/virtual"
+	"zkp-api/pkg/zkp"
 )
 
 func main() {
@@ -23,15 +37,195 @@ func main() {
 		log.Fatalf("error loading verifier config: %v", err)
 	}
 
+	// zkp backends users can register with, all the available ones if none is configured
+	names := verifierCfg.Protocols
+	if len(names) == 0 {
+		names = zkp.Protocols()
+	}
+	protocols := make([]zkp.Protocol, 0, len(names))
+	for _, name := range names {
+		p, err := zkp.GetProtocol(name)
+		if err != nil {
+			log.Fatalf("error loading zkp protocol: %v", err)
+		}
+		protocols = append(protocols, p)
+	}
+
+	mode, err := service.ParseChallengeMode(verifierCfg.Mode)
+	if err != nil {
+		log.Fatalf("error loading challenge mode: %v", err)
+	}
+
+	lockout := service.LockoutPolicy{
+		UserAttempts:   verifierCfg.UserAttempts,
+		ClientAttempts: verifierCfg.ClientAttempts,
+		BaseDelay:      verifierCfg.BaseDelay,
+		MaxDelay:       verifierCfg.MaxDelay,
+		Window:         verifierCfg.Window,
+	}
+	if lockout == (service.LockoutPolicy{}) {
+		lockout = service.DefaultLockoutPolicy
+	}
+	trustedProxies, err := handler.ParseTrustedProxies(verifierCfg.TrustedProxies)
+	if err != nil {
+		log.Fatalf("error loading trusted proxies: %v", err)
+	}
+
+	// expired challenges, login nonces, sessions, refresh tokens and failed attempts are deleted in the background until the server is stopped
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	st, err := newStorages(ctx, verifierCfg.VerifierStorage, lockout.Retention())
+	if err != nil {
+		log.Fatalf("error loading storage: %v", err)
+	}
+	ttl := verifierCfg.ChallengeTTL
+	if ttl <= 0 {
+		ttl = service.DefaultChallengeTTL
+	}
+	interval := verifierCfg.ReapInterval
+	if interval <= 0 {
+		interval = ttl
+	}
+	go service.ReapChallenges(ctx, st.challenges, interval)
+	go service.ReapNonces(ctx, st.nonces, interval)
+	go service.ReapSessions(ctx, st.sessions, interval)
+	go service.ReapRefreshTokens(ctx, st.refreshTokens, interval)
+	go service.ReapAttempts(ctx, st.attempts, interval, lockout)
+	go func() {
+		// note the signals are caught by the context, exit once it is done since the grpc server has no shutdown hook
+		<-ctx.Done()
+		log.Printf("shutting down")
+		st.close()
+		os.Exit(0)
+	}()
+
+	// session tokens signing key, the tokens of an ephemeral key cannot be validated after a restart
+	var key ed25519.PrivateKey
+	if verifierCfg.SigningKey != "" {
+		if key, err = session.LoadKey(verifierCfg.SigningKey); err != nil {
+			log.Fatalf("error loading session signing key: %v", err)
+		}
+	} else {
+		log.Printf("no session signing key configured, generating an ephemeral one")
+		if _, key, err = ed25519.GenerateKey(nil); err != nil {
+			log.Fatalf("error generating session signing key: %v", err)
+		}
+	}
+	sessions := session.NewSigner(key, verifierCfg.Issuer, verifierCfg.TTL)
+	log.Printf("session tokens verification key id: %s", sessions.VerificationKey().ID)
+
 	// init verifier
-	vSrv := service.NewServerVerifier()
+	vSrv := service.NewServerVerifier(st.users, st.challenges, st.nonces, st.sessions, st.refreshTokens, st.attempts,
+		service.Options{
+			Protocols:    protocols,
+			Mode:         mode,
+			ChallengeTTL: ttl,
+			NonceTTL:     verifierCfg.NonceTTL,
+			Sessions:     sessions,
+			RefreshTTL:   verifierCfg.RefreshTTL,
+			Lockout:      lockout,
+		})
 	//HandlerVerifier
-	hv := handler.NewHandlerVerifier(vSrv)
+	hv := handler.NewHandlerVerifier(vSrv, trustedProxies)
 
 	fmt.Println("initializing grpc server")
-	errS := grpc.InitServer(verifierCfg.Network, verifierCfg.Address, hv)
+	if verifierCfg.VerifierAdmin.Token == "" {
+		log.Printf("no admin token configured, the admin calls are disabled")
+	}
+	errS := grpc.InitServer(verifierCfg.Network, verifierCfg.Address, hv,
+		grpc.AdminInterceptor(verifierCfg.VerifierAdmin.Token),
+		ratelimit.UnaryServerInterceptor(ratelimit.NewLimiter(verifierCfg.RateLimit), hv.ClientAddr))
 	if errS != nil {
 		log.Fatalf("unable to init server: %s", errS.Error())
 	}
 
 }
+
+// storages holds the storages of the verifier and what has to be closed on shutdown, if anything.
+type storages struct {
+	users         storage.VerifierStorage
+	challenges    storage.ChallengeStorage
+	nonces        storage.NonceStorage
+	sessions      storage.SessionStorage
+	refreshTokens storage.RefreshTokenStorage
+	attempts      storage.AttemptStorage
+	closers       []io.Closer
+}
+
+// newStorages returns the storages of the given type, the in-memory ones if empty.
+// The file storage only persists the users, the postgres one keeps every storage in the database.
+// The challenges, login nonces, sessions, refresh tokens and failed attempts are kept in redis instead if its address is set,
+// the attempts for the given retention after their last failure.
+// Storages shared by several replicas are refused along with in-memory failed attempts, which would let every replica
+// be brute-forced on its own.
+func newStorages(ctx context.Context, cfg config.VerifierStorage, retention time.Duration) (*storages, error) {
+	st := &storages{
+		users:         virtual.NewVerifierStorage(),
+		challenges:    virtual.NewChallengeStorage(),
+		nonces:        virtual.NewNonceStorage(),
+		sessions:      virtual.NewSessionStorage(),
+		refreshTokens: virtual.NewRefreshTokenStorage(),
+		attempts:      virtual.NewAttemptStorage(),
+	}
+	switch cfg.Type {
+	case "", "memory":
+	case "file":
+		sync, err := file.ParseSyncPolicy(cfg.Sync)
+		if err != nil {
+			return nil, err
+		}
+		users, err := file.NewVerifierStorage(cfg.Dir, file.Options{
+			Sync:             sync,
+			SyncInterval:     cfg.SyncInterval,
+			SnapshotInterval: cfg.SnapshotInterval,
+			SnapshotRecords:  cfg.SnapshotRecords,
+		})
+		if err != nil {
+			return nil, err
+		}
+		st.users = users
+		st.closers = append(st.closers, users)
+	case "postgres":
+		db, err := postgres.Open(ctx, cfg.DSN, cfg.MaxConns)
+		if err != nil {
+			return nil, err
+		}
+		st.users = postgres.NewVerifierStorage(db)
+		st.challenges = postgres.NewChallengeStorage(db)
+		st.nonces = postgres.NewNonceStorage(db)
+		st.sessions = postgres.NewSessionStorage(db)
+		st.refreshTokens = postgres.NewRefreshTokenStorage(db)
+		st.attempts = postgres.NewAttemptStorage(db)
+		st.closers = append(st.closers, db)
+	default:
+		return nil, fmt.Errorf("unknown storage type '%s'", cfg.Type)
+	}
+	if cfg.Redis.Address != "" {
+		client, err := redis.Open(ctx, cfg.Redis.Address, cfg.Redis.Password, cfg.Redis.DB)
+		if err != nil {
+			st.close()
+			return nil, err
+		}
+		st.challenges = redis.NewChallengeStorage(client, cfg.Redis.Prefix)
+		st.nonces = redis.NewNonceStorage(client, cfg.Redis.Prefix)
+		st.sessions = redis.NewSessionStorage(client, cfg.Redis.Prefix)
+		st.refreshTokens = redis.NewRefreshTokenStorage(client, cfg.Redis.Prefix)
+		st.attempts = redis.NewAttemptStorage(client, cfg.Redis.Prefix, retention)
+		st.closers = append(st.closers, client)
+	}
+	shared := cfg.Type == "postgres" || cfg.Redis.Address != ""
+	if _, local := st.attempts.(*virtual.AttemptVirtualStorage); shared && local {
+		st.close()
+		return nil, fmt.Errorf("storage type '%s' is shared by replicas but the failed attempts are kept in memory", cfg.Type)
+	}
+	return st, nil
+}
+
+// close closes the storages that have to be, logging the errors.
+func (st *storages) close() {
+	for _, c := range st.closers {
+		if err := c.Close(); err != nil {
+			log.Printf("error closing storage: %v", err)
+		}
+	}
+}
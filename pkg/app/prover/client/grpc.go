@@ -5,13 +5,18 @@ import (
 	"google.golang.org/grpc"
 	"time"
 	pb "zkp-api/pkg/http/grpc/zkp"
+	"zkp-api/pkg/zkp"
 )
 
 // Auth defines the interface for the client that will interact with the prover service.
 type Auth interface {
-	Register(user string, y1, y2 []byte) error
-	RequestAuthenticationChallenge(user string, r1, r2 []byte) (*pb.AuthenticationChallengeResponse, error)
-	SendAuthentication(authId string, s []byte) (*pb.AuthenticationAnswerResponse, error)
+	Register(ctx context.Context, user, protocol string, salt []byte, kdf zkp.KDFParams, y1, y2 []byte) error
+	GetLoginParameters(ctx context.Context, user string) (*pb.LoginParametersResponse, error)
+	RequestAuthenticationChallenge(ctx context.Context, user string, r1, r2 []byte) (*pb.AuthenticationChallengeResponse, error)
+	SendAuthentication(ctx context.Context, authId string, s []byte) (*pb.AuthenticationAnswerResponse, error)
+	Login(ctx context.Context, user string, nonce, r1, r2, s []byte) (*pb.LoginResponse, error)
+	UpdateCommitments(ctx context.Context, user string, nonce, salt []byte, kdf zkp.KDFParams, y1, y2, r1, r2, s []byte) error
+	RefreshSession(ctx context.Context, refreshToken string) (*pb.RefreshSessionResponse, error)
 }
 
 // Client is a gRPC client that implements the Auth interface to communicate with the prover service.
@@ -27,21 +32,38 @@ func NewAuthClient(conn *grpc.ClientConn) Auth {
 	}
 }
 
-// Register sends a registration request to the authentication service with the user's details and public commitments.
-// It handles the context with a timeout for the gRPC call.
+// Register sends a registration request to the authentication service with the user's details, the zkp backend,
+// the salt and KDF parameters the secret was derived with and public commitments.
+// It handles the context of the request with a timeout for the gRPC call.
 // Returns an error if the registration request fails.
-func (a *Client) Register(user string, y1, y2 []byte) error {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+func (a *Client) Register(ctx context.Context, user, protocol string, salt []byte, kdf zkp.KDFParams, y1, y2 []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Second)
 	defer cancel()
-	_, err := a.client.Register(ctx, &pb.RegisterRequest{User: user, Y1: y1, Y2: y2})
+	req := &pb.RegisterRequest{
+		User:     user,
+		Protocol: protocol,
+		Salt:     salt,
+		Kdf:      &pb.KDFParams{Time: kdf.Time, Memory: kdf.Memory, Threads: uint32(kdf.Threads)},
+		Y1:       y1,
+		Y2:       y2,
+	}
+	_, err := a.client.Register(ctx, req)
 	return err
 }
 
+// GetLoginParameters requests the zkp backend, the salt and the KDF parameters the user registered with.
+// Returns a LoginParametersResponse or an error if the request fails.
+func (a *Client) GetLoginParameters(ctx context.Context, user string) (*pb.LoginParametersResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	return a.client.GetLoginParameters(ctx, &pb.LoginParametersRequest{User: user})
+}
+
 // RequestAuthenticationChallenge sends a request to the authentication service to initiate an authentication challenge for the user.
 // It provides the user's details and random commitments as part of the request.
 // Returns an AuthenticationChallengeResponse or an error if the request fails.
-func (a *Client) RequestAuthenticationChallenge(user string, r1, r2 []byte) (*pb.AuthenticationChallengeResponse, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+func (a *Client) RequestAuthenticationChallenge(ctx context.Context, user string, r1, r2 []byte) (*pb.AuthenticationChallengeResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second)
 	defer cancel()
 	return a.client.CreateAuthenticationChallenge(ctx, &pb.AuthenticationChallengeRequest{User: user, R1: r1, R2: r2})
 }
@@ -49,8 +71,47 @@ func (a *Client) RequestAuthenticationChallenge(user string, r1, r2 []byte) (*pb
 // SendAuthentication sends the solution to the authentication challenge to the service.
 // It includes the authentication ID and the solution as part of the request.
 // Returns an AuthenticationAnswerResponse or an error if the request fails.
-func (a *Client) SendAuthentication(authId string, s []byte) (*pb.AuthenticationAnswerResponse, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+func (a *Client) SendAuthentication(ctx context.Context, authId string, s []byte) (*pb.AuthenticationAnswerResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second)
 	defer cancel()
 	return a.client.VerifyAuthentication(ctx, &pb.AuthenticationAnswerRequest{AuthId: authId, S: s})
 }
+
+// Login sends a whole non-interactive proof to the authentication service.
+// It includes the user's name, the nonce the transcript is bound to, the random commitments and the solution as part
+// of the request.
+// Returns a LoginResponse or an error if the request fails.
+func (a *Client) Login(ctx context.Context, user string, nonce, r1, r2, s []byte) (*pb.LoginResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	return a.client.Login(ctx, &pb.LoginRequest{User: user, R1: r1, R2: r2, S: s, Nonce: nonce})
+}
+
+// RefreshSession exchanges a refresh token for a new session token and the next refresh token.
+// Returns a RefreshSessionResponse or an error if the request fails.
+func (a *Client) RefreshSession(ctx context.Context, refreshToken string) (*pb.RefreshSessionResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	return a.client.RefreshSession(ctx, &pb.RefreshSessionRequest{RefreshToken: refreshToken})
+}
+
+// UpdateCommitments sends the new salt, KDF parameters and public commitments of a user to the authentication service,
+// along with the proof (r1, r2, s) of the current secret that authorizes them and the nonce its transcript is bound to.
+// Returns an error if the request fails.
+func (a *Client) UpdateCommitments(ctx context.Context, user string, nonce, salt []byte, kdf zkp.KDFParams, y1, y2, r1, r2, s []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	req := &pb.UpdateCommitmentsRequest{
+		User:  user,
+		Salt:  salt,
+		Kdf:   &pb.KDFParams{Time: kdf.Time, Memory: kdf.Memory, Threads: uint32(kdf.Threads)},
+		Y1:    y1,
+		Y2:    y2,
+		R1:    r1,
+		R2:    r2,
+		S:     s,
+		Nonce: nonce,
+	}
+	_, err := a.client.UpdateCommitments(ctx, req)
+	return err
+}
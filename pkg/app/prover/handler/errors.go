@@ -0,0 +1,178 @@
+package handler
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+	jr "zkp-api/pkg/app/prover/handler/request"
+	"zkp-api/pkg/app/prover/service"
+	"zkp-api/pkg/http/grpc"
+)
+
+// RequestIDHeader is the header of the id of a request, echoed in its response and error body.
+const RequestIDHeader = "X-Request-Id"
+
+// maxRequestID is the longest request id taken from a request header.
+const maxRequestID = 64
+
+// Codes of the error bodies that are not reasons of the verifier, which are passed through as they are.
+const (
+	CodeInvalidRequest  = "INVALID_REQUEST" // the request is not valid
+	CodeUnauthenticated = "UNAUTHENTICATED" // the credentials or tokens are not valid
+	CodeNotFound        = "NOT_FOUND"       // the resource does not exist
+	CodeConflict        = "CONFLICT"        // the resource already exists
+	CodeUnavailable     = "UNAVAILABLE"     // the verifier cannot be reached, the request can be retried
+	CodeUpstream        = "UPSTREAM_ERROR"  // the verifier failed to process the request
+	CodeInternal        = "INTERNAL"        // the prover failed to process the request
+	CodeRateLimited     = grpc.ReasonRateLimited
+)
+
+// apiError is an error response: its HTTP status, code and message, which never carries the message of the error.
+type apiError struct {
+	status  int
+	code    string
+	message string
+}
+
+var (
+	errInvalidBody     = apiError{http.StatusBadRequest, CodeInvalidRequest, "invalid request body"}
+	errInvalidPassword = apiError{http.StatusBadRequest, CodeInvalidRequest, "password must be a non empty UTF-8 string"}
+	errInternal        = apiError{http.StatusInternalServerError, CodeInternal, "internal error"}
+	errRateLimited     = apiError{http.StatusTooManyRequests, CodeRateLimited, "too many requests, try again later"}
+)
+
+// reasonErrors are the error responses of the reasons of the verifier errors.
+var reasonErrors = map[string]apiError{
+	grpc.ReasonInvalidArgument:     {http.StatusBadRequest, grpc.ReasonInvalidArgument, "invalid request"},
+	grpc.ReasonUserExists:          {http.StatusConflict, grpc.ReasonUserExists, "user already exists"},
+	grpc.ReasonUserNotFound:        {http.StatusNotFound, grpc.ReasonUserNotFound, "user not found"},
+	grpc.ReasonUserNotActive:       {http.StatusLocked, grpc.ReasonUserNotActive, "account is not active"},
+	grpc.ReasonLocked:              {http.StatusLocked, grpc.ReasonLocked, "too many failed attempts, try again later"},
+	grpc.ReasonRateLimited:         errRateLimited,
+	grpc.ReasonInvalidProof:        {http.StatusUnauthorized, grpc.ReasonInvalidProof, "invalid credentials"},
+	grpc.ReasonInvalidSession:      {http.StatusUnauthorized, grpc.ReasonInvalidSession, "invalid session"},
+	grpc.ReasonInvalidRefreshToken: {http.StatusUnauthorized, grpc.ReasonInvalidRefreshToken, "invalid refresh token"},
+	// note the login flow of the prover failed on the verifier side, e.g: the challenge expired while it was solved
+	grpc.ReasonChallengeNotFound: {http.StatusBadGateway, CodeUpstream, "verifier error"},
+	grpc.ReasonChallengeExpired:  {http.StatusServiceUnavailable, CodeUnavailable, "verifier timeout, try again"},
+	grpc.ReasonNoNonce:           {http.StatusBadGateway, CodeUpstream, "verifier error"},
+}
+
+// codeErrors are the error responses of the gRPC status codes of the verifier errors without a known reason,
+// any other code is an upstream error.
+var codeErrors = map[codes.Code]apiError{
+	codes.InvalidArgument:   {http.StatusBadRequest, CodeInvalidRequest, "invalid request"},
+	codes.Unauthenticated:   {http.StatusUnauthorized, CodeUnauthenticated, "unauthenticated"},
+	codes.NotFound:          {http.StatusNotFound, CodeNotFound, "not found"},
+	codes.AlreadyExists:     {http.StatusConflict, CodeConflict, "already exists"},
+	codes.ResourceExhausted: errRateLimited,
+	codes.Unavailable:       {http.StatusServiceUnavailable, CodeUnavailable, "verifier unavailable, try again"},
+	codes.DeadlineExceeded:  {http.StatusServiceUnavailable, CodeUnavailable, "verifier timeout, try again"},
+}
+
+var errUpstream = apiError{http.StatusBadGateway, CodeUpstream, "verifier error"}
+
+// toAPIError returns the error response of an error of the Auth service and how long the client has to wait before
+// retrying, zero if unknown.
+func toAPIError(err error) (apiError, time.Duration) {
+	if errors.Is(err, service.ErrUnknownProtocol) {
+		return apiError{http.StatusBadRequest, CodeInvalidRequest, "unknown protocol"}, 0
+	}
+	st, ok := status.FromError(err)
+	if !ok {
+		return errInternal, 0
+	}
+	ae, ok := codeErrors[st.Code()]
+	if !ok {
+		ae = errUpstream
+	}
+	var wait time.Duration
+	for _, d := range st.Details() {
+		switch d := d.(type) {
+		case *errdetails.ErrorInfo:
+			if re, ok := reasonErrors[d.GetReason()]; ok && d.GetDomain() == grpc.ErrorDomain {
+				ae = re
+			}
+		case *errdetails.RetryInfo:
+			wait = d.GetRetryDelay().AsDuration()
+		}
+	}
+	return ae, wait
+}
+
+// writeError logs an error of the Auth service along with the id of the request and writes its error response.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	log.Printf("request %s: %s", requestID(r), err.Error())
+	ae, wait := toAPIError(err)
+	writeAPIError(w, r, ae, wait)
+}
+
+// writeAPIError writes an error response as the JSON body {code, message, requestId},
+// with a Retry-After header if the client has to wait before retrying.
+func writeAPIError(w http.ResponseWriter, r *http.Request, ae apiError, wait time.Duration) {
+	body, err := json.Marshal(&jr.ErrorResp{Code: ae.code, Message: ae.message, RequestID: requestID(r)})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if wait > 0 {
+		secs := int64((wait + time.Second - 1) / time.Second)
+		w.Header().Set("Retry-After", strconv.FormatInt(secs, 10))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(ae.status)
+	_, _ = w.Write(body)
+}
+
+// RateLimited writes the error response of a request rejected by the rate limiting.
+func RateLimited(w http.ResponseWriter, r *http.Request, wait time.Duration) {
+	writeAPIError(w, r, errRateLimited, wait)
+}
+
+// requestIDKey is the context key of the id of a request.
+type requestIDKey struct{}
+
+// RequestID is an HTTP middleware that gives every request an id, the one of its X-Request-Id header if it is valid
+// or a new random one, which is echoed in the X-Request-Id header of the response.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			b := make([]byte, 16)
+			if _, err := rand.Read(b); err != nil {
+				log.Printf("error generating request id: %s", err.Error())
+			}
+			id = hex.EncodeToString(b)
+		}
+		w.Header().Set(RequestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+// validRequestID returns whether a request id taken from a header can be echoed and logged as it is.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestID {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// requestID returns the id of a request, empty if the RequestID middleware was not used.
+func requestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey{}).(string)
+	return id
+}
@@ -0,0 +1,123 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	jr "zkp-api/pkg/app/prover/handler/request"
+	"zkp-api/pkg/app/prover/service"
+	"zkp-api/pkg/http/grpc"
+)
+
+// verifierError returns the error of the verifier with the status code, the ErrorInfo reason and the RetryInfo wait.
+func verifierError(code codes.Code, msg, reason string, wait time.Duration) error {
+	st := status.New(code, msg)
+	if reason != "" {
+		st, _ = st.WithDetails(&errdetails.ErrorInfo{Reason: reason, Domain: grpc.ErrorDomain})
+	}
+	if wait > 0 {
+		st, _ = st.WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(wait)})
+	}
+	return st.Err()
+}
+
+func TestWriteError(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		status     int
+		code       string
+		retryAfter string
+	}{
+		{"user exists", verifierError(codes.AlreadyExists, "user already exists: 'jon'", grpc.ReasonUserExists, 0),
+			http.StatusConflict, grpc.ReasonUserExists, ""},
+		{"user not found", verifierError(codes.NotFound, "user does not exist: 'jon'", grpc.ReasonUserNotFound, 0),
+			http.StatusNotFound, grpc.ReasonUserNotFound, ""},
+		{"invalid proof", verifierError(codes.Unauthenticated, "invalid proof", grpc.ReasonInvalidProof, 0),
+			http.StatusUnauthorized, grpc.ReasonInvalidProof, ""},
+		{"locked", verifierError(codes.ResourceExhausted, "too many failed attempts: client:10.0.0.1 locked for 2s", grpc.ReasonLocked, 1500*time.Millisecond),
+			http.StatusLocked, grpc.ReasonLocked, "2"},
+		{"rate limited", verifierError(codes.ResourceExhausted, "rate limit exceeded", grpc.ReasonRateLimited, time.Second),
+			http.StatusTooManyRequests, CodeRateLimited, "1"},
+		{"invalid argument without reason", verifierError(codes.InvalidArgument, "salt must be at least 16 bytes long", "", 0),
+			http.StatusBadRequest, CodeInvalidRequest, ""},
+		{"unavailable", verifierError(codes.Unavailable, "connection refused 10.0.0.5:50051", "", 0),
+			http.StatusServiceUnavailable, CodeUnavailable, ""},
+		{"deadline", verifierError(codes.DeadlineExceeded, "context deadline exceeded", "", 0),
+			http.StatusServiceUnavailable, CodeUnavailable, ""},
+		{"internal", verifierError(codes.Internal, "internal error", grpc.ReasonInternal, 0),
+			http.StatusBadGateway, CodeUpstream, ""},
+		{"unknown protocol", fmt.Errorf("%w: 'rsa'", service.ErrUnknownProtocol),
+			http.StatusBadRequest, CodeInvalidRequest, ""},
+		{"prover failure", errors.New("error generating salt: entropy source failed"),
+			http.StatusInternalServerError, CodeInternal, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			var got *jr.ErrorResp
+			RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				writeError(w, r, tt.err)
+			})).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/login", nil))
+
+			if rec.Code != tt.status {
+				t.Fatalf("got status %d, want %d", rec.Code, tt.status)
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+				t.Fatalf("invalid error body: %s", err.Error())
+			}
+			if got.Code != tt.code {
+				t.Fatalf("got code %s, want %s", got.Code, tt.code)
+			}
+			if got.RequestID == "" || got.RequestID != rec.Header().Get(RequestIDHeader) {
+				t.Fatalf("got request id '%s', header '%s'", got.RequestID, rec.Header().Get(RequestIDHeader))
+			}
+			if msg := status.Convert(tt.err).Message(); strings.Contains(got.Message, msg) || strings.Contains(got.Message, "'") {
+				t.Fatalf("error message leaked: %s", got.Message)
+			}
+			if ra := rec.Header().Get("Retry-After"); ra != tt.retryAfter {
+				t.Fatalf("got Retry-After '%s', want '%s'", ra, tt.retryAfter)
+			}
+		})
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		kept   bool
+	}{
+		{"none", "", false},
+		{"valid", "a1b2-c3d4_e5.f6", true},
+		{"invalid characters", "id\r\nSet-Cookie: x", false},
+		{"too long", strings.Repeat("a", maxRequestID+1), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var id string
+			req := httptest.NewRequest(http.MethodPost, "/login", nil)
+			req.Header.Set(RequestIDHeader, tt.header)
+			rec := httptest.NewRecorder()
+			RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				id = requestID(r)
+			})).ServeHTTP(rec, req)
+
+			if id == "" || rec.Header().Get(RequestIDHeader) != id {
+				t.Fatalf("got request id '%s', header '%s'", id, rec.Header().Get(RequestIDHeader))
+			}
+			if (id == tt.header) != tt.kept {
+				t.Fatalf("got request id '%s' for header '%s'", id, tt.header)
+			}
+		})
+	}
+}
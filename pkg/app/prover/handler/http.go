@@ -1,15 +1,26 @@
 package handler
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
-	"math/big"
+	"google.golang.org/grpc/metadata"
+	"io"
+	"net"
 	"net/http"
+	"unicode/utf8"
 	jr "zkp-api/pkg/app/prover/handler/request"
 	"zkp-api/pkg/app/prover/service"
+	"zkp-api/pkg/http/grpc"
 )
 
+// maxUserBody is the most of a request body read to find its user name.
+const maxUserBody = 64 << 10
+
 // AuthHandler is an HTTP handler that provides endpoints for user registration and login.
 // Auth is a reference to the service that performs the actual authentication logic.
+// Failures are answered with a JSON error body carrying an error code, a message and the id of the request,
+// errors of the verifier are mapped to their HTTP status and their messages are never returned.
 type AuthHandler struct {
 	Auth service.Auth
 }
@@ -23,27 +34,24 @@ func NewAuthHandler(auth service.Auth) *AuthHandler {
 }
 
 // RegisterUserHandler handles the HTTP request for registering a new user.
-// It decodes the request body into a RegisterReq struct, validates the password,
-// and calls the Register method of the Auth (prover) service.
+// It decodes the request body into a RegisterReq struct, validates the password, which can be any
+// non empty UTF-8 string, and calls the Register method of the Auth (prover) service.
 // Responds with an appropriate HTTP status code depending on the outcome of the operation.
 func (a *AuthHandler) RegisterUserHandler(w http.ResponseWriter, r *http.Request) {
 	req := &jr.RegisterReq{}
 	dec := json.NewDecoder(r.Body)
 	dec.DisallowUnknownFields()
 	if err := dec.Decode(req); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		writeAPIError(w, r, errInvalidBody, 0)
 		return
 	}
-	pwd, valid := new(big.Int).SetString(req.Password, 10)
-	if !valid {
-		w.WriteHeader(http.StatusBadRequest)
+	if req.Password == "" || !utf8.ValidString(req.Password) {
+		writeAPIError(w, r, errInvalidPassword, 0)
 		return
 	}
-	err := a.Auth.Register(req.UserName, pwd)
+	err := a.Auth.Register(clientContext(r), req.UserName, req.Protocol, req.Password)
 	if err != nil {
-		// note this could be either a Status Bad Request or a InternalError, for
-		// simplicity i've left out the custom errors from the design please refer to readme.
-		w.WriteHeader(http.StatusBadRequest)
+		writeError(w, r, err)
 		return
 	}
 
@@ -52,7 +60,7 @@ func (a *AuthHandler) RegisterUserHandler(w http.ResponseWriter, r *http.Request
 }
 
 // LoginUserHandler handles the HTTP request for logging in a user.
-// It decodes the request body into a LoginReq struct and calls the AuthenticationChallenge
+// It decodes the request body into a LoginReq struct, validates the password, and calls the AuthenticationChallenge
 // method of the Auth service to initiate the login process.
 // If successful, it returns the challenge in the response body, otherwise it responds
 // with an appropriate HTTP status code.
@@ -61,24 +69,117 @@ func (a *AuthHandler) LoginUserHandler(w http.ResponseWriter, r *http.Request) {
 	dec := json.NewDecoder(r.Body)
 	dec.DisallowUnknownFields()
 	if err := dec.Decode(req); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		writeAPIError(w, r, errInvalidBody, 0)
+		return
+	}
+	if req.Password == "" || !utf8.ValidString(req.Password) {
+		writeAPIError(w, r, errInvalidPassword, 0)
 		return
 	}
 
-	resp, err := a.Auth.AuthenticationChallenge(req.UserName)
+	resp, err := a.Auth.AuthenticationChallenge(clientContext(r), req.UserName, req.Password)
 	if err != nil {
-		// note this could be either a Status Bad Request or a InternalError, for
-		// simplicity i've left out the custom errors from the design please refer to readme.
-		w.WriteHeader(http.StatusBadRequest)
+		writeError(w, r, err)
+		return
+	}
+	writeSession(w, r, resp)
+}
+
+// ChangePasswordHandler handles the HTTP request for changing the password of a user.
+// It decodes the request body into a ChangePasswordReq struct, validates both passwords, which can be any
+// non empty UTF-8 strings, and calls the ChangePassword method of the Auth service.
+// Responds with an appropriate HTTP status code depending on the outcome of the operation.
+func (a *AuthHandler) ChangePasswordHandler(w http.ResponseWriter, r *http.Request) {
+	req := &jr.ChangePasswordReq{}
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(req); err != nil {
+		writeAPIError(w, r, errInvalidBody, 0)
 		return
 	}
+	for _, pwd := range []string{req.Password, req.NewPassword} {
+		if pwd == "" || !utf8.ValidString(pwd) {
+			writeAPIError(w, r, errInvalidPassword, 0)
+			return
+		}
+	}
+
+	if err := a.Auth.ChangePassword(clientContext(r), req.UserName, req.Password, req.NewPassword); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RefreshSessionHandler handles the HTTP request for refreshing a session.
+// It decodes the request body into a RefreshReq struct and calls the RefreshSession method of the Auth service
+// to exchange the refresh token for new tokens.
+// If successful, it returns the new tokens in the response body, otherwise it responds
+// with an appropriate HTTP status code.
+func (a *AuthHandler) RefreshSessionHandler(w http.ResponseWriter, r *http.Request) {
+	req := &jr.RefreshReq{}
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(req); err != nil {
+		writeAPIError(w, r, errInvalidBody, 0)
+		return
+	}
+	if req.RefreshToken == "" {
+		writeAPIError(w, r, apiError{http.StatusBadRequest, CodeInvalidRequest, "missing refresh token"}, 0)
+		return
+	}
+
+	resp, err := a.Auth.RefreshSession(clientContext(r), req.RefreshToken)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeSession(w, r, resp)
+}
+
+// UserName returns the user name of the JSON body of a request, empty if it has none, e.g: for the rate limiting
+// of each user. The body is left to be read again by the handler.
+func UserName(r *http.Request) string {
+	if r.Body == nil {
+		return ""
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxUserBody))
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+	if err != nil {
+		return ""
+	}
+	req := &struct {
+		UserName string `json:"userName"`
+	}{}
+	if err = json.Unmarshal(body, req); err != nil {
+		return ""
+	}
+	return req.UserName
+}
+
+// clientContext returns the context of a request carrying the address of its client, which the verifier counts
+// the failed attempts under when the prover is one of its trusted proxies.
+// note the address is taken from the connection and not from any header, since those can be set by the client.
+func clientContext(r *http.Request) context.Context {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.Context()
+	}
+	return metadata.AppendToOutgoingContext(r.Context(), grpc.ForwardedForKey, host)
+}
+
+// writeSession writes the tokens of a session as the JSON response body.
+func writeSession(w http.ResponseWriter, r *http.Request, s *service.Session) {
 	rBody := &jr.LoginResp{
-		resp,
+		SessionToken: s.Token,
+		RefreshToken: s.RefreshToken,
 	}
 	body, jsonErr := json.Marshal(rBody)
 	if jsonErr != nil {
-		// note should log error
-		w.WriteHeader(http.StatusInternalServerError)
+		writeError(w, r, jsonErr)
 		return
 	}
 
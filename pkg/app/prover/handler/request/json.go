@@ -2,13 +2,32 @@ package request
 
 type RegisterReq struct {
 	UserName string `json:"userName"`
-	Password string `json:"password"` // registration password
+	Password string `json:"password"`           // registration password
+	Protocol string `json:"protocol,omitempty"` // zkp backend, the prover default one if empty
 }
 
 type LoginReq struct {
 	UserName string `json:"userName"`
+	Password string `json:"password"` // used to compute the proof, never stored
 }
 
 type LoginResp struct {
-	SessionID string `json:"sessionID"` // login password
+	SessionToken string `json:"sessionToken"` // signed session token issued by the verifier
+	RefreshToken string `json:"refreshToken"` // single use token to get new tokens from /refresh
+}
+
+type ChangePasswordReq struct {
+	UserName    string `json:"userName"`
+	Password    string `json:"password"`    // current password, proven to the verifier
+	NewPassword string `json:"newPassword"` // password the new secret is derived from
+}
+
+type RefreshReq struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
+type ErrorResp struct {
+	Code      string `json:"code"`      // machine readable error code, e.g: USER_EXISTS
+	Message   string `json:"message"`   // human readable description of the error
+	RequestID string `json:"requestId"` // id of the request, also in its X-Request-Id header
 }
@@ -1,97 +1,273 @@
 package service
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"google.golang.org/grpc"
 	"log"
-	"math/big"
+	"math"
 	"zkp-api/pkg/app/prover/client"
-	"zkp-api/pkg/storage"
-	"zkp-api/pkg/storage/virtual"
+	pb "zkp-api/pkg/http/grpc/zkp"
 	"zkp-api/pkg/zkp"
 )
 
 // Prover is a structure that holds the necessary components to facilitate the zero-knowledge proof
-// based authentication process. It contains a storage to manage user data and a client to interact
-// with the authentication service.
+// based authentication process. It contains a client to interact with the authentication service,
+// the zkp backend new users are registered with by default and the KDF parameters their secrets are
+// derived from their passwords with, and whether logins use the single round non-interactive proof.
+// The prover is stateless: everything it needs to log a user in but the password is kept by the verifier,
+// and secrets are derived from the password given in each request and discarded once used.
 type Prover struct {
-	UsrStorage storage.ProverStorage // access to the storage
-	Client     client.Auth
+	Client   client.Auth
+	Protocol zkp.Protocol  // default zkp backend
+	KDF      zkp.KDFParams // KDF parameters for new users
+	// NonInteractive logins send the whole proof in a single Login call instead of answering a verifier challenge
+	NonInteractive bool
 }
 
-// NewServerProver initializes a new Prover instance with a gRPC connection and a virtual storage.
+// NewServerProver initializes a new Prover instance with a gRPC connection, the default zkp backend,
+// the KDF parameters for new users and whether logins are non-interactive.
 // It returns a pointer to the created Prover.
-func NewServerProver(conn *grpc.ClientConn) Auth {
+func NewServerProver(conn *grpc.ClientConn, protocol zkp.Protocol, kdf zkp.KDFParams, nonInteractive bool) Auth {
 	return &Prover{
-		Client:     client.NewAuthClient(conn),
-		UsrStorage: virtual.NewProverStorage(),
+		Client:         client.NewAuthClient(conn),
+		Protocol:       protocol,
+		KDF:            kdf,
+		NonInteractive: nonInteractive,
 	}
 }
 
 // Auth is an interface that defines the methods for user registration and authentication.
+// The context of each call is the one of the calls to the verifier, e.g: to forward the address of the client.
 type Auth interface {
-	Register(user string, password *big.Int) error
-	AuthenticationChallenge(user string) (string, error)
+	Register(ctx context.Context, user, protocol, password string) error
+	AuthenticationChallenge(ctx context.Context, user, password string) (*Session, error)
+	ChangePassword(ctx context.Context, user, password, newPassword string) error
+	RefreshSession(ctx context.Context, refreshToken string) (*Session, error)
 }
 
-// Register takes a username and a password (as a big integer) and registers a new user in the system.
-// It generates public commitments from the password and stores the user credentials.
-// Returns an error if registration fails.
-func (p *Prover) Register(user string, password *big.Int) error {
-	// from password and p.G, p.H generate public commitments => y1 & y2
-	y1, y2, err := zkp.GeneratePublicCommitments(password)
+// ErrUnknownProtocol is returned when registering a user with a zkp backend the prover does not know.
+var ErrUnknownProtocol = errors.New("unknown protocol")
+
+// Session holds the tokens issued by the verifier on a successful login or refresh.
+type Session struct {
+	Token        string // signed session token
+	RefreshToken string // single use refresh token
+}
+
+// Register takes a username, a zkp backend and a password and registers a new user in the system.
+// The secret is derived from the password with a new random salt, the public commitments are generated
+// from it and sent to the verifier along with the salt and the KDF parameters. Nothing is stored by the prover.
+// If no backend is given the default one is used.
+// Returns ErrUnknownProtocol if the backend is unknown or an error if registration fails.
+func (p *Prover) Register(ctx context.Context, user, protocol, password string) error {
+	zp := p.Protocol
+	if protocol != "" {
+		var err error
+		if zp, err = zkp.GetProtocol(protocol); err != nil {
+			err = fmt.Errorf("%w: '%s'", ErrUnknownProtocol, protocol)
+			log.Printf(err.Error())
+			return err
+		}
+	}
+	salt, err := zkp.NewSalt()
 	if err != nil {
-		// note just log the error since there's no proto schema for errors
 		log.Printf(err.Error())
 		return err
 	}
-
-	if err = p.Client.Register(user, y1, y2); err != nil {
+	x, err := zkp.DeriveSecret(password, salt, p.KDF)
+	if err != nil {
+		log.Printf(err.Error())
+		return err
+	}
+	defer clear(x)
+	// from the secret and the backend g, h generate public commitments => y1 & y2
+	y1, y2, err := zp.GeneratePublicCommitments(x)
+	if err != nil {
+		log.Printf(err.Error())
 		return err
 	}
 
-	if err = p.UsrStorage.AddUser(user, password.Bytes()); err != nil {
-		// note just log the error since there's no proto schema for errors
+	return p.Client.Register(ctx, user, zp.Name(), salt, p.KDF, y1, y2)
+}
+
+// AuthenticationChallenge initiates an authentication challenge for a user with the given password.
+// It retrieves the zkp backend, salt and KDF parameters from the verifier, derives the secret, generates random
+// commitments, and sends them to the authentication (verifier) service. The secret is discarded once the challenge is solved.
+// In non-interactive mode the challenge is computed from the login transcript and the proof sent in a single call instead.
+// Returns the session and refresh tokens if the authentication is successful, or an error if the process fails.
+func (p *Prover) AuthenticationChallenge(ctx context.Context, user, password string) (*Session, error) {
+	zp, nonce, x, err := p.currentSecret(ctx, user, password)
+	if err != nil {
+		return nil, err
+	}
+	// the secret only lives in memory for the duration of the login
+	defer clear(x)
+	// generate random k and produce 2 random commitments
+	r1, r2, k, err := zp.ProverCommitment()
+	if err != nil {
 		log.Printf(err.Error())
+		return nil, err
+	}
+	defer clear(k)
+	if p.NonInteractive {
+		return p.login(ctx, zp, user, nonce, x, r1, r2, k)
+	}
+	resp, err := p.Client.RequestAuthenticationChallenge(ctx, user, r1, r2)
+	if err != nil {
+		return nil, err
 	}
 
-	return nil
+	// solve the challenge c given by the verifier
+	s, err := zp.SolveChallenge(x, k, resp.GetC())
+	if err != nil {
+		log.Printf(err.Error())
+		return nil, err
+	}
+	authResp, err := p.Client.SendAuthentication(ctx, resp.GetAuthId(), s)
+	if err != nil {
+		log.Printf(err.Error())
+		return nil, err
+	}
+
+	return &Session{Token: authResp.GetSessionToken(), RefreshToken: authResp.GetRefreshToken()}, nil
 }
 
-// AuthenticationChallenge initiates an authentication challenge for a user.
-// It retrieves the user's password from storage, generates random commitments, and sends them to the authentication (verifier) service.
-// Returns a session ID if the authentication is successful, or an error if the process fails.
-func (p *Prover) AuthenticationChallenge(user string) (string, error) {
-	pwdB, err := p.UsrStorage.GetUser(user)
+// currentSecret retrieves the zkp backend, salt and KDF parameters of the user from the verifier and derives the secret
+// from the password with them.
+// Returns the backend, the nonce issued by the verifier and the secret, which the caller has to clear once used.
+func (p *Prover) currentSecret(ctx context.Context, user, password string) (zkp.Protocol, []byte, []byte, error) {
+	params, err := p.Client.GetLoginParameters(ctx, user)
+	if err != nil {
+		log.Printf(err.Error())
+		return nil, nil, nil, err
+	}
+	zp, err := zkp.GetProtocol(params.GetProtocol())
+	if err != nil {
+		log.Printf(err.Error())
+		return nil, nil, nil, err
+	}
+	kdf, err := kdfFromProto(params.GetKdf())
 	if err != nil {
-		// note just log the error since there's no proto schema for errors
 		log.Printf(err.Error())
-		return "", err
+		return nil, nil, nil, err
 	}
-	password := new(big.Int).SetBytes(pwdB)
-	// generate random r and produce 2 random commitments
-	r1, r2, r, err := zkp.ProverCommitment()
-	resp, err := p.Client.RequestAuthenticationChallenge(user, r1, r2)
+	x, err := zkp.DeriveSecret(password, params.GetSalt(), kdf)
+	if err != nil {
+		log.Printf(err.Error())
+		return nil, nil, nil, err
+	}
+	return zp, params.GetNonce(), x, nil
+}
 
-	fmt.Println(resp.GetAuthId())
-	fmt.Println(resp.GetC())
+// kdfFromProto converts the KDF parameters sent by the verifier, which are not trusted: parameters out of the bounds of
+// zkp.KDFParams.Validate are refused before any secret is derived with them.
+func kdfFromProto(k *pb.KDFParams) (zkp.KDFParams, error) {
+	if k.GetThreads() > math.MaxUint8 {
+		return zkp.KDFParams{}, fmt.Errorf("kdf threads must be between 1 and %d", zkp.MaxKDFThreads)
+	}
+	kdf := zkp.KDFParams{Time: k.GetTime(), Memory: k.GetMemory(), Threads: uint8(k.GetThreads())}
+	if err := kdf.Validate(); err != nil {
+		return zkp.KDFParams{}, fmt.Errorf("invalid kdf parameters of the user: %s", err.Error())
+	}
+	return kdf, nil
+}
+
+// login computes the challenge of the commitments r1, r2 from the login transcript, which needs the public commitments
+// recomputed from the secret x and the nonce issued by the verifier, and sends the whole proof to the verifier.
+// Returns the session and refresh tokens if the authentication is successful, or an error if the process fails.
+func (p *Prover) login(ctx context.Context, zp zkp.Protocol, user string, nonce, x, r1, r2, k []byte) (*Session, error) {
+	y1, y2, err := zp.GeneratePublicCommitments(x)
 	if err != nil {
-		return "", err
+		log.Printf(err.Error())
+		return nil, err
 	}
-	c := new(big.Int)
-	c.SetBytes(resp.GetC())
-	// solve the challenge c given by the verifier
-	s, err := zkp.SolveChallenge(password, r, c)
+	c, err := zkp.LoginChallenge(zp, user, nonce, y1, y2, r1, r2)
 	if err != nil {
-		// note just log the error since there's no proto schema for errors
 		log.Printf(err.Error())
+		return nil, err
 	}
-	authResp, err := p.Client.SendAuthentication(resp.GetAuthId(), s.Bytes())
+	s, err := zp.SolveChallenge(x, k, c)
 	if err != nil {
-		// note just log the error since there's no proto schema for errors
 		log.Printf(err.Error())
-		return "", err
+		return nil, err
 	}
+	resp, err := p.Client.Login(ctx, user, nonce, r1, r2, s)
+	if err != nil {
+		log.Printf(err.Error())
+		return nil, err
+	}
+
+	return &Session{Token: resp.GetSessionToken(), RefreshToken: resp.GetRefreshToken()}, nil
+}
+
+// RefreshSession exchanges a refresh token for a new session token and the next refresh token,
+// so that clients do not need the password to keep their session alive.
+// Returns the new tokens or an error if the refresh token is not valid.
+func (p *Prover) RefreshSession(ctx context.Context, refreshToken string) (*Session, error) {
+	resp, err := p.Client.RefreshSession(ctx, refreshToken)
+	if err != nil {
+		log.Printf(err.Error())
+		return nil, err
+	}
+	return &Session{Token: resp.GetSessionToken(), RefreshToken: resp.GetRefreshToken()}, nil
+}
 
-	return authResp.GetSessionId(), nil
+// ChangePassword replaces the password of a user. The new secret is derived from the new password with a new random salt
+// and the KDF parameters of the prover, so that changing the password also upgrades the KDF costs, and the verifier
+// replaces the public commitments once it has verified a proof of the current secret bound to the new credentials.
+// The user keeps the zkp backend it registered with. The verifier revokes every session of the user on success.
+// Returns an error if the current password is wrong or the process fails.
+func (p *Prover) ChangePassword(ctx context.Context, user, password, newPassword string) error {
+	zp, nonce, x, err := p.currentSecret(ctx, user, password)
+	if err != nil {
+		return err
+	}
+	defer clear(x)
+	y1, y2, err := zp.GeneratePublicCommitments(x)
+	if err != nil {
+		log.Printf(err.Error())
+		return err
+	}
+
+	salt, err := zkp.NewSalt()
+	if err != nil {
+		log.Printf(err.Error())
+		return err
+	}
+	newX, err := zkp.DeriveSecret(newPassword, salt, p.KDF)
+	if err != nil {
+		log.Printf(err.Error())
+		return err
+	}
+	defer clear(newX)
+	newY1, newY2, err := zp.GeneratePublicCommitments(newX)
+	if err != nil {
+		log.Printf(err.Error())
+		return err
+	}
+
+	// prove the current secret over a transcript bound to the new credentials
+	r1, r2, k, err := zp.ProverCommitment()
+	if err != nil {
+		log.Printf(err.Error())
+		return err
+	}
+	defer clear(k)
+	c, err := zkp.UpdateChallenge(zp, user, nonce, y1, y2, r1, r2, salt, p.KDF, newY1, newY2)
+	if err != nil {
+		log.Printf(err.Error())
+		return err
+	}
+	s, err := zp.SolveChallenge(x, k, c)
+	if err != nil {
+		log.Printf(err.Error())
+		return err
+	}
+	if err = p.Client.UpdateCommitments(ctx, user, nonce, salt, p.KDF, newY1, newY2, r1, r2, s); err != nil {
+		log.Printf(err.Error())
+		return err
+	}
+	return nil
 }
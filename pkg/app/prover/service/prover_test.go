@@ -0,0 +1,235 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"testing"
+	"time"
+	verifier "zkp-api/pkg/app/verifier/service"
+	pb "zkp-api/pkg/http/grpc/zkp"
+	"zkp-api/pkg/session"
+	"zkp-api/pkg/storage/virtual"
+	"zkp-api/pkg/zkp"
+)
+
+// testKDF keeps the derivations in the tests cheap.
+var testKDF = zkp.KDFParams{Time: 1, Memory: 64, Threads: 1}
+
+// testClient is the address the calls of the fake client come from.
+const testClient = "10.0.0.1"
+
+// fakeClient is a client.Auth calling a verifier service with in-memory storages instead of a remote verifier.
+type fakeClient struct {
+	v verifier.Auth
+	// params changes the login parameters returned by the verifier if set, e.g: to send parameters out of bounds
+	params func(*pb.LoginParametersResponse)
+}
+
+// newFakeClient returns a fake client of a new verifier allowing every backend.
+func newFakeClient(mode verifier.ChallengeMode) *fakeClient {
+	_, key, _ := ed25519.GenerateKey(nil)
+	protocols := make([]zkp.Protocol, 0)
+	for _, name := range zkp.Protocols() {
+		p, _ := zkp.GetProtocol(name)
+		protocols = append(protocols, p)
+	}
+	v := verifier.NewServerVerifier(virtual.NewVerifierStorage(), virtual.NewChallengeStorage(), virtual.NewNonceStorage(),
+		virtual.NewSessionStorage(), virtual.NewRefreshTokenStorage(), virtual.NewAttemptStorage(), verifier.Options{
+			Protocols: protocols,
+			Mode:      mode,
+			Sessions:  session.NewSigner(key, "zkp-api", time.Hour),
+		})
+	return &fakeClient{v: v}
+}
+
+func (f *fakeClient) Register(ctx context.Context, user, protocol string, salt []byte, kdf zkp.KDFParams, y1, y2 []byte) error {
+	return f.v.Register(ctx, user, protocol, salt, kdf, y1, y2)
+}
+
+func (f *fakeClient) GetLoginParameters(ctx context.Context, user string) (*pb.LoginParametersResponse, error) {
+	params, err := f.v.LoginParameters(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	resp := &pb.LoginParametersResponse{
+		Protocol: params.Protocol,
+		Salt:     params.Salt,
+		Kdf:      &pb.KDFParams{Time: params.KDF.Time, Memory: params.KDF.Memory, Threads: uint32(params.KDF.Threads)},
+		Nonce:    params.Nonce,
+	}
+	if f.params != nil {
+		f.params(resp)
+	}
+	return resp, nil
+}
+
+func (f *fakeClient) RequestAuthenticationChallenge(ctx context.Context, user string, r1, r2 []byte) (*pb.AuthenticationChallengeResponse, error) {
+	authID, c, err := f.v.CreateAuthenticationChallenge(ctx, testClient, user, r1, r2)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.AuthenticationChallengeResponse{AuthId: authID, C: c}, nil
+}
+
+func (f *fakeClient) SendAuthentication(ctx context.Context, authId string, s []byte) (*pb.AuthenticationAnswerResponse, error) {
+	tokens, err := f.v.VerifyAuthentication(ctx, testClient, authId, s)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.AuthenticationAnswerResponse{SessionToken: tokens.Session, RefreshToken: tokens.Refresh}, nil
+}
+
+func (f *fakeClient) Login(ctx context.Context, user string, nonce, r1, r2, s []byte) (*pb.LoginResponse, error) {
+	tokens, err := f.v.Login(ctx, testClient, user, nonce, r1, r2, s)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.LoginResponse{SessionToken: tokens.Session, RefreshToken: tokens.Refresh}, nil
+}
+
+func (f *fakeClient) UpdateCommitments(ctx context.Context, user string, nonce, salt []byte, kdf zkp.KDFParams, y1, y2, r1, r2, s []byte) error {
+	return f.v.UpdateCommitments(ctx, testClient, user, nonce, salt, kdf, y1, y2, r1, r2, s)
+}
+
+func (f *fakeClient) RefreshSession(ctx context.Context, refreshToken string) (*pb.RefreshSessionResponse, error) {
+	tokens, err := f.v.RefreshSession(ctx, refreshToken)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.RefreshSessionResponse{SessionToken: tokens.Session, RefreshToken: tokens.Refresh}, nil
+}
+
+// newTestProver returns a prover of the fake client registering users with the given backend.
+func newTestProver(f *fakeClient, protocol string, nonInteractive bool) *Prover {
+	zp, _ := zkp.GetProtocol(protocol)
+	return &Prover{Client: f, Protocol: zp, KDF: testKDF, NonInteractive: nonInteractive}
+}
+
+func TestLogin(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name           string
+		mode           verifier.ChallengeMode
+		nonInteractive bool
+	}{
+		{"interactive", verifier.Interactive, false},
+		{"non-interactive challenge", verifier.NonInteractive, false},
+		{"non-interactive login", verifier.Interactive, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeClient(tt.mode)
+			p := newTestProver(f, zkp.Ristretto255, tt.nonInteractive)
+			if err := p.Register(ctx, "jon", "", "correct horse battery staple"); err != nil {
+				t.Fatalf("unable to register: %s", err.Error())
+			}
+			sess, err := p.AuthenticationChallenge(ctx, "jon", "correct horse battery staple")
+			if err != nil {
+				t.Fatalf("unable to login: %s", err.Error())
+			}
+			if claims, err := f.v.ValidateSession(ctx, sess.Token); err != nil || claims.Subject != "jon" {
+				t.Fatalf("got claims %+v: %v", claims, err)
+			}
+			if sess.RefreshToken == "" {
+				t.Fatalf("no refresh token")
+			}
+			if _, err = p.AuthenticationChallenge(ctx, "jon", "wrong password"); !errors.Is(err, verifier.ErrInvalidProof) {
+				t.Fatalf("got error %v, want %v", err, verifier.ErrInvalidProof)
+			}
+		})
+	}
+}
+
+// TestStatelessLogin logs in with a prover that knows nothing about the user, e.g: another replica, whose KDF
+// parameters for new users differ from the ones the user registered with.
+func TestStatelessLogin(t *testing.T) {
+	ctx := context.Background()
+	f := newFakeClient(verifier.Interactive)
+	if err := newTestProver(f, zkp.P256, false).Register(ctx, "jon", "", "password of jon"); err != nil {
+		t.Fatalf("unable to register: %s", err.Error())
+	}
+	for _, nonInteractive := range []bool{false, true} {
+		p := newTestProver(f, zkp.Ristretto255, nonInteractive)
+		p.KDF = zkp.KDFParams{Time: 2, Memory: 128, Threads: 2}
+		if _, err := p.AuthenticationChallenge(ctx, "jon", "password of jon"); err != nil {
+			t.Fatalf("unable to login (non-interactive %v): %s", nonInteractive, err.Error())
+		}
+	}
+}
+
+func TestLoginKDFBounds(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name string
+		kdf  *pb.KDFParams
+	}{
+		{"missing", nil},
+		{"too much time", &pb.KDFParams{Time: zkp.MaxKDFTime + 1, Memory: 64, Threads: 1}},
+		{"too much memory", &pb.KDFParams{Time: 1, Memory: 0xFFFFFFFF, Threads: 1}},
+		{"too many threads", &pb.KDFParams{Time: 1, Memory: 1024, Threads: zkp.MaxKDFThreads + 1}},
+		// note threads are a single byte for Argon2id, 257 must not be truncated to 1
+		{"threads overflow", &pb.KDFParams{Time: 1, Memory: 64, Threads: 257}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeClient(verifier.Interactive)
+			p := newTestProver(f, zkp.Ristretto255, false)
+			if err := p.Register(ctx, "jon", "", "password of jon"); err != nil {
+				t.Fatalf("unable to register: %s", err.Error())
+			}
+			// the prover does not trust the parameters sent by the verifier
+			f.params = func(resp *pb.LoginParametersResponse) { resp.Kdf = tt.kdf }
+			if _, err := p.AuthenticationChallenge(ctx, "jon", "password of jon"); err == nil {
+				t.Fatalf("expected an error")
+			}
+		})
+	}
+}
+
+func TestChangePassword(t *testing.T) {
+	ctx := context.Background()
+	f := newFakeClient(verifier.Interactive)
+	p := newTestProver(f, zkp.Secp256k1, false)
+	if err := p.Register(ctx, "jon", "", "old password"); err != nil {
+		t.Fatalf("unable to register: %s", err.Error())
+	}
+	sess, err := p.AuthenticationChallenge(ctx, "jon", "old password")
+	if err != nil {
+		t.Fatalf("unable to login: %s", err.Error())
+	}
+	before, _ := f.v.LoginParameters(ctx, "jon")
+
+	if err = p.ChangePassword(ctx, "jon", "wrong password", "new password"); !errors.Is(err, verifier.ErrInvalidProof) {
+		t.Fatalf("got error %v, want %v", err, verifier.ErrInvalidProof)
+	}
+	// the KDF costs are upgraded to the ones of the prover along with the password
+	p.KDF = zkp.KDFParams{Time: 2, Memory: 128, Threads: 2}
+	if err = p.ChangePassword(ctx, "jon", "old password", "new password"); err != nil {
+		t.Fatalf("unable to change password: %s", err.Error())
+	}
+	after, _ := f.v.LoginParameters(ctx, "jon")
+	if after.KDF != p.KDF || bytes.Equal(after.Salt, before.Salt) || after.Protocol != zkp.Secp256k1 {
+		t.Fatalf("got login parameters %+v, want the KDF %+v with a new salt", after, p.KDF)
+	}
+
+	if _, err = f.v.ValidateSession(ctx, sess.Token); !errors.Is(err, verifier.ErrSessionRevoked) {
+		t.Fatalf("got error %v, want %v", err, verifier.ErrSessionRevoked)
+	}
+	if _, err = p.AuthenticationChallenge(ctx, "jon", "old password"); !errors.Is(err, verifier.ErrInvalidProof) {
+		t.Fatalf("got error %v, want %v", err, verifier.ErrInvalidProof)
+	}
+	for _, nonInteractive := range []bool{false, true} {
+		p.NonInteractive = nonInteractive
+		if _, err = p.AuthenticationChallenge(ctx, "jon", "new password"); err != nil {
+			t.Fatalf("unable to login with the new password (non-interactive %v): %s", nonInteractive, err.Error())
+		}
+	}
+
+	// the current secret is derived with parameters checked as for a login
+	f.params = func(resp *pb.LoginParametersResponse) { resp.Kdf.Memory = 0xFFFFFFFF }
+	if err = p.ChangePassword(ctx, "jon", "new password", "newer password"); err == nil {
+		t.Fatalf("expected an error")
+	}
+}
@@ -2,59 +2,345 @@ package handler
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
 	"log"
+	"math"
+	"net/netip"
+	"strings"
+	"time"
 	"zkp-api/pkg/app/verifier/service"
+	"zkp-api/pkg/http/grpc"
 	pb "zkp-api/pkg/http/grpc/zkp"
+	"zkp-api/pkg/session"
+	"zkp-api/pkg/storage"
+	"zkp-api/pkg/zkp"
 )
 
 // Verifier is a gRPC server handler that implements the AuthServer interface.
 // It provides methods to register users and to handle authentication challenges and verification.
+// The failed attempts are counted per client address, which is the peer address of the call or, when the peer is one
+// of the trusted proxies such as the prover, the address it forwards in the metadata.
 type Verifier struct {
-	AuthVerify service.Auth
+	AuthVerify     service.Auth
+	TrustedProxies []netip.Prefix
 	pb.UnimplementedAuthServer
 }
 
-// NewHandlerVerifier creates a new Verifier handler with a reference to an Auth service.
+// NewHandlerVerifier creates a new Verifier handler with a reference to an Auth service and the trusted proxies.
 // It returns a pointer to the created Verifier.
-func NewHandlerVerifier(av service.Auth) *Verifier {
+func NewHandlerVerifier(av service.Auth, trustedProxies []netip.Prefix) *Verifier {
 	return &Verifier{
-		AuthVerify: av,
+		AuthVerify:     av,
+		TrustedProxies: trustedProxies,
 	}
 }
 
+// ParseTrustedProxies parses the addresses and CIDRs of the trusted proxies, e.g: 127.0.0.1 or 172.16.0.0/12.
+// Returns an error if any of them is not valid.
+func ParseTrustedProxies(proxies []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(proxies))
+	for _, proxy := range proxies {
+		if addr, err := netip.ParseAddr(proxy); err == nil {
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy '%s': %v", proxy, err)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// ClientAddr returns the address of the client of a call, empty if unknown.
+// note only the last forwarded address is used since it is the one set by the trusted proxy, the previous ones
+// come from the client itself and can be spoofed.
+func (p *Verifier) ClientAddr(ctx context.Context) string {
+	pr, ok := peer.FromContext(ctx)
+	if !ok || pr.Addr == nil {
+		return ""
+	}
+	addrPort, err := netip.ParseAddrPort(pr.Addr.String())
+	if err != nil {
+		// note peers that are not ip based, e.g: unix sockets
+		return pr.Addr.String()
+	}
+	addr := addrPort.Addr().Unmap()
+	if !p.trusted(addr) {
+		return addr.String()
+	}
+	fwd := metadata.ValueFromIncomingContext(ctx, grpc.ForwardedForKey)
+	if len(fwd) == 0 {
+		return addr.String()
+	}
+	last := fwd[len(fwd)-1]
+	if i := strings.LastIndex(last, ","); i >= 0 {
+		last = last[i+1:]
+	}
+	client, err := netip.ParseAddr(strings.TrimSpace(last))
+	if err != nil {
+		log.Printf("invalid forwarded address '%s' from %s", last, addr)
+		return addr.String()
+	}
+	return client.Unmap().String()
+}
+
+// trusted returns whether an address belongs to a trusted proxy.
+func (p *Verifier) trusted(addr netip.Addr) bool {
+	for _, prefix := range p.TrustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
 // Register handles the gRPC call for registering a new user.
-// It receives a RegisterRequest containing the user's details and public commitments,
+// It receives a RegisterRequest containing the user's details, the zkp backend and public commitments,
 // and it delegates the registration logic to the Auth service.
 // Returns a RegisterResponse or an error if registration fails.
 func (p *Verifier) Register(ctx context.Context, in *pb.RegisterRequest) (*pb.RegisterResponse, error) {
-	err := p.AuthVerify.Register(in.GetUser(), in.GetY1(), in.GetY2())
+	err := p.AuthVerify.Register(ctx, in.GetUser(), in.GetProtocol(), in.GetSalt(), kdfFromProto(in.GetKdf()), in.GetY1(), in.GetY2())
 	if err != nil {
-		return nil, err
+		return nil, toStatus(err)
 	}
 	log.Printf("Received: %v", in.GetUser())
 	return &pb.RegisterResponse{}, nil
 }
 
+// GetLoginParameters handles the gRPC call that starts a login.
+// It receives a LoginParametersRequest with the user's name and returns the zkp backend, the salt and the KDF
+// parameters the user registered with, so that the prover can derive the secret from the password, and the nonce
+// of the non-interactive login.
+// Returns an error if the user does not exist.
+func (p *Verifier) GetLoginParameters(ctx context.Context, req *pb.LoginParametersRequest) (*pb.LoginParametersResponse, error) {
+	params, err := p.AuthVerify.LoginParameters(ctx, req.GetUser())
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &pb.LoginParametersResponse{
+		Protocol: params.Protocol,
+		Salt:     params.Salt,
+		Kdf:      kdfToProto(params.KDF),
+		Nonce:    params.Nonce,
+	}, nil
+}
+
 // CreateAuthenticationChallenge handles the gRPC call to create a new authentication challenge.
 // It receives an AuthenticationChallengeRequest with the user's details and random commitments,
 // and it delegates the challenge creation to the Auth service.
-// Returns an AuthenticationChallengeResponse containing the challenge or an error if the process fails.
+// Returns an AuthenticationChallengeResponse containing the auth id and the challenge or an error if the process fails.
 func (p *Verifier) CreateAuthenticationChallenge(ctx context.Context, req *pb.AuthenticationChallengeRequest) (*pb.AuthenticationChallengeResponse, error) {
-	respC, err := p.AuthVerify.CreateAuthenticationChallenge(req.GetUser(), req.GetR1(), req.GetR2())
+	authID, respC, err := p.AuthVerify.CreateAuthenticationChallenge(ctx, p.ClientAddr(ctx), req.GetUser(), req.GetR1(), req.GetR2())
 	if err != nil {
-		return nil, err
+		return nil, toStatus(err)
 	}
-	return &pb.AuthenticationChallengeResponse{AuthId: req.GetUser(), C: respC.Bytes()}, nil
+	return &pb.AuthenticationChallengeResponse{AuthId: authID, C: respC}, nil
 }
 
 // VerifyAuthentication handles the gRPC call to verify a user's authentication attempt.
 // It receives an AuthenticationAnswerRequest with the authentication ID and the user's solution,
 // and it delegates the verification to the Auth service.
-// Returns an AuthenticationAnswerResponse with a session ID if verification is successful, or an error if it fails.
+// Returns an AuthenticationAnswerResponse with the session and refresh tokens if verification is successful, or an error if it fails.
 func (p *Verifier) VerifyAuthentication(ctx context.Context, req *pb.AuthenticationAnswerRequest) (*pb.AuthenticationAnswerResponse, error) {
-	sessionID, err := p.AuthVerify.VerifyAuthentication(req.GetAuthId(), req.GetS())
+	tokens, err := p.AuthVerify.VerifyAuthentication(ctx, p.ClientAddr(ctx), req.GetAuthId(), req.GetS())
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &pb.AuthenticationAnswerResponse{SessionToken: tokens.Session, RefreshToken: tokens.Refresh}, nil
+}
+
+// Login handles the gRPC call of the non-interactive login.
+// It receives a LoginRequest with the user's name, the nonce of GetLoginParameters, the random commitments and the solution,
+// and it delegates the verification to the Auth service.
+// Returns a LoginResponse with the session and refresh tokens if verification is successful, or an error if it fails.
+func (p *Verifier) Login(ctx context.Context, req *pb.LoginRequest) (*pb.LoginResponse, error) {
+	tokens, err := p.AuthVerify.Login(ctx, p.ClientAddr(ctx), req.GetUser(), req.GetNonce(), req.GetR1(), req.GetR2(), req.GetS())
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &pb.LoginResponse{SessionToken: tokens.Session, RefreshToken: tokens.Refresh}, nil
+}
+
+// UpdateCommitments handles the gRPC call that rotates the credentials of a user.
+// It receives an UpdateCommitmentsRequest with the new salt, KDF parameters and public commitments along with the proof
+// of the current secret and its nonce, and it delegates the verification and update to the Auth service.
+// Returns an UpdateCommitmentsResponse or an error if the proof fails.
+func (p *Verifier) UpdateCommitments(ctx context.Context, req *pb.UpdateCommitmentsRequest) (*pb.UpdateCommitmentsResponse, error) {
+	err := p.AuthVerify.UpdateCommitments(ctx, p.ClientAddr(ctx), req.GetUser(), req.GetNonce(), req.GetSalt(), kdfFromProto(req.GetKdf()), req.GetY1(), req.GetY2(),
+		req.GetR1(), req.GetR2(), req.GetS())
 	if err != nil {
-		return nil, err
+		return nil, toStatus(err)
 	}
-	return &pb.AuthenticationAnswerResponse{SessionId: sessionID}, nil
+	return &pb.UpdateCommitmentsResponse{}, nil
+}
+
+// RefreshSession handles the gRPC call that exchanges a refresh token for new tokens.
+// It receives a RefreshSessionRequest with the refresh token and delegates the rotation to the Auth service.
+// Returns a RefreshSessionResponse with the new session and refresh tokens or an error if the refresh token is not valid.
+func (p *Verifier) RefreshSession(ctx context.Context, req *pb.RefreshSessionRequest) (*pb.RefreshSessionResponse, error) {
+	tokens, err := p.AuthVerify.RefreshSession(ctx, req.GetRefreshToken())
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &pb.RefreshSessionResponse{SessionToken: tokens.Session, RefreshToken: tokens.Refresh}, nil
+}
+
+// GetVerificationKey handles the gRPC call that publishes the public key of the session tokens,
+// so that other services can validate them offline.
+func (p *Verifier) GetVerificationKey(ctx context.Context, req *pb.VerificationKeyRequest) (*pb.VerificationKeyResponse, error) {
+	vk := p.AuthVerify.VerificationKey()
+	return &pb.VerificationKeyResponse{KeyId: vk.ID, Algorithm: vk.Algorithm, PublicKey: vk.PublicKey}, nil
+}
+
+// ValidateSession handles the gRPC call that introspects a session token.
+// It receives a ValidateSessionRequest with the token and delegates the validation to the Auth service.
+// Returns a ValidateSessionResponse with the claims of the token or an error if the token is not valid or its session was revoked.
+func (p *Verifier) ValidateSession(ctx context.Context, req *pb.ValidateSessionRequest) (*pb.ValidateSessionResponse, error) {
+	claims, err := p.AuthVerify.ValidateSession(ctx, req.GetSessionToken())
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &pb.ValidateSessionResponse{
+		User:      claims.Subject,
+		SessionId: claims.ID,
+		IssuedAt:  claims.IssuedAt,
+		ExpiresAt: claims.ExpiresAt,
+	}, nil
+}
+
+// RevokeSession handles the gRPC call that ends the session of a token.
+// Returns a RevokeSessionResponse or an error if the token is not valid.
+func (p *Verifier) RevokeSession(ctx context.Context, req *pb.RevokeSessionRequest) (*pb.RevokeSessionResponse, error) {
+	if err := p.AuthVerify.RevokeSession(ctx, req.GetSessionToken()); err != nil {
+		return nil, toStatus(err)
+	}
+	return &pb.RevokeSessionResponse{}, nil
+}
+
+// RevokeAllSessions handles the gRPC call that ends every session of the user of a session token.
+// Returns a RevokeAllSessionsResponse with the number of revoked sessions or an error if the token is not valid.
+func (p *Verifier) RevokeAllSessions(ctx context.Context, req *pb.RevokeAllSessionsRequest) (*pb.RevokeAllSessionsResponse, error) {
+	n, err := p.AuthVerify.RevokeAllSessions(ctx, req.GetSessionToken())
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &pb.RevokeAllSessionsResponse{Revoked: uint32(n)}, nil
+}
+
+// SetUserState handles the admin gRPC call that changes the lifecycle state of a user account.
+// It receives a SetUserStateRequest with the user's name and the new state and delegates the change to the Auth service.
+// Returns a SetUserStateResponse or an error if the state is unknown or the user does not exist.
+func (p *Verifier) SetUserState(ctx context.Context, req *pb.SetUserStateRequest) (*pb.SetUserStateResponse, error) {
+	state, ok := userStates[req.GetState()]
+	if !ok {
+		return nil, statusError(codes.InvalidArgument, fmt.Sprintf("unknown user state '%s'", req.GetState()),
+			grpc.ReasonInvalidArgument, 0)
+	}
+	if err := p.AuthVerify.SetUserState(ctx, req.GetUser(), state); err != nil {
+		return nil, toStatus(err)
+	}
+	return &pb.SetUserStateResponse{}, nil
+}
+
+// DeleteUser handles the admin gRPC call that deregisters a user.
+// Returns a DeleteUserResponse or an error if the user does not exist.
+func (p *Verifier) DeleteUser(ctx context.Context, req *pb.DeleteUserRequest) (*pb.DeleteUserResponse, error) {
+	if err := p.AuthVerify.DeleteUser(ctx, req.GetUser()); err != nil {
+		return nil, toStatus(err)
+	}
+	return &pb.DeleteUserResponse{}, nil
+}
+
+// userStates maps the user states of the requests to the ones of the storage.
+var userStates = map[pb.UserState]storage.UserState{
+	pb.UserState_USER_STATE_ACTIVE:   storage.UserActive,
+	pb.UserState_USER_STATE_LOCKED:   storage.UserLocked,
+	pb.UserState_USER_STATE_DISABLED: storage.UserDisabled,
+	pb.UserState_USER_STATE_PENDING:  storage.UserPending,
+}
+
+// statusErrors maps the errors of the Auth service to their gRPC status code and ErrorInfo reason.
+var statusErrors = []struct {
+	err    error
+	code   codes.Code
+	reason string
+}{
+	{service.ErrInvalidArgument, codes.InvalidArgument, grpc.ReasonInvalidArgument},
+	{service.ErrUserExists, codes.AlreadyExists, grpc.ReasonUserExists},
+	{service.ErrUserNotFound, codes.NotFound, grpc.ReasonUserNotFound},
+	{service.ErrUserNotActive, codes.FailedPrecondition, grpc.ReasonUserNotActive},
+	{service.ErrChallengeNotFound, codes.NotFound, grpc.ReasonChallengeNotFound},
+	{service.ErrChallengeExpired, codes.FailedPrecondition, grpc.ReasonChallengeExpired},
+	{service.ErrNoNonce, codes.FailedPrecondition, grpc.ReasonNoNonce},
+	{service.ErrInvalidProof, codes.Unauthenticated, grpc.ReasonInvalidProof},
+	{service.ErrLocked, codes.ResourceExhausted, grpc.ReasonLocked},
+	{service.ErrSessionRevoked, codes.Unauthenticated, grpc.ReasonInvalidSession},
+	{session.ErrInvalidToken, codes.Unauthenticated, grpc.ReasonInvalidSession},
+	{session.ErrTokenExpired, codes.Unauthenticated, grpc.ReasonInvalidSession},
+	{service.ErrInvalidRefreshToken, codes.Unauthenticated, grpc.ReasonInvalidRefreshToken},
+	{service.ErrRefreshTokenExpired, codes.Unauthenticated, grpc.ReasonInvalidRefreshToken},
+	{service.ErrRefreshTokenReused, codes.Unauthenticated, grpc.ReasonInvalidRefreshToken},
+}
+
+// toStatus converts the errors of the Auth service into a gRPC status with ErrorInfo details, and RetryInfo details
+// for lockouts. The errors of the context of the call, which the storages fail with once it is canceled or past its
+// deadline, keep their Canceled or DeadlineExceeded code. Errors that are not domain errors are internal errors whose
+// message is not returned to the caller, they are logged by the service.
+func toStatus(err error) error {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return status.FromContextError(err).Err()
+	}
+	for _, se := range statusErrors {
+		if !errors.Is(err, se.err) {
+			continue
+		}
+		var wait time.Duration
+		var locked *service.LockedError
+		if errors.As(err, &locked) {
+			wait = locked.Wait
+		}
+		return statusError(se.code, err.Error(), se.reason, wait)
+	}
+	return statusError(codes.Internal, "internal error", grpc.ReasonInternal, 0)
+}
+
+// statusError returns the error of a gRPC status with the ErrorInfo details of the reason,
+// and RetryInfo details if the caller has to wait before retrying.
+func statusError(code codes.Code, msg, reason string, wait time.Duration) error {
+	st := status.New(code, msg)
+	info := &errdetails.ErrorInfo{Reason: reason, Domain: grpc.ErrorDomain}
+	var err error
+	if wait > 0 {
+		st, err = st.WithDetails(info, &errdetails.RetryInfo{RetryDelay: durationpb.New(wait)})
+	} else {
+		st, err = st.WithDetails(info)
+	}
+	if err != nil {
+		return status.Error(code, msg)
+	}
+	return st.Err()
+}
+
+// kdfFromProto converts the KDF parameters of a request, missing parameters are left to zero so that they fail validation.
+// note threads over 255 are truncated to 0, which also fails validation.
+func kdfFromProto(k *pb.KDFParams) zkp.KDFParams {
+	threads := k.GetThreads()
+	if threads > math.MaxUint8 {
+		threads = 0
+	}
+	return zkp.KDFParams{Time: k.GetTime(), Memory: k.GetMemory(), Threads: uint8(threads)}
+}
+
+// kdfToProto converts KDF parameters into their message.
+func kdfToProto(k zkp.KDFParams) *pb.KDFParams {
+	return &pb.KDFParams{Time: k.Time, Memory: k.Memory, Threads: uint32(k.Threads)}
 }
@@ -0,0 +1,128 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"net"
+	"testing"
+	"time"
+	"zkp-api/pkg/app/verifier/service"
+	"zkp-api/pkg/http/grpc"
+	"zkp-api/pkg/session"
+)
+
+func TestParseTrustedProxies(t *testing.T) {
+	if _, err := ParseTrustedProxies([]string{"127.0.0.1", "::1", "172.16.0.0/12"}); err != nil {
+		t.Fatalf("unable to parse trusted proxies: %s", err.Error())
+	}
+	for _, proxy := range []string{"", "localhost", "10.0.0.0/33", "10.0.0.1:80"} {
+		if _, err := ParseTrustedProxies([]string{proxy}); err == nil {
+			t.Fatalf("expected error parsing '%s'", proxy)
+		}
+	}
+}
+
+func TestClientAddr(t *testing.T) {
+	proxies, _ := ParseTrustedProxies([]string{"127.0.0.1", "172.16.0.0/12"})
+	v := NewHandlerVerifier(nil, proxies)
+
+	tests := []struct {
+		name      string
+		peer      net.Addr
+		forwarded []string
+		want      string
+	}{
+		{"no peer", nil, nil, ""},
+		{"untrusted peer", &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 5000}, nil, "10.0.0.1"},
+		{"untrusted forward", &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 5000}, []string{"10.0.0.2"}, "10.0.0.1"},
+		{"trusted peer", &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 5000}, nil, "127.0.0.1"},
+		{"trusted forward", &net.TCPAddr{IP: net.ParseIP("172.18.0.3"), Port: 5000}, []string{"10.0.0.2"}, "10.0.0.2"},
+		{"last forward", &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 5000}, []string{"10.0.0.2", "10.0.0.3, 10.0.0.4"}, "10.0.0.4"},
+		{"invalid forward", &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 5000}, []string{"unknown"}, "127.0.0.1"},
+		{"mapped peer", &net.TCPAddr{IP: net.ParseIP("::ffff:10.0.0.1"), Port: 5000}, nil, "10.0.0.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.peer != nil {
+				ctx = peer.NewContext(ctx, &peer.Peer{Addr: tt.peer})
+			}
+			if tt.forwarded != nil {
+				ctx = metadata.NewIncomingContext(ctx, metadata.MD{grpc.ForwardedForKey: tt.forwarded})
+			}
+			if got := v.ClientAddr(ctx); got != tt.want {
+				t.Fatalf("got client '%s', want '%s'", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestToStatus(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		code   codes.Code
+		reason string // expected ErrorInfo reason, none if empty
+		msg    string // expected message, the one of the error if empty
+	}{
+		{"user exists", fmt.Errorf("%w: 'jon'", service.ErrUserExists), codes.AlreadyExists, grpc.ReasonUserExists, ""},
+		{"user not found", fmt.Errorf("%w: 'jon'", service.ErrUserNotFound), codes.NotFound, grpc.ReasonUserNotFound, ""},
+		{"invalid argument", fmt.Errorf("%w: short salt", service.ErrInvalidArgument), codes.InvalidArgument, grpc.ReasonInvalidArgument, ""},
+		{"not active", fmt.Errorf("%w: user 'jon' is locked", service.ErrUserNotActive), codes.FailedPrecondition, grpc.ReasonUserNotActive, ""},
+		{"challenge expired", service.ErrChallengeExpired, codes.FailedPrecondition, grpc.ReasonChallengeExpired, ""},
+		{"invalid proof", service.ErrInvalidProof, codes.Unauthenticated, grpc.ReasonInvalidProof, ""},
+		{"locked", &service.LockedError{Key: "user:jon", Wait: 2 * time.Second}, codes.ResourceExhausted, grpc.ReasonLocked, ""},
+		{"expired token", session.ErrTokenExpired, codes.Unauthenticated, grpc.ReasonInvalidSession, ""},
+		{"reused refresh token", service.ErrRefreshTokenReused, codes.Unauthenticated, grpc.ReasonInvalidRefreshToken, ""},
+		{"internal", errors.New("connection refused by 10.0.0.5"), codes.Internal, grpc.ReasonInternal, "internal error"},
+		{"canceled", context.Canceled, codes.Canceled, "", ""},
+		{"deadline exceeded", fmt.Errorf("user: %w", context.DeadlineExceeded), codes.DeadlineExceeded, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := status.Convert(toStatus(tt.err))
+			if st.Code() != tt.code {
+				t.Fatalf("got code %s, want %s", st.Code(), tt.code)
+			}
+			msg := tt.msg
+			if msg == "" {
+				msg = tt.err.Error()
+			}
+			if st.Message() != msg {
+				t.Fatalf("got message '%s', want '%s'", st.Message(), msg)
+			}
+			var info *errdetails.ErrorInfo
+			var retry *errdetails.RetryInfo
+			for _, d := range st.Details() {
+				switch d := d.(type) {
+				case *errdetails.ErrorInfo:
+					info = d
+				case *errdetails.RetryInfo:
+					retry = d
+				}
+			}
+			if tt.reason == "" {
+				if info != nil {
+					t.Fatalf("got error info %v, want none", info)
+				}
+				return
+			}
+			if info == nil || info.GetReason() != tt.reason || info.GetDomain() != grpc.ErrorDomain {
+				t.Fatalf("got error info %v, want reason %s", info, tt.reason)
+			}
+			var locked *service.LockedError
+			if errors.As(tt.err, &locked) != (retry != nil) {
+				t.Fatalf("got retry info %v", retry)
+			}
+			if retry != nil && retry.GetRetryDelay().AsDuration() != locked.Wait {
+				t.Fatalf("got retry delay %s, want %s", retry.GetRetryDelay().AsDuration(), locked.Wait)
+			}
+		})
+	}
+}
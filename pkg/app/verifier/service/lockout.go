@@ -0,0 +1,180 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+	"zkp-api/pkg/storage"
+)
+
+// ErrLocked is returned when a user or client is locked out after too many failed attempts.
+var ErrLocked = errors.New("too many failed attempts")
+
+// LockedError is the error of a locked out user or client, it wraps ErrLocked along with how long the lockout lasts.
+type LockedError struct {
+	Key  string        // key the failed attempts are counted under, e.g: user:jon
+	Wait time.Duration // time left until the next attempt is allowed
+}
+
+// Error returns the message of the lockout, e.g: too many failed attempts: user:jon locked for 2s.
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("%s: %s locked for %s", ErrLocked.Error(), e.Key, e.Wait.Round(time.Second))
+}
+
+// Unwrap returns ErrLocked, so that errors.Is matches every lockout.
+func (e *LockedError) Unwrap() error {
+	return ErrLocked
+}
+
+// LockoutPolicy configures the brute-force protection of the verifier. Failed attempts are counted per user and per
+// client address, once a counter reaches its limit every further failure doubles the time the user or client has to wait
+// before the next attempt, from BaseDelay up to MaxDelay.
+type LockoutPolicy struct {
+	UserAttempts   int           // failures allowed per user before the backoff starts, no limit if zero
+	ClientAttempts int           // failures allowed per client address before the backoff starts, no limit if zero
+	BaseDelay      time.Duration // lockout after the first failure over the limit
+	MaxDelay       time.Duration // longest lockout
+	Window         time.Duration // failures are forgotten once the last one is older than the window
+}
+
+// DefaultLockoutPolicy is the lockout policy used when none is configured.
+// note clients get a higher limit than users since a single address can be shared by many users.
+var DefaultLockoutPolicy = LockoutPolicy{
+	UserAttempts:   5,
+	ClientAttempts: 20,
+	BaseDelay:      time.Second,
+	MaxDelay:       15 * time.Minute,
+	Window:         time.Hour,
+}
+
+// lockedUntil returns until when the attempts under a key are locked out given the limit of failures,
+// a time in the past if they are not.
+func (p LockoutPolicy) lockedUntil(at *storage.AttemptData, limit int) time.Time {
+	if limit <= 0 || at.Failures < limit {
+		return time.Time{}
+	}
+	delay := p.MaxDelay
+	// note the shift is bounded so that it never overflows
+	if n := at.Failures - limit; n < 32 {
+		if d := p.BaseDelay << n; d > 0 && d < p.MaxDelay {
+			delay = d
+		}
+	}
+	return at.LastFailure.Add(delay)
+}
+
+// Retention is how long the attempts have to be kept after the last failure: the lockout can last longer than the window.
+func (p LockoutPolicy) Retention() time.Duration {
+	if p.MaxDelay > p.Window {
+		return p.MaxDelay
+	}
+	return p.Window
+}
+
+// attemptKey is a key the failed attempts are counted under, along with its limit of failures.
+type attemptKey struct {
+	key   string
+	limit int
+}
+
+// attemptKeys returns the keys the attempts of a user and of a client are counted under.
+func (v *AuthVerifier) attemptKeys(user, client string) []attemptKey {
+	keys := []attemptKey{{"user:" + user, v.Lockout.UserAttempts}}
+	if client != "" {
+		keys = append(keys, attemptKey{"client:" + client, v.Lockout.ClientAttempts})
+	}
+	return keys
+}
+
+// authAttempt is an authentication attempt of a user from a client, checked against the lockout then reserved before its
+// proof is verified.
+type authAttempt struct {
+	user     string
+	keys     []attemptKey
+	checked  []*storage.AttemptData // attempts of each key when the lockout was checked
+	reserved []*storage.AttemptData // attempts of each key once the attempt was counted
+}
+
+// checkLockout returns a LockedError if the user or the client are locked out, the attempt to reserve before verifying
+// its proof otherwise.
+func (v *AuthVerifier) checkLockout(ctx context.Context, user, client string) (*authAttempt, error) {
+	now := v.now()
+	a := &authAttempt{user: user, keys: v.attemptKeys(user, client)}
+	for _, k := range a.keys {
+		at, err := v.AtStorage.GetAttempts(ctx, k.key)
+		if err != nil {
+			log.Printf(err.Error())
+			return nil, err
+		}
+		if until := v.Lockout.lockedUntil(at, k.limit); now.Before(until) {
+			err = &LockedError{Key: k.key, Wait: until.Sub(now)}
+			log.Printf(err.Error())
+			return nil, err
+		}
+		a.checked = append(a.checked, at)
+	}
+	return a, nil
+}
+
+// reserve counts the attempt as a failure of the user and the client before its proof is verified, recordSuccess takes
+// it back. The failures returned atomically by the storage decide, so that concurrent attempts cannot all pass
+// checkLockout before any of them fails: every attempt goes through up to the limit, past it only the first failure
+// since the check does and the others get a LockedError.
+// note the failures are counted even if the call is canceled meanwhile, otherwise a client could dodge the lockout by
+// giving up on its calls as soon as the proof is checked.
+func (v *AuthVerifier) reserve(ctx context.Context, a *authAttempt) error {
+	ctx = context.WithoutCancel(ctx)
+	now := v.now()
+	var locked error
+	for i, k := range a.keys {
+		at, err := v.AtStorage.AddFailure(ctx, k.key, now, v.Lockout.Window)
+		if err != nil {
+			log.Printf(err.Error())
+			return err
+		}
+		a.reserved = append(a.reserved, at)
+		if k.limit > 0 && at.Failures > k.limit && at.Failures != a.checked[i].Failures+1 && locked == nil {
+			locked = &LockedError{Key: k.key, Wait: v.Lockout.lockedUntil(at, k.limit).Sub(now)}
+		}
+	}
+	if locked != nil {
+		log.Printf(locked.Error())
+	}
+	return locked
+}
+
+// recordFailure logs the lockouts the failure of a reserved attempt starts, its failures are already counted.
+func (v *AuthVerifier) recordFailure(a *authAttempt) {
+	now := v.now()
+	for i, k := range a.keys {
+		at := a.reserved[i]
+		if until := v.Lockout.lockedUntil(at, k.limit); now.Before(until) {
+			log.Printf("%d failed attempts, %s locked until %s", at.Failures, k.key, until.Format(time.RFC3339))
+		}
+	}
+}
+
+// recordSuccess forgets the failed attempts of the user once it authenticates and takes back the failure reserved for
+// the client.
+// note the other attempts of the client are kept, otherwise an attacker could reset them by logging in with its own account.
+func (v *AuthVerifier) recordSuccess(ctx context.Context, a *authAttempt) {
+	ctx = context.WithoutCancel(ctx)
+	if err := v.AtStorage.ResetAttempts(ctx, "user:"+a.user); err != nil {
+		log.Printf(err.Error())
+	}
+	for i, k := range a.keys[1:] {
+		if err := v.AtStorage.ReleaseFailure(ctx, k.key, a.reserved[i+1].LastFailure, a.checked[i+1].LastFailure); err != nil {
+			log.Printf(err.Error())
+		}
+	}
+}
+
+// ReapAttempts deletes the failed attempts of the storage that no longer count for the policy every interval
+// until the context is done.
+func ReapAttempts(ctx context.Context, atStorage storage.AttemptStorage, interval time.Duration, policy LockoutPolicy) {
+	reap(ctx, interval, "failed attempts", func(ctx context.Context, now time.Time) (int, error) {
+		return atStorage.DeleteStaleAttempts(ctx, now.Add(-policy.Retention()))
+	})
+}
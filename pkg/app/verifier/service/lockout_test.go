@@ -0,0 +1,127 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	"zkp-api/pkg/storage"
+	"zkp-api/pkg/zkp"
+)
+
+func TestLockedUntil(t *testing.T) {
+	p := LockoutPolicy{UserAttempts: 3, BaseDelay: time.Second, MaxDelay: 10 * time.Second}
+	last := time.Now()
+
+	tests := []struct {
+		name     string
+		failures int
+		limit    int
+		want     time.Duration // lockout after the last failure, zero if not locked
+	}{
+		{"under limit", 2, 3, 0},
+		{"at limit", 3, 3, time.Second},
+		{"doubled", 4, 3, 2 * time.Second},
+		{"doubled twice", 5, 3, 4 * time.Second},
+		{"capped", 8, 3, 10 * time.Second},
+		{"shift overflow", 100, 3, 10 * time.Second},
+		{"no limit", 100, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			until := p.lockedUntil(&storage.AttemptData{Failures: tt.failures, LastFailure: last}, tt.limit)
+			if tt.want == 0 {
+				if !until.IsZero() {
+					t.Fatalf("locked until %s", until)
+				}
+				return
+			}
+			if got := until.Sub(last); got != tt.want {
+				t.Fatalf("got lockout %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// attempt runs an interactive login of user from client with the secret x.
+func attempt(v Auth, zp zkp.Protocol, client, user string, x []byte) error {
+	ctx := context.Background()
+	r1, r2, k, err := zp.ProverCommitment()
+	if err != nil {
+		return err
+	}
+	authID, c, err := v.CreateAuthenticationChallenge(ctx, client, user, r1, r2)
+	if err != nil {
+		return err
+	}
+	s, err := zp.SolveChallenge(x, k, c)
+	if err != nil {
+		return err
+	}
+	_, err = v.VerifyAuthentication(ctx, client, authID, s)
+	return err
+}
+
+func TestReserveCanceled(t *testing.T) {
+	zp, _ := zkp.GetProtocol(zkp.Ristretto255)
+	v := newTestVerifier(zp, Interactive)
+	a, err := v.checkLockout(context.Background(), "jon", testClient)
+	if err != nil {
+		t.Fatalf("unable to check lockout: %s", err.Error())
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err = v.reserve(ctx, a); err != nil {
+		t.Fatalf("unable to reserve attempt: %s", err.Error())
+	}
+	for _, key := range []string{"user:jon", "client:" + testClient} {
+		at, err := v.AtStorage.GetAttempts(context.Background(), key)
+		if err != nil || at.Failures != 1 {
+			t.Fatalf("got attempts %+v of %s, want 1 failure: %v", at, key, err)
+		}
+	}
+}
+
+// TestConcurrentAnswersLockout answers many challenges opened ahead of time at once, only as many answers as the
+// lockout allows get their proof verified.
+func TestConcurrentAnswersLockout(t *testing.T) {
+	ctx := context.Background()
+	zp, _ := zkp.GetProtocol(zkp.Ristretto255)
+	v := newTestVerifier(zp, Interactive)
+	v.Lockout = LockoutPolicy{UserAttempts: 3, ClientAttempts: 100, BaseDelay: time.Minute, MaxDelay: time.Hour, Window: time.Hour}
+	register(t, v, zp, "jon")
+	wrong, _ := zkp.DeriveSecret("wrong password", make([]byte, 16), testKDF)
+
+	type answer struct {
+		authID string
+		s      []byte
+	}
+	answers := make([]answer, 16)
+	for i := range answers {
+		answers[i].authID, answers[i].s = challenge(t, v, zp, "jon", wrong)
+	}
+	var wg sync.WaitGroup
+	var verified atomic.Int32
+	start := make(chan struct{})
+	for _, a := range answers {
+		wg.Add(1)
+		go func(a answer) {
+			defer wg.Done()
+			<-start
+			_, err := v.VerifyAuthentication(ctx, testClient, a.authID, a.s)
+			switch {
+			case errors.Is(err, ErrInvalidProof):
+				verified.Add(1)
+			case !errors.Is(err, ErrLocked):
+				t.Errorf("got error %v, want %v", err, ErrLocked)
+			}
+		}(a)
+	}
+	close(start)
+	wg.Wait()
+	if n := verified.Load(); n != int32(v.Lockout.UserAttempts) {
+		t.Fatalf("%d proofs verified, want %d", n, v.Lockout.UserAttempts)
+	}
+}
@@ -1,109 +1,835 @@
 package service
 
 import (
+	"context"
+	"crypto/rand"
 	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"log"
-	"math/big"
+	"time"
+	"zkp-api/pkg/session"
 	"zkp-api/pkg/storage"
-	"zkp-api/pkg/storage/virtual"
 	"zkp-api/pkg/zkp"
 )
 
+// ChallengeMode selects how the verifier produces the challenges.
+type ChallengeMode string
+
+const (
+	// Interactive challenges are sampled uniformly at random by the verifier once it has received the prover commitments.
+	Interactive ChallengeMode = "interactive"
+	// NonInteractive challenges are derived from a transcript of the backend, the user, its commitments, the auth id and
+	// the prover commitments (Fiat–Shamir), so the prover can compute them on its own.
+	NonInteractive ChallengeMode = "non-interactive"
+)
+
+// ParseChallengeMode returns the challenge mode with the given name, Interactive if the name is empty.
+// Returns an error if the mode is unknown.
+func ParseChallengeMode(name string) (ChallengeMode, error) {
+	switch mode := ChallengeMode(name); mode {
+	case "":
+		return Interactive, nil
+	case Interactive, NonInteractive:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unknown challenge mode '%s'", name)
+	}
+}
+
+const (
+	// DefaultChallengeTTL is how long a challenge can be answered when no TTL is configured.
+	DefaultChallengeTTL = 30 * time.Second
+	// DefaultNonceTTL is how long a login nonce can be used when no TTL is configured, which covers the derivation
+	// of the secret by the prover.
+	DefaultNonceTTL = time.Minute
+	// DefaultRefreshTTL is how long a refresh token family can be used after login when no TTL is configured.
+	DefaultRefreshTTL = 30 * 24 * time.Hour
+)
+
+var (
+	// ErrInvalidArgument is returned when a request is not valid, e.g: an unsupported zkp backend or too short a salt.
+	ErrInvalidArgument = errors.New("invalid argument")
+	// ErrUserExists is returned when registering a user that is already registered.
+	ErrUserExists = errors.New("user already exists")
+	// ErrUserNotFound is returned when a user is not registered.
+	ErrUserNotFound = errors.New("user does not exist")
+	// ErrChallengeNotFound is returned when an auth id is unknown, e.g: its challenge was already answered.
+	ErrChallengeNotFound = errors.New("challenge does not exist")
+	// ErrNoNonce is returned when a non-interactive proof is sent with a nonce that was not issued to the user by
+	// LoginParameters, was already used or expired.
+	ErrNoNonce = errors.New("no nonce issued")
+	// ErrInvalidProof is returned when a proof does not verify.
+	ErrInvalidProof = errors.New("invalid proof")
+	// ErrChallengeExpired is returned when a challenge is answered after its TTL.
+	ErrChallengeExpired = errors.New("challenge expired")
+	// ErrSessionRevoked is returned when a session token is validly signed but its session is no longer active.
+	ErrSessionRevoked = errors.New("session revoked")
+	// ErrInvalidRefreshToken is returned when a refresh token is unknown, e.g: its family was revoked.
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	// ErrRefreshTokenExpired is returned when a refresh token is used after its expiry.
+	ErrRefreshTokenExpired = errors.New("refresh token expired")
+	// ErrRefreshTokenReused is returned when a refresh token is used twice, which revokes its family.
+	ErrRefreshTokenReused = errors.New("refresh token reused")
+	// ErrUserNotActive is returned when a user whose account is not active tries to authenticate.
+	ErrUserNotActive = errors.New("user is not active")
+)
+
+// Options holds the settings of the verifier.
+type Options struct {
+	Protocols    []zkp.Protocol // zkp backends users can register with
+	Mode         ChallengeMode  // how challenges are produced
+	ChallengeTTL time.Duration  // how long a challenge can be answered, DefaultChallengeTTL if zero
+	NonceTTL     time.Duration  // how long a login nonce can be used, DefaultNonceTTL if zero
+	Sessions     session.Issuer // issues the session tokens of successful authentications
+	RefreshTTL   time.Duration  // how long a refresh token family can be used after login, DefaultRefreshTTL if zero
+	Lockout      LockoutPolicy  // brute-force protection, DefaultLockoutPolicy if zero
+}
+
 // AuthVerifier is a structure that holds the necessary components to facilitate the zero-knowledge proof
-// based verification process. It contains a storage to manage user data.
+// based verification process. It contains a storage to manage user data, storages for the pending challenges, the login
+// nonces, the active sessions, the refresh tokens and the failed attempts, the zkp backends users are allowed to register
+// with, how challenges are produced and for how long they and the nonces are valid, the issuer of the session tokens and
+// the lockout policy.
 type AuthVerifier struct {
-	UsrStorage storage.VerifierStorage // access to the store
+	UsrStorage   storage.VerifierStorage     // access to the store
+	ChStorage    storage.ChallengeStorage    // pending challenges indexed by auth id
+	NcStorage    storage.NonceStorage        // login nonces indexed by nonce
+	SessStorage  storage.SessionStorage      // active sessions indexed by session id
+	RtStorage    storage.RefreshTokenStorage // refresh tokens indexed by hash
+	AtStorage    storage.AttemptStorage      // failed attempts indexed by user and client
+	Protocols    map[string]zkp.Protocol     // zkp backends indexed by name
+	Mode         ChallengeMode
+	ChallengeTTL time.Duration
+	NonceTTL     time.Duration
+	Sessions     session.Issuer
+	RefreshTTL   time.Duration
+	Lockout      LockoutPolicy
+	now          func() time.Time // clock, replaced in tests
 }
 
-// NewServerVerifier initializes a new AuthVerifier instance with a virtual storage.
+// NewServerVerifier initializes a new AuthVerifier instance with the user, challenge, nonce, session, refresh token and
+// failed attempt storages and the options.
 // It returns a pointer to the created AuthVerifier.
-func NewServerVerifier() Auth {
-	return &AuthVerifier{
-		UsrStorage: virtual.NewVerifierStorage(),
+func NewServerVerifier(usrStorage storage.VerifierStorage, chStorage storage.ChallengeStorage, ncStorage storage.NonceStorage,
+	sessStorage storage.SessionStorage, rtStorage storage.RefreshTokenStorage, atStorage storage.AttemptStorage,
+	opts Options) Auth {
+	av := &AuthVerifier{
+		UsrStorage:   usrStorage,
+		ChStorage:    chStorage,
+		NcStorage:    ncStorage,
+		SessStorage:  sessStorage,
+		RtStorage:    rtStorage,
+		AtStorage:    atStorage,
+		Protocols:    make(map[string]zkp.Protocol, len(opts.Protocols)),
+		Mode:         opts.Mode,
+		ChallengeTTL: opts.ChallengeTTL,
+		NonceTTL:     opts.NonceTTL,
+		Sessions:     opts.Sessions,
+		RefreshTTL:   opts.RefreshTTL,
+		Lockout:      opts.Lockout,
+		now:          time.Now,
+	}
+	if av.ChallengeTTL <= 0 {
+		av.ChallengeTTL = DefaultChallengeTTL
+	}
+	if av.NonceTTL <= 0 {
+		av.NonceTTL = DefaultNonceTTL
+	}
+	if av.RefreshTTL <= 0 {
+		av.RefreshTTL = DefaultRefreshTTL
+	}
+	if av.Lockout == (LockoutPolicy{}) {
+		av.Lockout = DefaultLockoutPolicy
+	}
+	for _, p := range opts.Protocols {
+		av.Protocols[p.Name()] = p
+	}
+	return av
+}
+
+// ReapChallenges deletes the expired challenges of the storage every interval until the context is done.
+// It is meant to run in its own goroutine, so that challenges that are never answered do not pile up.
+func ReapChallenges(ctx context.Context, chStorage storage.ChallengeStorage, interval time.Duration) {
+	reap(ctx, interval, "challenges", chStorage.DeleteExpiredChallenges)
+}
+
+// ReapNonces deletes the expired login nonces of the storage every interval until the context is done.
+// It is meant to run in its own goroutine, so that nonces that are never used do not pile up.
+func ReapNonces(ctx context.Context, ncStorage storage.NonceStorage, interval time.Duration) {
+	reap(ctx, interval, "login nonces", ncStorage.DeleteExpiredNonces)
+}
+
+// ReapSessions deletes the expired sessions of the storage every interval until the context is done.
+// It is meant to run in its own goroutine, so that sessions that are never revoked do not pile up.
+func ReapSessions(ctx context.Context, sessStorage storage.SessionStorage, interval time.Duration) {
+	reap(ctx, interval, "sessions", sessStorage.DeleteExpiredSessions)
+}
+
+// ReapRefreshTokens deletes the expired refresh tokens of the storage every interval until the context is done.
+// It is meant to run in its own goroutine, used refresh tokens are kept until they expire to detect their reuse.
+func ReapRefreshTokens(ctx context.Context, rtStorage storage.RefreshTokenStorage, interval time.Duration) {
+	reap(ctx, interval, "refresh tokens", rtStorage.DeleteExpiredRefreshTokens)
+}
+
+// reap calls deleteExpired every interval until the context is done, logging how many entries of what were deleted.
+func reap(ctx context.Context, interval time.Duration, what string, deleteExpired func(ctx context.Context, now time.Time) (int, error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			n, err := deleteExpired(ctx, now)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				log.Printf("error deleting expired %s: %s", what, err.Error())
+				continue
+			}
+			if n > 0 {
+				log.Printf("deleted %d expired %s", n, what)
+			}
+		}
 	}
 }
 
 // Auth is an interface that defines the methods for user registration and authentication verification.
+// The methods that reach the storages take the context of the call, they fail with its error once it is done.
 type Auth interface {
-	Register(user string, y1, y2 []byte) error
-	CreateAuthenticationChallenge(user string, r1, r2 []byte) (*big.Int, error)
-	VerifyAuthentication(authID string, solution []byte) (string, error)
+	Register(ctx context.Context, user, protocol string, salt []byte, kdf zkp.KDFParams, y1, y2 []byte) error
+	LoginParameters(ctx context.Context, user string) (*LoginParams, error)
+	CreateAuthenticationChallenge(ctx context.Context, client, user string, r1, r2 []byte) (authID string, c []byte, err error)
+	VerifyAuthentication(ctx context.Context, client, authID string, solution []byte) (*Tokens, error)
+	Login(ctx context.Context, client, user string, nonce, r1, r2, solution []byte) (*Tokens, error)
+	UpdateCommitments(ctx context.Context, client, user string, nonce, salt []byte, kdf zkp.KDFParams, y1, y2, r1, r2, solution []byte) error
+	RefreshSession(ctx context.Context, refreshToken string) (*Tokens, error)
+	VerificationKey() *session.VerificationKey
+	ValidateSession(ctx context.Context, token string) (*session.Claims, error)
+	RevokeSession(ctx context.Context, token string) error
+	RevokeAllSessions(ctx context.Context, token string) (int, error)
+	SetUserState(ctx context.Context, user string, state storage.UserState) error
+	DeleteUser(ctx context.Context, user string) error
+}
+
+// LoginParams is what the prover needs at login start: the zkp backend, salt and KDF parameters to derive
+// the secret from the password, and the single use nonce of the non-interactive login transcript.
+type LoginParams struct {
+	Protocol string
+	Salt     []byte
+	KDF      zkp.KDFParams
+	Nonce    []byte
 }
 
-// Register takes a username and public commitments (y1, y2) and registers a new user in the system.
-// It stores the user's public commitments in the storage.
-// Returns an error if registration fails.
-func (v *AuthVerifier) Register(user string, y1, y2 []byte) error {
+// Tokens are issued by a successful authentication or refresh: a short-lived session token and the single use
+// refresh token that can be exchanged for the next ones.
+type Tokens struct {
+	Session string
+	Refresh string
+}
+
+const (
+	// nonceSize is the size in bytes of the login nonces.
+	nonceSize = 32
+	// authIDSize is the size in bytes of the random auth ids.
+	authIDSize = 32
+	// refreshTokenSize is the size in bytes of the random refresh tokens.
+	refreshTokenSize = 32
+	// familyIDSize is the size in bytes of the random refresh token family ids.
+	familyIDSize = 16
+)
+
+// newAuthID returns a random auth id, unguessable and unrelated to the user it is issued to.
+func newAuthID() (string, error) {
+	return randomID(authIDSize, "auth id")
+}
+
+// randomID returns size random bytes encoded with base64url, what is used in the error message.
+func randomID(size int, what string) (string, error) {
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("error generating %s: %s", what, err.Error())
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// nonceKey returns the key a login nonce is stored under.
+func nonceKey(nonce []byte) string {
+	return base64.RawURLEncoding.EncodeToString(nonce)
+}
+
+// hashRefreshToken returns the hash refresh tokens are stored by, so that a leak of the storage does not leak them.
+func hashRefreshToken(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
+
+// getUser returns the data of a user.
+// Returns ErrUserNotFound if the user does not exist.
+func (v *AuthVerifier) getUser(ctx context.Context, user string) (*storage.VerifierUserData, error) {
+	usr, err := v.UsrStorage.GetUser(ctx, user)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			err = userNotFound(user)
+		}
+		log.Printf(err.Error())
+		return nil, err
+	}
+	return usr, nil
+}
+
+// userNotFound returns the ErrUserNotFound error of a user.
+func userNotFound(user string) error {
+	return fmt.Errorf("%w: '%s'", ErrUserNotFound, user)
+}
+
+// activeUser returns the data of a user whose account is active.
+// Returns ErrUserNotFound if the user does not exist or ErrUserNotActive if its account is not active.
+func (v *AuthVerifier) activeUser(ctx context.Context, user string) (*storage.VerifierUserData, error) {
+	usr, err := v.getUser(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	if usr.State != storage.UserActive {
+		err = fmt.Errorf("%w: user '%s' is %s", ErrUserNotActive, user, usr.State)
+		log.Printf(err.Error())
+		return nil, err
+	}
+	return usr, nil
+}
+
+// protocol returns the zkp backend registered under name.
+// Returns an error if the backend is unknown or not allowed by the verifier.
+func (v *AuthVerifier) protocol(name string) (zkp.Protocol, error) {
+	p, ok := v.Protocols[name]
+	if !ok {
+		return nil, fmt.Errorf("protocol '%s' is not supported", name)
+	}
+	return p, nil
+}
+
+// Register takes a username, the zkp backend, the salt and KDF parameters the secret was derived from the password with
+// and public commitments (y1, y2) and registers a new user in the system.
+// It stores the user's public commitments along with the backend and the KDF salt and parameters in the storage.
+// Returns ErrInvalidArgument if the backend, the salt or the KDF parameters are not valid, ErrUserExists if the user
+// is already registered or an error if registration fails.
+func (v *AuthVerifier) Register(ctx context.Context, user, protocol string, salt []byte, kdf zkp.KDFParams, y1, y2 []byte) error {
+	if _, err := v.protocol(protocol); err != nil {
+		err = fmt.Errorf("%w: %s", ErrInvalidArgument, err.Error())
+		log.Printf(err.Error())
+		return err
+	}
+	if err := validateCredentials(salt, kdf); err != nil {
+		return err
+	}
 	// add public commitments of the user in storage
-	if err := v.UsrStorage.AddUser(user, y1, y2); err != nil {
-		// note just log the error since there's no proto schema for errors
+	usr := &storage.VerifierUserData{
+		State:    storage.UserActive,
+		Protocol: protocol,
+		Salt:     salt,
+		KDF:      kdf,
+		Y1:       y1,
+		Y2:       y2,
+	}
+	if err := v.UsrStorage.AddUser(ctx, user, usr); err != nil {
+		if errors.Is(err, storage.ErrExists) {
+			err = fmt.Errorf("%w: '%s'", ErrUserExists, user)
+		}
+		log.Printf(err.Error())
+		return err
+	}
+	return nil
+}
+
+// validateCredentials checks the salt and KDF parameters the secret of a user is derived with.
+// Returns ErrInvalidArgument if they are not valid.
+func validateCredentials(salt []byte, kdf zkp.KDFParams) error {
+	if len(salt) < zkp.SaltSize {
+		err := fmt.Errorf("%w: salt must be at least %d bytes long", ErrInvalidArgument, zkp.SaltSize)
+		log.Printf(err.Error())
+		return err
+	}
+	if err := kdf.Validate(); err != nil {
+		err = fmt.Errorf("%w: %s", ErrInvalidArgument, err.Error())
 		log.Printf(err.Error())
 		return err
 	}
 	return nil
 }
 
-// CreateAuthenticationChallenge generates a challenge for the user based on random commitments (r1, r2).
-// It checks if the user exists and updates the user's challenge and random values in the storage.
-// Returns the generated challenge as a big integer or an error if the process fails.
-func (v *AuthVerifier) CreateAuthenticationChallenge(user string, r1, r2 []byte) (*big.Int, error) {
-	if exist, err := v.UsrStorage.CheckUser(user); err != nil || !exist {
-		if err == nil {
-			err = fmt.Errorf("user '%s' does not exist", user)
+// LoginParameters returns the zkp backend, the salt and the KDF parameters a user registered with, which is what the
+// prover needs to derive the secret from the password at login start, none of them are secret.
+// It also issues a new single use nonce for the non-interactive login, valid for the nonce TTL. Every call issues its own
+// nonce, so that concurrent logins of a user do not invalidate each other's.
+// Returns ErrUserNotFound if the user does not exist.
+func (v *AuthVerifier) LoginParameters(ctx context.Context, user string) (*LoginParams, error) {
+	usr, err := v.getUser(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, nonceSize)
+	if _, err = rand.Read(nonce); err != nil {
+		err = fmt.Errorf("error generating nonce: %s", err.Error())
+		log.Printf(err.Error())
+		return nil, err
+	}
+	n := &storage.NonceData{User: user, CreatedAt: v.now(), TTL: v.NonceTTL}
+	if err = v.NcStorage.AddNonce(ctx, nonceKey(nonce), n); err != nil {
+		log.Printf(err.Error())
+		return nil, err
+	}
+	return &LoginParams{
+		Protocol: usr.Protocol,
+		Salt:     usr.Salt,
+		KDF:      usr.KDF,
+		Nonce:    nonce,
+	}, nil
+}
+
+// CreateAuthenticationChallenge generates a challenge for the user once it has sent its random commitments (r1, r2).
+// It checks if the user exists and stores the challenge and random values as a pending challenge under a new random
+// auth id, so that several logins of the same user can be in progress at once.
+// The challenge is generated with the zkp backend the user registered with, at random in interactive mode
+// or from the pending challenge transcript in non-interactive mode. The client is the address the request comes from.
+// Returns the auth id and the generated challenge, ErrLocked if the user or the client are locked out
+// or an error if the process fails.
+func (v *AuthVerifier) CreateAuthenticationChallenge(ctx context.Context, client, user string, r1, r2 []byte) (string, []byte, error) {
+	usr, err := v.activeUser(ctx, user)
+	if err != nil {
+		return "", nil, err
+	}
+	if _, err = v.checkLockout(ctx, user, client); err != nil {
+		return "", nil, err
+	}
+	zp, err := v.protocol(usr.Protocol)
+	if err != nil {
+		log.Printf(err.Error())
+		return "", nil, err
+	}
+
+	authID, err := newAuthID()
+	if err != nil {
+		log.Printf(err.Error())
+		return "", nil, err
+	}
+	var c []byte
+	switch v.Mode {
+	case NonInteractive:
+		// c is bound to the backend, the user and its commitments, and to the auth id as a single use nonce
+		c, err = zkp.PendingChallenge(zp, user, authID, usr.Y1, usr.Y2, r1, r2)
+	default:
+		// c must not be predictable by the prover before it commits to r1,r2
+		c, err = zp.RandomChallenge()
+	}
+	if err != nil {
+		err = fmt.Errorf("error generating challenge: %s", err.Error())
+		log.Printf(err.Error())
+		return "", nil, err
+	}
+	ch := &storage.ChallengeData{
+		User:      user,
+		R1:        r1,
+		R2:        r2,
+		C:         c,
+		CreatedAt: v.now(),
+		TTL:       v.ChallengeTTL,
+	}
+	if err = v.ChStorage.BeginChallenge(ctx, authID, ch); err != nil {
+		log.Printf(err.Error())
+		return "", nil, err
+	}
+
+	return authID, c, nil
+}
+
+// VerifyAuthentication takes an authentication ID and a solution (as a byte slice) and verifies the solution against the stored challenge.
+// It takes the pending challenge out of the storage, so that it is answered at most once whether the verification
+// succeeds or not, then verifies the solution with the public commitments and zkp backend of the challenge's user.
+// A failed verification counts against the user and the client, a successful one resets the failures of the user.
+// Returns the session and refresh tokens, ErrChallengeNotFound if the auth id is unknown, ErrChallengeExpired if the
+// challenge is past its TTL, ErrLocked if the user or the client are locked out or ErrInvalidProof if the verification fails.
+func (v *AuthVerifier) VerifyAuthentication(ctx context.Context, client, authID string, solution []byte) (*Tokens, error) {
+	ch, err := v.ChStorage.ConsumeChallenge(ctx, authID)
+	if err != nil {
+		log.Printf(err.Error())
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, ErrChallengeNotFound
 		}
+		return nil, err
+	}
+	if ch.Expired(v.now()) {
+		log.Printf("%s: auth id %s of user '%s'", ErrChallengeExpired.Error(), authID, ch.User)
+		return nil, ErrChallengeExpired
+	}
+	usr, err := v.activeUser(ctx, ch.User)
+	if err != nil {
+		return nil, err
+	}
+	a, err := v.checkLockout(ctx, ch.User, client)
+	if err != nil {
+		return nil, err
+	}
+	zp, err := v.protocol(usr.Protocol)
+	if err != nil {
+		log.Printf(err.Error())
+		return nil, err
+	}
+
+	// verify prover solution
+	if err = v.reserve(ctx, a); err != nil {
+		return nil, err
+	}
+	if correct := zp.Verify(usr.Y1, usr.Y2, ch.R1, ch.R2, solution, ch.C); !correct {
+		v.recordFailure(a)
+		log.Printf("%s: auth id %s of user '%s'", ErrInvalidProof.Error(), authID, ch.User)
+		return nil, ErrInvalidProof
+	}
+	v.recordSuccess(ctx, a)
+
+	return v.login(ctx, ch.User)
+}
+
+// Login verifies a whole non-interactive proof (r1, r2, s) of a user in a single call. The challenge is derived from the
+// login transcript, which binds the user's backend and public commitments, the user name and a nonce issued to the user
+// by LoginParameters. The nonce is consumed by the first attempt whether it succeeds or not, so a proof cannot be replayed.
+// Failures are counted as in VerifyAuthentication.
+// Returns the session and refresh tokens, ErrNoNonce if the nonce was not issued to the user, was already used or expired,
+// ErrLocked if the user or the client are locked out or ErrInvalidProof if the verification fails.
+func (v *AuthVerifier) Login(ctx context.Context, client, user string, nonce, r1, r2, solution []byte) (*Tokens, error) {
+	usr, err := v.activeUser(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	a, err := v.checkLockout(ctx, user, client)
+	if err != nil {
+		return nil, err
+	}
+	zp, err := v.protocol(usr.Protocol)
+	if err != nil {
 		log.Printf(err.Error())
 		return nil, err
 	}
+	if err = v.consumeNonce(ctx, user, nonce); err != nil {
+		return nil, err
+	}
 
-	// from received r1,r2 using zkp generate C challenge
-	c := zkp.GenerateChallenge(r1, r2)
-	if c == nil {
-		err := fmt.Errorf("error generating challenge")
+	c, err := zkp.LoginChallenge(zp, user, nonce, usr.Y1, usr.Y2, r1, r2)
+	if err != nil {
+		err = fmt.Errorf("error generating challenge: %s", err.Error())
 		log.Printf(err.Error())
+		return nil, err
+	}
+	if err = v.reserve(ctx, a); err != nil {
+		return nil, err
+	}
+	if correct := zp.Verify(usr.Y1, usr.Y2, r1, r2, solution, c); !correct {
+		v.recordFailure(a)
+		log.Printf("%s: login of user '%s'", ErrInvalidProof.Error(), user)
+		return nil, ErrInvalidProof
 	}
+	v.recordSuccess(ctx, a)
 
-	if err := v.UsrStorage.UpdateUserChallenge(user, c.Bytes()); err != nil {
-		// note just log the error since there's no proto schema for errors
+	return v.login(ctx, user)
+}
+
+// UpdateCommitments replaces the salt, KDF parameters and public commitments of a user, e.g: when the password changes.
+// It is authorized by a non-interactive proof (r1, r2, s) of knowledge of the current secret, whose challenge is derived
+// from the update transcript binding the new credentials and a nonce issued to the user by LoginParameters. As in Login,
+// the nonce is consumed by the first attempt. Every session and refresh token of the user is revoked once the
+// credentials are replaced, so a leaked password stops being useful as soon as it is changed.
+// Failures are counted as in VerifyAuthentication.
+// Returns ErrInvalidArgument if the new credentials are not valid, ErrNoNonce if the nonce was not issued to the user,
+// was already used or expired, ErrLocked if the user or the client are locked out or ErrInvalidProof if the proof fails.
+func (v *AuthVerifier) UpdateCommitments(ctx context.Context, client, user string, nonce, salt []byte, kdf zkp.KDFParams, y1, y2, r1, r2, solution []byte) error {
+	if err := validateCredentials(salt, kdf); err != nil {
+		return err
+	}
+	usr, err := v.activeUser(ctx, user)
+	if err != nil {
+		return err
+	}
+	a, err := v.checkLockout(ctx, user, client)
+	if err != nil {
+		return err
+	}
+	zp, err := v.protocol(usr.Protocol)
+	if err != nil {
+		log.Printf(err.Error())
+		return err
+	}
+	if err = v.consumeNonce(ctx, user, nonce); err != nil {
+		return err
+	}
+
+	c, err := zkp.UpdateChallenge(zp, user, nonce, usr.Y1, usr.Y2, r1, r2, salt, kdf, y1, y2)
+	if err != nil {
+		err = fmt.Errorf("error generating challenge: %s", err.Error())
+		log.Printf(err.Error())
+		return err
+	}
+	if err = v.reserve(ctx, a); err != nil {
+		return err
+	}
+	if correct := zp.Verify(usr.Y1, usr.Y2, r1, r2, solution, c); !correct {
+		v.recordFailure(a)
+		log.Printf("%s: commitments update of user '%s'", ErrInvalidProof.Error(), user)
+		return ErrInvalidProof
+	}
+	v.recordSuccess(ctx, a)
+
+	if err = v.UsrStorage.UpdateUserCommitments(ctx, user, salt, kdf, y1, y2); err != nil {
+		log.Printf(err.Error())
+		return err
+	}
+	log.Printf("commitments of user '%s' updated", user)
+	// note once the credentials are replaced the sessions are revoked even if the call is canceled meanwhile
+	_, err = v.revokeUserSessions(context.WithoutCancel(ctx), user)
+	return err
+}
+
+// consumeNonce takes a login nonce of user out of the storage, so that it is used at most once whether the proof it is
+// bound to verifies or not.
+// Returns ErrNoNonce if the nonce was not issued to the user, was already used or expired.
+func (v *AuthVerifier) consumeNonce(ctx context.Context, user string, nonce []byte) error {
+	if len(nonce) == 0 {
+		err := fmt.Errorf("%w: user '%s'", ErrNoNonce, user)
+		log.Printf(err.Error())
+		return err
+	}
+	n, err := v.NcStorage.ConsumeNonce(ctx, nonceKey(nonce))
+	if err != nil {
+		log.Printf(err.Error())
+		if errors.Is(err, storage.ErrNotFound) {
+			return fmt.Errorf("%w: user '%s'", ErrNoNonce, user)
+		}
+		return err
+	}
+	if n.User != user {
+		err = fmt.Errorf("%w: nonce of user '%s' sent by '%s'", ErrNoNonce, n.User, user)
+		log.Printf(err.Error())
+		return err
+	}
+	if n.Expired(v.now()) {
+		err = fmt.Errorf("%w: nonce of user '%s' expired", ErrNoNonce, user)
+		log.Printf(err.Error())
+		return err
+	}
+	return nil
+}
+
+// login issues the tokens of a successful authentication of user, starting a new refresh token family.
+func (v *AuthVerifier) login(ctx context.Context, user string) (*Tokens, error) {
+	family, err := randomID(familyIDSize, "refresh token family")
+	if err != nil {
 		log.Printf(err.Error())
 		return nil, err
 	}
-	if err := v.UsrStorage.UpdateUserRand(user, r1, r2); err != nil {
-		// note just log the error since there's no proto schema for errors
+	return v.issueTokens(ctx, user, family, v.now().Add(v.RefreshTTL))
+}
+
+// issueTokens issues a session token of user and the next refresh token of the family, valid until expiresAt.
+func (v *AuthVerifier) issueTokens(ctx context.Context, user, family string, expiresAt time.Time) (*Tokens, error) {
+	token, err := v.newSession(ctx, user, family)
+	if err != nil {
+		return nil, err
+	}
+	refresh, err := randomID(refreshTokenSize, "refresh token")
+	if err != nil {
+		log.Printf(err.Error())
+		return nil, err
+	}
+	rt := &storage.RefreshTokenData{
+		Hash:      hashRefreshToken(refresh),
+		Family:    family,
+		User:      user,
+		ExpiresAt: expiresAt,
+	}
+	if err = v.RtStorage.AddRefreshToken(ctx, rt); err != nil {
 		log.Printf(err.Error())
 		return nil, err
 	}
+	return &Tokens{Session: token, Refresh: refresh}, nil
+}
 
-	return c, nil
+// RefreshSession exchanges a refresh token for a new session token and the next refresh token of its family,
+// which keeps the expiry of the family. Every refresh token can only be used once: using it again means it leaked,
+// so the whole family is revoked along with the sessions issued with it and the user has to authenticate again.
+// Returns the new tokens, ErrInvalidRefreshToken, ErrRefreshTokenExpired or ErrRefreshTokenReused.
+func (v *AuthVerifier) RefreshSession(ctx context.Context, refreshToken string) (*Tokens, error) {
+	rt, err := v.RtStorage.UseRefreshToken(ctx, hashRefreshToken(refreshToken))
+	if err != nil {
+		log.Printf(err.Error())
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, ErrInvalidRefreshToken
+		}
+		return nil, err
+	}
+	if rt.Expired(v.now()) {
+		log.Printf("%s: family %s of user '%s'", ErrRefreshTokenExpired.Error(), rt.Family, rt.User)
+		return nil, ErrRefreshTokenExpired
+	}
+	if rt.Used {
+		// note the revocation is not canceled along with the call, a leaked family must not outlive its detection
+		revokeCtx := context.WithoutCancel(ctx)
+		n, err := v.RtStorage.DeleteRefreshFamily(revokeCtx, rt.Family)
+		if err != nil {
+			log.Printf(err.Error())
+		}
+		sessions, err := v.SessStorage.DeleteFamilySessions(revokeCtx, rt.Family)
+		if err != nil {
+			log.Printf(err.Error())
+		}
+		log.Printf("%s: family %s of user '%s' revoked, %d refresh tokens and %d sessions deleted", ErrRefreshTokenReused.Error(),
+			rt.Family, rt.User, n, sessions)
+		return nil, ErrRefreshTokenReused
+	}
+	if _, err = v.activeUser(ctx, rt.User); err != nil {
+		return nil, err
+	}
+	return v.issueTokens(ctx, rt.User, rt.Family, rt.ExpiresAt)
 }
 
-// VerifyAuthentication takes an authentication ID and a solution (as a byte slice) and verifies the solution against the stored challenge.
-// It retrieves the user's data using the authentication ID, verifies the solution, and returns an authentication result.
-// Returns a success message or an error if the verification fails.
-func (v *AuthVerifier) VerifyAuthentication(authID string, solution []byte) (string, error) {
-	usr, err := v.UsrStorage.GetUser(authID)
+// newSession issues the session token of a successful authentication of user and stores its session as active
+// along with the refresh token family it was issued with.
+func (v *AuthVerifier) newSession(ctx context.Context, user, family string) (string, error) {
+	token, claims, err := v.Sessions.Issue(user)
 	if err != nil {
-		// not just log the error since there's no proto schema for errors
 		log.Printf(err.Error())
 		return "", err
 	}
-
-	s := new(big.Int)
-	s.SetBytes(solution)
-	c := new(big.Int)
-	c.SetBytes(usr.C)
-	// verify prover solution
-	if correct := zkp.Verify(usr.Y1, usr.Y2, usr.R1, usr.R2, s, c); !correct {
-		// note just log the error since there's no proto schema for errors
-		err = fmt.Errorf("error verifiying the solution")
+	sess := &storage.SessionData{
+		ID:        claims.ID,
+		User:      user,
+		Family:    family,
+		IssuedAt:  time.Unix(claims.IssuedAt, 0),
+		ExpiresAt: time.Unix(claims.ExpiresAt, 0),
+	}
+	if err = v.SessStorage.AddSession(ctx, sess); err != nil {
 		log.Printf(err.Error())
 		return "", err
 	}
+	log.Printf("session %s issued to user '%s'", claims.ID, user)
+	return token, nil
+}
 
-	// For the POC, we just concatenate s and c and hash them
-	combined := append(s.Bytes(), c.Bytes()...)
-	hash := sha256.Sum256(combined)
-	otp := new(big.Int).SetBytes(hash[:])
+// VerificationKey returns the public key the session tokens are verified with,
+// so that other services can validate them offline.
+func (v *AuthVerifier) VerificationKey() *session.VerificationKey {
+	return v.Sessions.VerificationKey()
+}
 
-	return otp.String(), nil
+// ValidateSession checks that a session token is signed by the verifier, not expired and that its session is still active.
+// Returns the claims of the token, session.ErrTokenExpired or session.ErrInvalidToken if the token is not valid,
+// or ErrSessionRevoked if its session was revoked.
+func (v *AuthVerifier) ValidateSession(ctx context.Context, token string) (*session.Claims, error) {
+	claims, err := session.Verify(v.Sessions.VerificationKey().PublicKey, token, v.now())
+	if err != nil {
+		log.Printf(err.Error())
+		return nil, err
+	}
+	sess, err := v.SessStorage.GetSession(ctx, claims.ID)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		log.Printf(err.Error())
+		return nil, err
+	}
+	if err != nil || sess.User != claims.Subject {
+		log.Printf("%s: session %s of user '%s'", ErrSessionRevoked.Error(), claims.ID, claims.Subject)
+		return nil, ErrSessionRevoked
+	}
+	return claims, nil
+}
+
+// RevokeSession ends the session of a token, which must be valid, so that the token is no longer accepted
+// by ValidateSession, and revokes the refresh token family it was issued with.
+// Returns an error if the token is not valid.
+func (v *AuthVerifier) RevokeSession(ctx context.Context, token string) error {
+	claims, err := v.ValidateSession(ctx, token)
+	if err != nil {
+		return err
+	}
+	sess, err := v.SessStorage.GetSession(ctx, claims.ID)
+	if err != nil {
+		log.Printf(err.Error())
+		return err
+	}
+	if err = v.SessStorage.DeleteSession(ctx, claims.ID); err != nil {
+		log.Printf(err.Error())
+		return err
+	}
+	if _, err = v.RtStorage.DeleteRefreshFamily(ctx, sess.Family); err != nil {
+		log.Printf(err.Error())
+		return err
+	}
+	log.Printf("session %s of user '%s' revoked", claims.ID, claims.Subject)
+	return nil
+}
+
+// RevokeAllSessions ends every session and refresh token family of the user of a session token, which must be valid,
+// e.g: to log out everywhere.
+// Returns the number of revoked sessions or an error if the token is not valid.
+func (v *AuthVerifier) RevokeAllSessions(ctx context.Context, token string) (int, error) {
+	claims, err := v.ValidateSession(ctx, token)
+	if err != nil {
+		return 0, err
+	}
+	return v.revokeUserSessions(ctx, claims.Subject)
+}
+
+// revokeUserSessions ends every session and refresh token family of a user.
+// Returns the number of revoked sessions.
+func (v *AuthVerifier) revokeUserSessions(ctx context.Context, user string) (int, error) {
+	n, err := v.SessStorage.DeleteUserSessions(ctx, user)
+	if err != nil {
+		log.Printf(err.Error())
+		return 0, err
+	}
+	if _, err = v.RtStorage.DeleteUserRefreshTokens(ctx, user); err != nil {
+		log.Printf(err.Error())
+		return 0, err
+	}
+	log.Printf("%d sessions of user '%s' revoked", n, user)
+	return n, nil
+}
+
+// SetUserState changes the lifecycle state of a user account. Only active accounts can authenticate,
+// every session and refresh token of the user is revoked when the account leaves the active state.
+// Returns ErrInvalidArgument if the state is unknown or ErrUserNotFound if the user does not exist.
+func (v *AuthVerifier) SetUserState(ctx context.Context, user string, state storage.UserState) error {
+	if !state.Valid() {
+		err := fmt.Errorf("%w: unknown user state '%s'", ErrInvalidArgument, state)
+		log.Printf(err.Error())
+		return err
+	}
+	if err := v.UsrStorage.SetUserState(ctx, user, state); err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			err = userNotFound(user)
+		}
+		log.Printf(err.Error())
+		return err
+	}
+	log.Printf("user '%s' is %s", user, state)
+	if state == storage.UserActive {
+		return nil
+	}
+	_, err := v.revokeUserSessions(context.WithoutCancel(ctx), user)
+	return err
+}
+
+// DeleteUser deregisters a user: its data is deleted and every session and refresh token of the user is revoked.
+// Returns ErrUserNotFound if the user does not exist.
+func (v *AuthVerifier) DeleteUser(ctx context.Context, user string) error {
+	if err := v.UsrStorage.DeleteUser(ctx, user); err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			err = userNotFound(user)
+		}
+		log.Printf(err.Error())
+		return err
+	}
+	log.Printf("user '%s' deleted", user)
+	_, err := v.revokeUserSessions(context.WithoutCancel(ctx), user)
+	return err
 }
@@ -0,0 +1,877 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	"zkp-api/pkg/session"
+	"zkp-api/pkg/storage"
+	"zkp-api/pkg/storage/virtual"
+	"zkp-api/pkg/zkp"
+)
+
+// testKDF keeps the registrations in the tests cheap, the verifier only validates the parameters.
+var testKDF = zkp.KDFParams{Time: 1, Memory: 64, Threads: 1}
+
+// testClient is the address the calls in the tests come from.
+const testClient = "10.0.0.1"
+
+// newTestVerifier returns a verifier with virtual storages, the default lockout policy and a new session key that only allows the given backend.
+func newTestVerifier(zp zkp.Protocol, mode ChallengeMode) *AuthVerifier {
+	_, key, _ := ed25519.GenerateKey(nil)
+	return NewServerVerifier(virtual.NewVerifierStorage(), virtual.NewChallengeStorage(), virtual.NewNonceStorage(), virtual.NewSessionStorage(),
+		virtual.NewRefreshTokenStorage(), virtual.NewAttemptStorage(), Options{
+			Protocols: []zkp.Protocol{zp},
+			Mode:      mode,
+			Sessions:  session.NewSigner(key, "zkp-api", time.Hour),
+		}).(*AuthVerifier)
+}
+
+// register registers user with the given backend and returns the secret of the user.
+func register(t *testing.T, v Auth, zp zkp.Protocol, user string) []byte {
+	t.Helper()
+	ctx := context.Background()
+	salt, err := zkp.NewSalt()
+	if err != nil {
+		t.Fatalf("error generating salt: %s", err.Error())
+	}
+	x, err := zkp.DeriveSecret("password of "+user, salt, testKDF)
+	if err != nil {
+		t.Fatalf("error deriving secret: %s", err.Error())
+	}
+	y1, y2, err := zp.GeneratePublicCommitments(x)
+	if err != nil {
+		t.Fatalf("error generating public commitments: %s", err.Error())
+	}
+	if err = v.Register(ctx, user, zp.Name(), salt, testKDF, y1, y2); err != nil {
+		t.Fatalf("error registering user: %s", err.Error())
+	}
+	return x
+}
+
+func TestChallengeModes(t *testing.T) {
+	ctx := context.Background()
+	zp, _ := zkp.GetProtocol(zkp.Ristretto255)
+
+	tests := []struct {
+		name string
+		mode ChallengeMode
+		// whether the challenge is the one the prover can compute from the pending challenge transcript
+		hashed bool
+	}{
+		{"interactive", Interactive, false},
+		{"non-interactive", NonInteractive, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := newTestVerifier(zp, tt.mode)
+			x := register(t, v, zp, "jon")
+
+			r1, r2, k, err := zp.ProverCommitment()
+			if err != nil {
+				t.Fatalf("error generating prover commitments: %s", err.Error())
+			}
+			authID, c, err := v.CreateAuthenticationChallenge(ctx, testClient, "jon", r1, r2)
+			if err != nil {
+				t.Fatalf("error creating challenge: %s", err.Error())
+			}
+			y1, y2, _ := zp.GeneratePublicCommitments(x)
+			hashed, _ := zkp.PendingChallenge(zp, "jon", authID, y1, y2, r1, r2)
+			if bytes.Equal(c, hashed) != tt.hashed {
+				t.Fatalf("expected the challenge to be hashed from the transcript: %v", tt.hashed)
+			}
+
+			s, err := zp.SolveChallenge(x, k, c)
+			if err != nil {
+				t.Fatalf("error solving challenge: %s", err.Error())
+			}
+			tokens, err := v.VerifyAuthentication(ctx, testClient, authID, s)
+			if err != nil {
+				t.Fatalf("unable to verify: %s", err.Error())
+			}
+			claims, err := session.Verify(v.VerificationKey().PublicKey, tokens.Session, time.Now())
+			if err != nil {
+				t.Fatalf("invalid session token: %s", err.Error())
+			}
+			if claims.Subject != "jon" {
+				t.Fatalf("session token issued to '%s'", claims.Subject)
+			}
+		})
+	}
+}
+
+// challenge starts an interactive login of user and returns its auth id and the solution computed with x.
+func challenge(t *testing.T, v Auth, zp zkp.Protocol, user string, x []byte) (string, []byte) {
+	t.Helper()
+	ctx := context.Background()
+	r1, r2, k, err := zp.ProverCommitment()
+	if err != nil {
+		t.Fatalf("error generating prover commitments: %s", err.Error())
+	}
+	authID, c, err := v.CreateAuthenticationChallenge(ctx, testClient, user, r1, r2)
+	if err != nil {
+		t.Fatalf("error creating challenge: %s", err.Error())
+	}
+	s, err := zp.SolveChallenge(x, k, c)
+	if err != nil {
+		t.Fatalf("error solving challenge: %s", err.Error())
+	}
+	return authID, s
+}
+
+// login logs user in with an interactive challenge and returns the issued tokens.
+func login(t *testing.T, v Auth, zp zkp.Protocol, user string, x []byte) *Tokens {
+	t.Helper()
+	ctx := context.Background()
+	authID, s := challenge(t, v, zp, user, x)
+	tokens, err := v.VerifyAuthentication(ctx, testClient, authID, s)
+	if err != nil {
+		t.Fatalf("unable to verify: %s", err.Error())
+	}
+	return tokens
+}
+
+func TestAuthIDs(t *testing.T) {
+	ctx := context.Background()
+	zp, _ := zkp.GetProtocol(zkp.Ed25519)
+	v := newTestVerifier(zp, Interactive)
+	x := register(t, v, zp, "jon")
+
+	// several challenges of the same user are pending at once, each under its own auth id
+	id1, s1 := challenge(t, v, zp, "jon", x)
+	id2, s2 := challenge(t, v, zp, "jon", x)
+	if id1 == id2 || id1 == "jon" || id2 == "jon" {
+		t.Fatalf("auth ids must be distinct and unrelated to the user: %s, %s", id1, id2)
+	}
+	if _, err := v.VerifyAuthentication(ctx, testClient, "jon", s1); err == nil {
+		t.Fatalf("the user name must not be accepted as an auth id")
+	}
+	if _, err := v.VerifyAuthentication(ctx, testClient, id2, s2); err != nil {
+		t.Fatalf("unable to verify the second challenge: %s", err.Error())
+	}
+	if _, err := v.VerifyAuthentication(ctx, testClient, id1, s1); err != nil {
+		t.Fatalf("unable to verify the first challenge: %s", err.Error())
+	}
+
+	// a challenge is deleted by its first answer, right or wrong
+	tests := []struct {
+		name  string
+		first func(s []byte) []byte
+	}{
+		{"after success", func(s []byte) []byte { return s }},
+		{"after failure", func(s []byte) []byte { return s2 }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, s := challenge(t, v, zp, "jon", x)
+			_, _ = v.VerifyAuthentication(ctx, testClient, id, tt.first(s))
+			if _, err := v.VerifyAuthentication(ctx, testClient, id, s); err == nil {
+				t.Fatalf("a challenge must only be answered once")
+			}
+		})
+	}
+}
+
+func TestParseChallengeMode(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    ChallengeMode
+		wantErr bool
+	}{
+		{"", Interactive, false},
+		{"interactive", Interactive, false},
+		{"non-interactive", NonInteractive, false},
+		{"fiat-shamir", "", true},
+	}
+	for _, tt := range tests {
+		mode, err := ParseChallengeMode(tt.name)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%q: unexpected error: %v", tt.name, err)
+		}
+		if mode != tt.want {
+			t.Errorf("%q: got mode %q, want %q", tt.name, mode, tt.want)
+		}
+	}
+}
+
+func TestRegisterValidation(t *testing.T) {
+	ctx := context.Background()
+	zp, _ := zkp.GetProtocol(zkp.Ristretto255)
+	v := newTestVerifier(zp, Interactive)
+	salt, _ := zkp.NewSalt()
+	y1, y2, _ := zp.GeneratePublicCommitments([]byte("secret"))
+
+	tests := []struct {
+		name     string
+		protocol string
+		salt     []byte
+		kdf      zkp.KDFParams
+	}{
+		{"protocol not allowed", zkp.P256, salt, testKDF},
+		{"short salt", zp.Name(), salt[:4], testKDF},
+		{"invalid kdf", zp.Name(), salt, zkp.KDFParams{}},
+		{"kdf memory over the limit", zp.Name(), salt, zkp.KDFParams{Time: 1, Memory: 0xFFFFFFFF, Threads: 1}},
+		{"kdf time over the limit", zp.Name(), salt, zkp.KDFParams{Time: 1 << 20, Memory: 64, Threads: 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := v.Register(ctx, "jon", tt.protocol, tt.salt, tt.kdf, y1, y2); !errors.Is(err, ErrInvalidArgument) {
+				t.Errorf("got error %v, want %v", err, ErrInvalidArgument)
+			}
+		})
+	}
+}
+
+func TestErrors(t *testing.T) {
+	ctx := context.Background()
+	zp, _ := zkp.GetProtocol(zkp.Ristretto255)
+	v := newTestVerifier(zp, Interactive)
+	x := register(t, v, zp, "jon")
+	salt, _ := zkp.NewSalt()
+	y1, y2, _ := zp.GeneratePublicCommitments(x)
+
+	tests := []struct {
+		name    string
+		call    func() error
+		wantErr error
+	}{
+		{"user exists", func() error { return v.Register(ctx, "jon", zp.Name(), salt, testKDF, y1, y2) }, ErrUserExists},
+		{"user not found", func() error { _, err := v.LoginParameters(ctx, "ana"); return err }, ErrUserNotFound},
+		{"challenge of unknown user", func() error {
+			_, _, err := v.CreateAuthenticationChallenge(ctx, testClient, "ana", y1, y2)
+			return err
+		}, ErrUserNotFound},
+		{"unknown auth id", func() error { _, err := v.VerifyAuthentication(ctx, testClient, "unknown", nil); return err }, ErrChallengeNotFound},
+		{"invalid proof", func() error {
+			authID, _ := challenge(t, v, zp, "jon", x)
+			_, err := v.VerifyAuthentication(ctx, testClient, authID, []byte("wrong"))
+			return err
+		}, ErrInvalidProof},
+		{"no nonce", func() error { _, err := v.Login(ctx, testClient, "jon", nil, nil, nil, nil); return err }, ErrNoNonce},
+		{"unknown nonce", func() error { _, err := v.Login(ctx, testClient, "jon", []byte("nonce"), nil, nil, nil); return err }, ErrNoNonce},
+		{"unknown state", func() error { return v.SetUserState(ctx, "jon", "banned") }, ErrInvalidArgument},
+		{"state of unknown user", func() error { return v.SetUserState(ctx, "ana", storage.UserLocked) }, ErrUserNotFound},
+		{"delete unknown user", func() error { return v.DeleteUser(ctx, "ana") }, ErrUserNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.call(); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLogin(t *testing.T) {
+	ctx := context.Background()
+	zp, _ := zkp.GetProtocol(zkp.Secp256k1)
+	v := newTestVerifier(zp, Interactive)
+	x := register(t, v, zp, "jon")
+	register(t, v, zp, "ana")
+
+	// prove builds a non-interactive proof of x for user bound to nonce
+	prove := func(user string, nonce []byte) (r1, r2, s []byte) {
+		t.Helper()
+		y1, y2, _ := zp.GeneratePublicCommitments(x)
+		r1, r2, k, err := zp.ProverCommitment()
+		if err != nil {
+			t.Fatalf("error generating prover commitments: %s", err.Error())
+		}
+		c, err := zkp.LoginChallenge(zp, user, nonce, y1, y2, r1, r2)
+		if err != nil {
+			t.Fatalf("error computing challenge: %s", err.Error())
+		}
+		if s, err = zp.SolveChallenge(x, k, c); err != nil {
+			t.Fatalf("error solving challenge: %s", err.Error())
+		}
+		return r1, r2, s
+	}
+
+	if _, err := v.Login(ctx, testClient, "jon", nil, nil, nil, nil); !errors.Is(err, ErrNoNonce) {
+		t.Fatalf("got error %v, want %v", err, ErrNoNonce)
+	}
+
+	params, err := v.LoginParameters(ctx, "jon")
+	if err != nil {
+		t.Fatalf("error getting login parameters: %s", err.Error())
+	}
+	r1, r2, s := prove("jon", params.Nonce)
+	tokens, err := v.Login(ctx, testClient, "jon", params.Nonce, r1, r2, s)
+	if err != nil {
+		t.Fatalf("unable to login: %s", err.Error())
+	}
+	if claims, err := session.Verify(v.VerificationKey().PublicKey, tokens.Session, time.Now()); err != nil || claims.Subject != "jon" {
+		t.Fatalf("invalid session token: %v", err)
+	}
+	if _, err = v.Login(ctx, testClient, "jon", params.Nonce, r1, r2, s); !errors.Is(err, ErrNoNonce) {
+		t.Fatalf("a proof must not be replayed: %v", err)
+	}
+
+	// a proof bound to another nonce fails, and consumes the nonce it was sent with
+	params, _ = v.LoginParameters(ctx, "jon")
+	r1, r2, s = prove("jon", []byte("stale nonce"))
+	if _, err = v.Login(ctx, testClient, "jon", params.Nonce, r1, r2, s); !errors.Is(err, ErrInvalidProof) {
+		t.Fatalf("got error %v, want %v", err, ErrInvalidProof)
+	}
+	r1, r2, s = prove("jon", params.Nonce)
+	if _, err = v.Login(ctx, testClient, "jon", params.Nonce, r1, r2, s); !errors.Is(err, ErrNoNonce) {
+		t.Fatalf("nonce should be consumed by the failed attempt: %v", err)
+	}
+
+	// a nonce issued to another user is rejected, a proof bound to another user fails
+	params, _ = v.LoginParameters(ctx, "ana")
+	r1, r2, s = prove("jon", params.Nonce)
+	if _, err = v.Login(ctx, testClient, "jon", params.Nonce, r1, r2, s); !errors.Is(err, ErrNoNonce) {
+		t.Fatalf("got error %v, want %v", err, ErrNoNonce)
+	}
+	params, _ = v.LoginParameters(ctx, "ana")
+	r1, r2, s = prove("jon", params.Nonce)
+	if _, err = v.Login(ctx, testClient, "ana", params.Nonce, r1, r2, s); !errors.Is(err, ErrInvalidProof) {
+		t.Fatalf("got error %v, want %v", err, ErrInvalidProof)
+	}
+
+	// a nonce can only be used for the nonce TTL
+	params, _ = v.LoginParameters(ctx, "jon")
+	r1, r2, s = prove("jon", params.Nonce)
+	v.now = func() time.Time { return time.Now().Add(DefaultNonceTTL) }
+	if _, err = v.Login(ctx, testClient, "jon", params.Nonce, r1, r2, s); !errors.Is(err, ErrNoNonce) {
+		t.Fatalf("got error %v, want %v", err, ErrNoNonce)
+	}
+}
+
+func TestInterleavedLogins(t *testing.T) {
+	ctx := context.Background()
+	zp, _ := zkp.GetProtocol(zkp.Ristretto255)
+	v := newTestVerifier(zp, NonInteractive)
+	x := register(t, v, zp, "jon")
+	y1, y2, _ := zp.GeneratePublicCommitments(x)
+
+	// two logins of jon get their nonces before either sends its proof, and someone else keeps asking for more
+	first, err := v.LoginParameters(ctx, "jon")
+	if err != nil {
+		t.Fatalf("error getting login parameters: %s", err.Error())
+	}
+	second, _ := v.LoginParameters(ctx, "jon")
+	for i := 0; i < 3; i++ {
+		_, _ = v.LoginParameters(ctx, "jon")
+	}
+	if bytes.Equal(first.Nonce, second.Nonce) {
+		t.Fatalf("logins got the same nonce")
+	}
+
+	// both logins succeed whatever the order of their proofs
+	for _, params := range []*LoginParams{second, first} {
+		r1, r2, k, _ := zp.ProverCommitment()
+		c, _ := zkp.LoginChallenge(zp, "jon", params.Nonce, y1, y2, r1, r2)
+		s, _ := zp.SolveChallenge(x, k, c)
+		if _, err = v.Login(ctx, testClient, "jon", params.Nonce, r1, r2, s); err != nil {
+			t.Fatalf("unable to login: %s", err.Error())
+		}
+	}
+	if at, _ := v.AtStorage.GetAttempts(ctx, "client:"+testClient); at.Failures != 0 {
+		t.Fatalf("interleaved logins counted %d failures", at.Failures)
+	}
+}
+
+func TestChallengeExpiry(t *testing.T) {
+	ctx := context.Background()
+	zp, _ := zkp.GetProtocol(zkp.Ristretto255)
+	v := newTestVerifier(zp, Interactive)
+	now := time.Now()
+	v.now = func() time.Time { return now }
+	x := register(t, v, zp, "jon")
+
+	tests := []struct {
+		name    string
+		elapsed time.Duration
+		wantErr error
+	}{
+		{"before ttl", DefaultChallengeTTL - time.Second, nil},
+		{"at ttl", DefaultChallengeTTL, ErrChallengeExpired},
+		{"after ttl", DefaultChallengeTTL + time.Minute, ErrChallengeExpired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := now
+			authID, s := challenge(t, v, zp, "jon", x)
+			now = start.Add(tt.elapsed)
+			defer func() { now = start }()
+			if _, err := v.VerifyAuthentication(ctx, testClient, authID, s); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestConcurrentLogins hammers the challenges with concurrent logins, it is meant to run with the race detector:
+// every challenge keeps the commitments of its own login and is answered once, whatever the other logins do.
+func TestConcurrentLogins(t *testing.T) {
+	ctx := context.Background()
+	zp, _ := zkp.GetProtocol(zkp.Ristretto255)
+	v := newTestVerifier(zp, Interactive)
+	users := []string{"jon", "ana", "bob"}
+	secrets := make(map[string][]byte)
+	for _, user := range users {
+		secrets[user] = register(t, v, zp, user)
+	}
+
+	t.Run("logins", func(t *testing.T) {
+		var wg sync.WaitGroup
+		for i := 0; i < 48; i++ {
+			user := users[i%len(users)]
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := attempt(v, zp, testClient, user, secrets[user]); err != nil {
+					t.Errorf("unable to login %s: %s", user, err.Error())
+				}
+			}()
+		}
+		wg.Wait()
+	})
+
+	t.Run("answers", func(t *testing.T) {
+		authID, s := challenge(t, v, zp, "jon", secrets["jon"])
+		var wg sync.WaitGroup
+		var verified atomic.Int32
+		for i := 0; i < 16; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := v.VerifyAuthentication(ctx, testClient, authID, s)
+				if err == nil {
+					verified.Add(1)
+				} else if !errors.Is(err, ErrChallengeNotFound) {
+					t.Errorf("got error %v, want %v", err, ErrChallengeNotFound)
+				}
+			}()
+		}
+		wg.Wait()
+		if n := verified.Load(); n != 1 {
+			t.Fatalf("challenge answered %d times", n)
+		}
+	})
+
+	t.Run("nonces", func(t *testing.T) {
+		nv := newTestVerifier(zp, NonInteractive)
+		x := register(t, nv, zp, "jon")
+		y1, y2, _ := zp.GeneratePublicCommitments(x)
+		params, err := nv.LoginParameters(ctx, "jon")
+		if err != nil {
+			t.Fatalf("error getting login parameters: %s", err.Error())
+		}
+		r1, r2, k, _ := zp.ProverCommitment()
+		c, _ := zkp.LoginChallenge(zp, "jon", params.Nonce, y1, y2, r1, r2)
+		s, _ := zp.SolveChallenge(x, k, c)
+
+		// the same proof replayed concurrently logs in once, the nonce is consumed by the first
+		var wg sync.WaitGroup
+		var verified atomic.Int32
+		for i := 0; i < 16; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := nv.Login(ctx, testClient, "jon", params.Nonce, r1, r2, s)
+				if err == nil {
+					verified.Add(1)
+				} else if !errors.Is(err, ErrNoNonce) {
+					t.Errorf("got error %v, want %v", err, ErrNoNonce)
+				}
+			}()
+		}
+		wg.Wait()
+		if n := verified.Load(); n != 1 {
+			t.Fatalf("nonce used %d times", n)
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		var wg sync.WaitGroup
+		for i := 0; i < 32; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				// note a login either completes before the delete or fails, never with the values of another login
+				if err := attempt(v, zp, testClient, "bob", secrets["bob"]); err != nil && !errors.Is(err, ErrUserNotFound) {
+					t.Errorf("got error %v, want %v", err, ErrUserNotFound)
+				}
+			}()
+		}
+		if err := v.DeleteUser(ctx, "bob"); err != nil {
+			t.Fatalf("unable to delete user: %s", err.Error())
+		}
+		wg.Wait()
+		if err := attempt(v, zp, testClient, "bob", secrets["bob"]); !errors.Is(err, ErrUserNotFound) {
+			t.Fatalf("got error %v, want %v", err, ErrUserNotFound)
+		}
+	})
+}
+
+func TestReapChallenges(t *testing.T) {
+	ctx := context.Background()
+	chs := virtual.NewChallengeStorage()
+	now := time.Now()
+	_ = chs.BeginChallenge(ctx, "stale", &storage.ChallengeData{User: "jon", CreatedAt: now.Add(-time.Minute), TTL: time.Second})
+	_ = chs.BeginChallenge(ctx, "fresh", &storage.ChallengeData{User: "jon", CreatedAt: now, TTL: time.Hour})
+
+	reapCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		ReapChallenges(reapCtx, chs, time.Millisecond)
+		close(done)
+	}()
+
+	deadline := time.After(5 * time.Second)
+	for {
+		if _, err := chs.ConsumeChallenge(ctx, "stale"); err != nil {
+			break
+		}
+		select {
+		case <-deadline:
+			t.Fatalf("stale challenge was not reaped")
+		case <-time.After(time.Millisecond):
+		}
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("reaper did not stop with its context")
+	}
+	if _, err := chs.ConsumeChallenge(ctx, "fresh"); err != nil {
+		t.Fatalf("fresh challenge was reaped: %s", err.Error())
+	}
+}
+
+func TestCanceledContext(t *testing.T) {
+	ctx := context.Background()
+	zp, _ := zkp.GetProtocol(zkp.Ristretto255)
+	v := newTestVerifier(zp, Interactive)
+	x := register(t, v, zp, "jon")
+	tokens := login(t, v, zp, "jon", x)
+	authID, s := challenge(t, v, zp, "jon", x)
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	expired, cancel := context.WithDeadline(ctx, time.Now().Add(-time.Second))
+	defer cancel()
+	salt, _ := zkp.NewSalt()
+	tests := []struct {
+		name    string
+		call    func(ctx context.Context) error
+		wantErr error
+	}{
+		{"register", func(ctx context.Context) error { return v.Register(ctx, "ana", zp.Name(), salt, testKDF, x, x) }, context.Canceled},
+		{"login parameters", func(ctx context.Context) error { _, err := v.LoginParameters(ctx, "jon"); return err }, context.DeadlineExceeded},
+		{"verify", func(ctx context.Context) error {
+			_, err := v.VerifyAuthentication(ctx, testClient, authID, s)
+			return err
+		}, context.Canceled},
+		{"validate session", func(ctx context.Context) error { _, err := v.ValidateSession(ctx, tokens.Session); return err }, context.Canceled},
+		{"refresh", func(ctx context.Context) error { _, err := v.RefreshSession(ctx, tokens.Refresh); return err }, context.DeadlineExceeded},
+		{"delete user", func(ctx context.Context) error { return v.DeleteUser(ctx, "jon") }, context.Canceled},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := canceled
+			if tt.wantErr == context.DeadlineExceeded {
+				ctx = expired
+			}
+			if err := tt.call(ctx); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	// nothing was changed by the canceled calls
+	if ok, _ := v.UsrStorage.CheckUser(ctx, "ana"); ok {
+		t.Fatalf("user registered by a canceled call")
+	}
+	if _, err := v.VerifyAuthentication(ctx, testClient, authID, s); err != nil {
+		t.Fatalf("challenge consumed by a canceled call: %s", err.Error())
+	}
+	if _, err := v.RefreshSession(ctx, tokens.Refresh); err != nil {
+		t.Fatalf("refresh token used by a canceled call: %s", err.Error())
+	}
+}
+
+func TestSessions(t *testing.T) {
+	ctx := context.Background()
+	zp, _ := zkp.GetProtocol(zkp.Ristretto255)
+	v := newTestVerifier(zp, Interactive)
+	x := register(t, v, zp, "jon")
+	y := register(t, v, zp, "ana")
+
+	jon1 := login(t, v, zp, "jon", x).Session
+	jon2 := login(t, v, zp, "jon", x).Session
+	jon3 := login(t, v, zp, "jon", x).Session
+	ana := login(t, v, zp, "ana", y).Session
+
+	claims, err := v.ValidateSession(ctx, jon1)
+	if err != nil {
+		t.Fatalf("unable to validate session: %s", err.Error())
+	}
+	if claims.Subject != "jon" {
+		t.Fatalf("session of user '%s'", claims.Subject)
+	}
+	if _, err = v.ValidateSession(ctx, "not a token"); !errors.Is(err, session.ErrInvalidToken) {
+		t.Fatalf("got error %v, want %v", err, session.ErrInvalidToken)
+	}
+
+	// revoking a session only ends that session
+	if err = v.RevokeSession(ctx, jon1); err != nil {
+		t.Fatalf("unable to revoke session: %s", err.Error())
+	}
+	if _, err = v.ValidateSession(ctx, jon1); !errors.Is(err, ErrSessionRevoked) {
+		t.Fatalf("got error %v, want %v", err, ErrSessionRevoked)
+	}
+	if err = v.RevokeSession(ctx, jon1); err == nil {
+		t.Fatalf("a session must only be revoked once")
+	}
+	if _, err = v.ValidateSession(ctx, jon2); err != nil {
+		t.Fatalf("unable to validate another session: %s", err.Error())
+	}
+
+	// revoking every session of a user takes one of its session tokens and leaves the sessions of other users
+	if _, err = v.RevokeAllSessions(ctx, jon1); !errors.Is(err, ErrSessionRevoked) {
+		t.Fatalf("got error %v, want %v", err, ErrSessionRevoked)
+	}
+	n, err := v.RevokeAllSessions(ctx, jon3)
+	if err != nil {
+		t.Fatalf("unable to revoke sessions: %s", err.Error())
+	}
+	if n != 2 {
+		t.Fatalf("revoked %d sessions, want 2", n)
+	}
+	for _, token := range []string{jon2, jon3} {
+		if _, err = v.ValidateSession(ctx, token); !errors.Is(err, ErrSessionRevoked) {
+			t.Fatalf("got error %v, want %v", err, ErrSessionRevoked)
+		}
+	}
+	if _, err = v.ValidateSession(ctx, ana); err != nil {
+		t.Fatalf("unable to validate the session of another user: %s", err.Error())
+	}
+
+	// expired tokens are rejected before looking up their session
+	v.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	if _, err = v.ValidateSession(ctx, ana); !errors.Is(err, session.ErrTokenExpired) {
+		t.Fatalf("got error %v, want %v", err, session.ErrTokenExpired)
+	}
+}
+
+func TestRefreshSession(t *testing.T) {
+	ctx := context.Background()
+	zp, _ := zkp.GetProtocol(zkp.Ristretto255)
+	v := newTestVerifier(zp, Interactive)
+	x := register(t, v, zp, "jon")
+
+	// refresh exchanges a refresh token and checks the new session token
+	refresh := func(rt string) *Tokens {
+		t.Helper()
+		tokens, err := v.RefreshSession(ctx, rt)
+		if err != nil {
+			t.Fatalf("unable to refresh: %s", err.Error())
+		}
+		if claims, err := v.ValidateSession(ctx, tokens.Session); err != nil || claims.Subject != "jon" {
+			t.Fatalf("invalid session token: %v", err)
+		}
+		return tokens
+	}
+
+	first := login(t, v, zp, "jon", x)
+	second := refresh(first.Refresh)
+	if second.Refresh == first.Refresh {
+		t.Fatalf("refresh tokens must rotate")
+	}
+	third := refresh(second.Refresh)
+	other := login(t, v, zp, "jon", x)
+
+	// reusing a refresh token revokes its whole family and the sessions issued with it, but not the families of other logins
+	if _, err := v.RefreshSession(ctx, first.Refresh); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Fatalf("got error %v, want %v", err, ErrRefreshTokenReused)
+	}
+	if _, err := v.RefreshSession(ctx, third.Refresh); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Fatalf("got error %v, want %v", err, ErrInvalidRefreshToken)
+	}
+	for _, tokens := range []*Tokens{first, second, third} {
+		if _, err := v.ValidateSession(ctx, tokens.Session); !errors.Is(err, ErrSessionRevoked) {
+			t.Fatalf("got error %v, want %v", err, ErrSessionRevoked)
+		}
+	}
+	if _, err := v.ValidateSession(ctx, other.Session); err != nil {
+		t.Fatalf("unable to validate the session of another family: %s", err.Error())
+	}
+	other = refresh(other.Refresh)
+
+	if _, err := v.RefreshSession(ctx, "unknown"); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Fatalf("got error %v, want %v", err, ErrInvalidRefreshToken)
+	}
+
+	// revoking a session revokes its family
+	if err := v.RevokeSession(ctx, other.Session); err != nil {
+		t.Fatalf("unable to revoke session: %s", err.Error())
+	}
+	if _, err := v.RefreshSession(ctx, other.Refresh); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Fatalf("got error %v, want %v", err, ErrInvalidRefreshToken)
+	}
+
+	// revoking every session of a user revokes every family
+	last := login(t, v, zp, "jon", x)
+	if _, err := v.RevokeAllSessions(ctx, last.Session); err != nil {
+		t.Fatalf("unable to revoke sessions: %s", err.Error())
+	}
+	if _, err := v.RefreshSession(ctx, last.Refresh); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Fatalf("got error %v, want %v", err, ErrInvalidRefreshToken)
+	}
+
+	// a family expires RefreshTTL after its login, however many times it was rotated
+	expiring := refresh(login(t, v, zp, "jon", x).Refresh)
+	v.now = func() time.Time { return time.Now().Add(DefaultRefreshTTL) }
+	if _, err := v.RefreshSession(ctx, expiring.Refresh); !errors.Is(err, ErrRefreshTokenExpired) {
+		t.Fatalf("got error %v, want %v", err, ErrRefreshTokenExpired)
+	}
+}
+
+func TestUpdateCommitments(t *testing.T) {
+	ctx := context.Background()
+	zp, _ := zkp.GetProtocol(zkp.P256)
+	v := newTestVerifier(zp, Interactive)
+	x := register(t, v, zp, "jon")
+	tokens := login(t, v, zp, "jon", x)
+
+	salt, _ := zkp.NewSalt()
+	newX, err := zkp.DeriveSecret("new password of jon", salt, testKDF)
+	if err != nil {
+		t.Fatalf("error deriving secret: %s", err.Error())
+	}
+	newY1, newY2, _ := zp.GeneratePublicCommitments(newX)
+	otherY1, otherY2, _ := zp.GeneratePublicCommitments([]byte("other secret"))
+
+	// prove proves the knowledge of secret bound to the new credentials and a new nonce
+	prove := func(secret []byte) (nonce, r1, r2, s []byte) {
+		t.Helper()
+		params, err := v.LoginParameters(ctx, "jon")
+		if err != nil {
+			t.Fatalf("error getting login parameters: %s", err.Error())
+		}
+		y1, y2, _ := zp.GeneratePublicCommitments(secret)
+		r1, r2, k, _ := zp.ProverCommitment()
+		c, err := zkp.UpdateChallenge(zp, "jon", params.Nonce, y1, y2, r1, r2, salt, testKDF, newY1, newY2)
+		if err != nil {
+			t.Fatalf("error computing challenge: %s", err.Error())
+		}
+		s, _ = zp.SolveChallenge(secret, k, c)
+		return params.Nonce, r1, r2, s
+	}
+
+	tests := []struct {
+		name         string
+		secret       []byte
+		salt         []byte
+		newY1, newY2 []byte
+	}{
+		{"wrong secret", []byte("guess"), salt, newY1, newY2},
+		{"other commitments", x, salt, otherY1, otherY2},
+		{"short salt", x, salt[:4], newY1, newY2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nonce, r1, r2, s := prove(tt.secret)
+			if err := v.UpdateCommitments(ctx, testClient, "jon", nonce, tt.salt, testKDF, tt.newY1, tt.newY2, r1, r2, s); err == nil {
+				t.Fatalf("expected an error")
+			}
+		})
+	}
+
+	// costs over the limits would make every later login of the user exhaust the prover
+	nonce, r1, r2, s := prove(x)
+	huge := zkp.KDFParams{Time: 1, Memory: 0xFFFFFFFF, Threads: 1}
+	if err = v.UpdateCommitments(ctx, testClient, "jon", nonce, salt, huge, newY1, newY2, r1, r2, s); !errors.Is(err, ErrInvalidArgument) {
+		t.Fatalf("got error %v, want %v", err, ErrInvalidArgument)
+	}
+
+	if err = v.UpdateCommitments(ctx, testClient, "jon", nonce, salt, testKDF, newY1, newY2, r1, r2, s); err != nil {
+		t.Fatalf("unable to update commitments: %s", err.Error())
+	}
+	if err = v.UpdateCommitments(ctx, testClient, "jon", nonce, salt, testKDF, newY1, newY2, r1, r2, s); err == nil {
+		t.Fatalf("a proof must not be replayed")
+	}
+	if _, err = v.ValidateSession(ctx, tokens.Session); !errors.Is(err, ErrSessionRevoked) {
+		t.Fatalf("got error %v, want %v", err, ErrSessionRevoked)
+	}
+	params, _ := v.LoginParameters(ctx, "jon")
+	if !bytes.Equal(params.Salt, salt) {
+		t.Fatalf("salt was not updated")
+	}
+
+	// only the new secret logs in
+	authID, s := challenge(t, v, zp, "jon", x)
+	if _, err = v.VerifyAuthentication(ctx, testClient, authID, s); err == nil {
+		t.Fatalf("the old secret should not log in")
+	}
+	login(t, v, zp, "jon", newX)
+}
+
+func TestUserLifecycle(t *testing.T) {
+	ctx := context.Background()
+	zp, _ := zkp.GetProtocol(zkp.Ed25519)
+	v := newTestVerifier(zp, Interactive)
+	x := register(t, v, zp, "jon")
+
+	for _, state := range []storage.UserState{storage.UserLocked, storage.UserDisabled, storage.UserPending} {
+		t.Run(string(state), func(t *testing.T) {
+			tokens := login(t, v, zp, "jon", x)
+			// a challenge issued before the state changes can not be answered after it
+			authID, s := challenge(t, v, zp, "jon", x)
+			if err := v.SetUserState(ctx, "jon", state); err != nil {
+				t.Fatalf("unable to set state: %s", err.Error())
+			}
+			if _, err := v.VerifyAuthentication(ctx, testClient, authID, s); !errors.Is(err, ErrUserNotActive) {
+				t.Fatalf("got error %v, want %v", err, ErrUserNotActive)
+			}
+			r1, r2, _, _ := zp.ProverCommitment()
+			if _, _, err := v.CreateAuthenticationChallenge(ctx, testClient, "jon", r1, r2); !errors.Is(err, ErrUserNotActive) {
+				t.Fatalf("got error %v, want %v", err, ErrUserNotActive)
+			}
+			if _, err := v.ValidateSession(ctx, tokens.Session); !errors.Is(err, ErrSessionRevoked) {
+				t.Fatalf("got error %v, want %v", err, ErrSessionRevoked)
+			}
+			if _, err := v.RefreshSession(ctx, tokens.Refresh); err == nil {
+				t.Fatalf("refresh tokens must be revoked")
+			}
+
+			if err := v.SetUserState(ctx, "jon", storage.UserActive); err != nil {
+				t.Fatalf("unable to set state: %s", err.Error())
+			}
+			login(t, v, zp, "jon", x)
+		})
+	}
+
+	if err := v.SetUserState(ctx, "jon", "archived"); err == nil {
+		t.Fatalf("unknown states must be rejected")
+	}
+	if err := v.SetUserState(ctx, "ana", storage.UserDisabled); err == nil {
+		t.Fatalf("the state of an unknown user must not be set")
+	}
+
+	tokens := login(t, v, zp, "jon", x)
+	if err := v.DeleteUser(ctx, "jon"); err != nil {
+		t.Fatalf("unable to delete user: %s", err.Error())
+	}
+	if _, err := v.ValidateSession(ctx, tokens.Session); !errors.Is(err, ErrSessionRevoked) {
+		t.Fatalf("got error %v, want %v", err, ErrSessionRevoked)
+	}
+	if _, err := v.LoginParameters(ctx, "jon"); err == nil {
+		t.Fatalf("a deleted user must not log in")
+	}
+	if err := v.DeleteUser(ctx, "jon"); err == nil {
+		t.Fatalf("a user must only be deleted once")
+	}
+	// the name can be registered again
+	register(t, v, zp, "jon")
+}
@@ -0,0 +1,67 @@
+package grpc
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"strings"
+)
+
+// AuthorizationKey is the metadata key of the admin token of the admin calls, e.g: authorization: Bearer <token>.
+const AuthorizationKey = "authorization"
+
+// AdminMethods are the full methods of the admin calls, which change or delete the accounts of any user.
+var AdminMethods = map[string]bool{
+	"/zkpauth.Auth/SetUserState": true,
+	"/zkpauth.Auth/DeleteUser":   true,
+}
+
+// AdminInterceptor returns a gRPC interceptor that only lets the admin calls through when they carry the admin token
+// as a bearer token, the other calls are passed as they are. Every admin call is rejected if the token is empty.
+// note the tokens are compared by their hash, in constant time.
+func AdminInterceptor(token string) grpc.UnaryServerInterceptor {
+	want := sha256.Sum256([]byte(token))
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if !AdminMethods[info.FullMethod] {
+			return handler(ctx, req)
+		}
+		if token == "" {
+			return nil, adminError(codes.PermissionDenied, "admin calls are disabled")
+		}
+		got, ok := bearerToken(ctx)
+		if !ok {
+			return nil, adminError(codes.Unauthenticated, "missing admin token")
+		}
+		if sum := sha256.Sum256([]byte(got)); subtle.ConstantTimeCompare(sum[:], want[:]) != 1 {
+			return nil, adminError(codes.Unauthenticated, "invalid admin token")
+		}
+		return handler(ctx, req)
+	}
+}
+
+// bearerToken returns the bearer token of the authorization metadata of a call, if any.
+func bearerToken(ctx context.Context) (string, bool) {
+	values := metadata.ValueFromIncomingContext(ctx, AuthorizationKey)
+	if len(values) != 1 {
+		return "", false
+	}
+	scheme, token, ok := strings.Cut(values[0], " ")
+	if !ok || !strings.EqualFold(scheme, "bearer") || token == "" {
+		return "", false
+	}
+	return token, true
+}
+
+// adminError returns the error of a rejected admin call, with the ErrorInfo details of ReasonAdminOnly.
+func adminError(code codes.Code, msg string) error {
+	st := status.New(code, msg)
+	if dst, err := st.WithDetails(&errdetails.ErrorInfo{Reason: ReasonAdminOnly, Domain: ErrorDomain}); err == nil {
+		st = dst
+	}
+	return st.Err()
+}
@@ -0,0 +1,57 @@
+package grpc
+
+import (
+	"context"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"testing"
+	pb "zkp-api/pkg/http/grpc/zkp"
+)
+
+func TestAdminInterceptor(t *testing.T) {
+	handler := func(ctx context.Context, req any) (any, error) { return &pb.DeleteUserResponse{}, nil }
+	withToken := func(auth ...string) context.Context {
+		return metadata.NewIncomingContext(context.Background(), metadata.MD{AuthorizationKey: auth})
+	}
+
+	tests := []struct {
+		name   string
+		token  string // admin token of the interceptor
+		method string
+		ctx    context.Context
+		code   codes.Code
+	}{
+		{"unauthenticated", "s3cret", "/zkpauth.Auth/DeleteUser", context.Background(), codes.Unauthenticated},
+		{"invalid token", "s3cret", "/zkpauth.Auth/SetUserState", withToken("Bearer s3cre"), codes.Unauthenticated},
+		{"invalid scheme", "s3cret", "/zkpauth.Auth/DeleteUser", withToken("Basic s3cret"), codes.Unauthenticated},
+		{"several tokens", "s3cret", "/zkpauth.Auth/DeleteUser", withToken("Bearer s3cret", "Bearer other"), codes.Unauthenticated},
+		{"disabled", "", "/zkpauth.Auth/DeleteUser", withToken("Bearer "), codes.PermissionDenied},
+		{"admin", "s3cret", "/zkpauth.Auth/DeleteUser", withToken("Bearer s3cret"), codes.OK},
+		{"not admin", "", "/zkpauth.Auth/Login", context.Background(), codes.OK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			intercept := AdminInterceptor(tt.token)
+			_, err := intercept(tt.ctx, &pb.DeleteUserRequest{User: "jon"}, &grpc.UnaryServerInfo{FullMethod: tt.method}, handler)
+			st := status.Convert(err)
+			if st.Code() != tt.code {
+				t.Fatalf("got code %s, want %s", st.Code(), tt.code)
+			}
+			if tt.code == codes.OK {
+				return
+			}
+			var info *errdetails.ErrorInfo
+			for _, d := range st.Details() {
+				if ei, ok := d.(*errdetails.ErrorInfo); ok {
+					info = ei
+				}
+			}
+			if info.GetReason() != ReasonAdminOnly {
+				t.Fatalf("got error info %v, want reason %s", info, ReasonAdminOnly)
+			}
+		})
+	}
+}
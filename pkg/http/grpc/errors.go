@@ -0,0 +1,23 @@
+package grpc
+
+// ErrorDomain is the domain of the ErrorInfo details of the errors returned by the verifier.
+const ErrorDomain = "zkp-api"
+
+// Reasons of the ErrorInfo details of the errors returned by the verifier, so that clients can tell apart errors
+// with the same status code, e.g: a locked out user and a rate limited call are both ResourceExhausted.
+const (
+	ReasonInvalidArgument     = "INVALID_ARGUMENT"      // the request is not valid
+	ReasonUserExists          = "USER_EXISTS"           // the user is already registered
+	ReasonUserNotFound        = "USER_NOT_FOUND"        // the user is not registered
+	ReasonUserNotActive       = "USER_NOT_ACTIVE"       // the account of the user is not active
+	ReasonChallengeNotFound   = "CHALLENGE_NOT_FOUND"   // the auth id is unknown
+	ReasonChallengeExpired    = "CHALLENGE_EXPIRED"     // the challenge was answered after its TTL
+	ReasonNoNonce             = "NO_NONCE"              // no nonce was issued for the non-interactive proof
+	ReasonInvalidProof        = "INVALID_PROOF"         // the proof does not verify
+	ReasonLocked              = "LOCKED"                // the user or client is locked out after too many failed attempts
+	ReasonRateLimited         = "RATE_LIMITED"          // the call is over the rate limits
+	ReasonInvalidSession      = "INVALID_SESSION"       // the session token is not valid, expired or revoked
+	ReasonInvalidRefreshToken = "INVALID_REFRESH_TOKEN" // the refresh token is not valid, expired or reused
+	ReasonAdminOnly           = "ADMIN_ONLY"            // the call needs the admin token
+	ReasonInternal            = "INTERNAL"              // the verifier failed to process the call
+)
@@ -7,17 +7,20 @@ import (
 	pb "zkp-api/pkg/http/grpc/zkp"
 )
 
+// ForwardedForKey is the metadata key a proxy such as the prover forwards the address of its own client with.
+const ForwardedForKey = "x-forwarded-for"
+
 // InitServer initializes and starts a gRPC server on the specified network and address.
 // It takes a network type (e.g., "tcp"), an address (e.g., ":50051"), and an implementation
-// of the AuthServer interface to register with the gRPC server.
+// of the AuthServer interface to register with the gRPC server, along with the interceptors every unary call goes through.
 // It logs and exits the application if it fails to listen on the network address or if the server fails to serve.
-func InitServer(network, address string, as pb.AuthServer) error {
+func InitServer(network, address string, as pb.AuthServer, interceptors ...grpc.UnaryServerInterceptor) error {
 	// "tcp", ":50051"
 	lis, err := net.Listen(network, address)
 	if err != nil {
 		log.Fatalf("failed to listen: %v", err)
 	}
-	s := grpc.NewServer()
+	s := grpc.NewServer(grpc.ChainUnaryInterceptor(interceptors...))
 	pb.RegisterAuthServer(s, as)
 
 	if err := s.Serve(lis); err != nil {
@@ -30,9 +30,9 @@ func (s *testServer) CreateAuthenticationChallenge(ctx context.Context, req *pb.
 }
 
 // VerifyAuthentication is a mock implementation that returns a fixed
-// AuthenticationAnswerResponse with a dummy SessionId.
+// AuthenticationAnswerResponse with a dummy SessionToken.
 func (s *testServer) VerifyAuthentication(ctx context.Context, req *pb.AuthenticationAnswerRequest) (*pb.AuthenticationAnswerResponse, error) {
-	return &pb.AuthenticationAnswerResponse{SessionId: "session123"}, nil
+	return &pb.AuthenticationAnswerResponse{SessionToken: "session123"}, nil
 }
 
 // TestConnection is a test function that sets up a mock gRPC server and client
@@ -0,0 +1,39 @@
+package ratelimit
+
+import (
+	"context"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	zgrpc "zkp-api/pkg/http/grpc"
+)
+
+// RetryAfterKey is the metadata key of the seconds a rejected caller has to wait before retrying.
+const RetryAfterKey = "retry-after"
+
+// UnaryServerInterceptor returns a gRPC interceptor that takes the tokens of each call from the limiter.
+// The user is the one of the requests that have one and the client address is returned by clientAddr.
+// Calls over the limits fail with the ResourceExhausted status and ErrorInfo details of the RATE_LIMITED reason,
+// carrying how long to wait both as RetryInfo details and in the retry-after header.
+func UnaryServerInterceptor(l *Limiter, clientAddr func(ctx context.Context) string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		var user string
+		if r, ok := req.(interface{ GetUser() string }); ok {
+			user = r.GetUser()
+		}
+		ok, wait := l.Allow(info.FullMethod, user, clientAddr(ctx))
+		if ok {
+			return handler(ctx, req)
+		}
+		_ = grpc.SetHeader(ctx, metadata.Pairs(RetryAfterKey, retryAfter(wait)))
+		st := status.New(codes.ResourceExhausted, "rate limit exceeded")
+		reason := &errdetails.ErrorInfo{Reason: zgrpc.ReasonRateLimited, Domain: zgrpc.ErrorDomain}
+		if dst, err := st.WithDetails(reason, &errdetails.RetryInfo{RetryDelay: durationpb.New(wait)}); err == nil {
+			st = dst
+		}
+		return nil, st.Err()
+	}
+}
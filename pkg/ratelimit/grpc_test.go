@@ -0,0 +1,40 @@
+package ratelimit
+
+import (
+	"context"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"testing"
+	"time"
+	pb "zkp-api/pkg/http/grpc/zkp"
+)
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	l, _ := newTestLimiter(Policy{User: Rate{Rate: 0.5, Burst: 1}})
+	intercept := UnaryServerInterceptor(l, func(context.Context) string { return "10.0.0.1" })
+	info := &grpc.UnaryServerInfo{FullMethod: "/zkpauth.Auth/Login"}
+	handler := func(ctx context.Context, req any) (any, error) { return &pb.LoginResponse{}, nil }
+
+	if _, err := intercept(context.Background(), &pb.LoginRequest{User: "jon"}, info, handler); err != nil {
+		t.Fatalf("call rejected: %s", err.Error())
+	}
+	_, err := intercept(context.Background(), &pb.LoginRequest{User: "jon"}, info, handler)
+	st := status.Convert(err)
+	if st.Code() != codes.ResourceExhausted {
+		t.Fatalf("got code %s, want %s", st.Code(), codes.ResourceExhausted)
+	}
+	var retry *errdetails.RetryInfo
+	for _, d := range st.Details() {
+		if ri, ok := d.(*errdetails.RetryInfo); ok {
+			retry = ri
+		}
+	}
+	if retry == nil || retry.GetRetryDelay().AsDuration() != 2*time.Second {
+		t.Fatalf("got retry info %v", retry)
+	}
+	if _, err = intercept(context.Background(), &pb.LoginRequest{User: "ana"}, info, handler); err != nil {
+		t.Fatalf("call of other user rejected: %s", err.Error())
+	}
+}
@@ -0,0 +1,38 @@
+package ratelimit
+
+import (
+	"net"
+	"net/http"
+	"time"
+)
+
+// Middleware returns an HTTP middleware that takes the tokens of each request from the limiter.
+// The method is the path of the request, the user is returned by user, which can be nil if requests are not limited
+// per user, and the client address is the remote address of the connection.
+// Requests over the limits are rejected by reject, with how long until they would be admitted, or with 429 Too Many Requests
+// and a Retry-After header if it is nil.
+func Middleware(l *Limiter, user func(r *http.Request) string,
+	reject func(w http.ResponseWriter, r *http.Request, wait time.Duration)) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var u string
+			if user != nil {
+				u = user(r)
+			}
+			client, _, err := net.SplitHostPort(r.RemoteAddr)
+			if err != nil {
+				client = r.RemoteAddr
+			}
+			if ok, wait := l.Allow(r.URL.Path, u, client); !ok {
+				if reject != nil {
+					reject(w, r, wait)
+					return
+				}
+				w.Header().Set("Retry-After", retryAfter(wait))
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,38 @@
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMiddleware(t *testing.T) {
+	l, _ := newTestLimiter(Policy{User: Rate{Rate: 1, Burst: 1}, Client: Rate{Rate: 1, Burst: 2}})
+	user := func(r *http.Request) string { return r.Header.Get("X-User") }
+	h := Middleware(l, user, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	tests := []struct {
+		name, user, remote string
+		want               int
+	}{
+		{"admitted", "jon", "10.0.0.1:5000", http.StatusNoContent},
+		{"user limit", "jon", "10.0.0.2:5000", http.StatusTooManyRequests},
+		{"other user", "ana", "10.0.0.1:5001", http.StatusNoContent},
+		{"client limit", "bob", "10.0.0.1:5002", http.StatusTooManyRequests},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPost, "/login", nil)
+		req.Header.Set("X-User", tt.user)
+		req.RemoteAddr = tt.remote
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Fatalf("%s: got status %d, want %d", tt.name, rec.Code, tt.want)
+		}
+		if tt.want == http.StatusTooManyRequests && rec.Header().Get("Retry-After") != "1" {
+			t.Fatalf("%s: got Retry-After '%s'", tt.name, rec.Header().Get("Retry-After"))
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package ratelimit
+
+import (
+	"math"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often the buckets that refilled are deleted, so that the limiter does not grow with every user
+// and client ever seen.
+const sweepInterval = time.Minute
+
+// Rate is the refill rate of a token bucket, in tokens per second, and its capacity.
+// A bucket without rate does not limit anything.
+type Rate struct {
+	Rate  float64 `yaml:"rate"`  // tokens added per second
+	Burst int     `yaml:"burst"` // most tokens the bucket holds, at least 1
+}
+
+// capacity returns the most tokens a bucket of the rate holds.
+func (r Rate) capacity() float64 {
+	if r.Burst < 1 {
+		return 1
+	}
+	return float64(r.Burst)
+}
+
+// Policy holds the token buckets every request takes a token from, a request is only admitted if all of them have one.
+type Policy struct {
+	// Methods are the buckets of each method (gRPC full method or HTTP path), shared by every caller
+	Methods map[string]Rate `yaml:"methods"`
+	User    Rate            `yaml:"user"`   // bucket of each user, shared by every method
+	Client  Rate            `yaml:"client"` // bucket of each client address, shared by every method
+}
+
+// bucket is the state of a token bucket: its rate and the tokens it had at its last update.
+type bucket struct {
+	rate   Rate
+	tokens float64
+	last   time.Time
+}
+
+// Limiter is an in-memory token bucket rate limiter.
+// It uses a mutex for concurrent access protection.
+// note the buckets are kept per process, every replica of a server admits its own share of the configured rates.
+type Limiter struct {
+	mu        sync.Mutex
+	policy    Policy
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// NewLimiter initializes a new Limiter with the policy.
+// It returns a pointer to the created Limiter.
+func NewLimiter(policy Policy) *Limiter {
+	return &Limiter{
+		policy:    policy,
+		buckets:   make(map[string]*bucket),
+		lastSweep: time.Now(),
+		now:       time.Now,
+	}
+}
+
+// limit is a bucket a request takes a token from.
+type limit struct {
+	key  string
+	rate Rate
+}
+
+// limits returns the buckets with a rate a request takes a token from, users and clients are skipped if empty.
+func (l *Limiter) limits(method, user, client string) []limit {
+	limits := make([]limit, 0, 3)
+	for _, lm := range []limit{
+		{"method:" + method, l.policy.Methods[method]},
+		{"user:" + user, l.policy.User},
+		{"client:" + client, l.policy.Client},
+	} {
+		if lm.rate.Rate <= 0 || lm.key == "user:" || lm.key == "client:" {
+			continue
+		}
+		limits = append(limits, lm)
+	}
+	return limits
+}
+
+// Allow takes a token from the buckets of the method, the user and the client of a request.
+// Tokens are only taken if every bucket has one, so rejected requests do not drain the others.
+// Returns whether the request is admitted and, if it is not, how long until it would be.
+func (l *Limiter) Allow(method, user, client string) (bool, time.Duration) {
+	limits := l.limits(method, user, client)
+	if len(limits) == 0 {
+		return true, 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	l.sweep(now)
+
+	var wait time.Duration
+	buckets := make([]*bucket, len(limits))
+	for i, lm := range limits {
+		b := l.buckets[lm.key]
+		if b == nil {
+			b = &bucket{rate: lm.rate, tokens: lm.rate.capacity(), last: now}
+			l.buckets[lm.key] = b
+		}
+		b.refill(now)
+		if b.tokens < 1 {
+			if d := time.Duration(math.Ceil((1 - b.tokens) / lm.rate.Rate * float64(time.Second))); d > wait {
+				wait = d
+			}
+		}
+		buckets[i] = b
+	}
+	if wait > 0 {
+		return false, wait
+	}
+	for _, b := range buckets {
+		b.tokens--
+	}
+	return true, 0
+}
+
+// refill adds the tokens of the rate since the last update of the bucket, up to its capacity.
+func (b *bucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = math.Min(b.rate.capacity(), b.tokens+elapsed.Seconds()*b.rate.Rate)
+		b.last = now
+	}
+}
+
+// full returns whether the bucket would be full at the given time.
+func (b *bucket) full(now time.Time) bool {
+	return b.tokens+now.Sub(b.last).Seconds()*b.rate.Rate >= b.rate.capacity()
+}
+
+// sweep deletes the buckets that refilled since their last update every sweepInterval, they are recreated full when needed.
+// note the caller has to hold the lock.
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < sweepInterval {
+		return
+	}
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		if b.full(now) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// retryAfter returns the seconds a client has to wait, rounded up, as the value of a Retry-After header.
+func retryAfter(wait time.Duration) string {
+	secs := int64(math.Ceil(wait.Seconds()))
+	if secs < 1 {
+		secs = 1
+	}
+	return strconv.FormatInt(secs, 10)
+}
@@ -0,0 +1,115 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+// newTestLimiter returns a limiter with the policy whose clock is moved by the returned pointer.
+func newTestLimiter(policy Policy) (*Limiter, *time.Time) {
+	l := NewLimiter(policy)
+	now := time.Now()
+	l.now = func() time.Time { return now }
+	return l, &now
+}
+
+func TestAllow(t *testing.T) {
+	policy := Policy{
+		Methods: map[string]Rate{"/login": {Rate: 1, Burst: 3}},
+		User:    Rate{Rate: 0.5, Burst: 2},
+		Client:  Rate{Rate: 10, Burst: 5},
+	}
+
+	tests := []struct {
+		name string
+		// requests of method, user and client admitted in a row before the rejected one
+		method, user, client string
+		admitted             int
+		wait                 time.Duration
+	}{
+		{"method", "/login", "", "", 3, time.Second},
+		{"user", "/register", "jon", "", 2, 2 * time.Second},
+		{"client", "/register", "", "10.0.0.1", 5, 100 * time.Millisecond},
+		{"strictest bucket", "/login", "jon", "10.0.0.1", 2, 2 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, now := newTestLimiter(policy)
+			for i := 0; i < tt.admitted; i++ {
+				if ok, _ := l.Allow(tt.method, tt.user, tt.client); !ok {
+					t.Fatalf("request %d rejected", i)
+				}
+			}
+			ok, wait := l.Allow(tt.method, tt.user, tt.client)
+			if ok {
+				t.Fatalf("request over the limit admitted")
+			}
+			if wait != tt.wait {
+				t.Fatalf("got wait %s, want %s", wait, tt.wait)
+			}
+			*now = now.Add(wait - time.Millisecond)
+			if ok, _ = l.Allow(tt.method, tt.user, tt.client); ok {
+				t.Fatalf("request admitted before the wait")
+			}
+			*now = now.Add(time.Millisecond)
+			if ok, _ = l.Allow(tt.method, tt.user, tt.client); !ok {
+				t.Fatalf("request rejected after the wait")
+			}
+		})
+	}
+}
+
+func TestAllowUnlimited(t *testing.T) {
+	l, _ := newTestLimiter(Policy{Methods: map[string]Rate{"/login": {Rate: 1}}, User: Rate{Burst: 1}})
+	for i := 0; i < 100; i++ {
+		if ok, _ := l.Allow("/register", "jon", "10.0.0.1"); !ok {
+			t.Fatalf("request without limits rejected")
+		}
+	}
+	if ok, _ := l.Allow("/login", "jon", ""); !ok {
+		t.Fatalf("request rejected")
+	}
+	if ok, _ := l.Allow("/login", "jon", ""); ok {
+		t.Fatalf("request over the default burst of 1 admitted")
+	}
+}
+
+func TestAllowKeepsTokens(t *testing.T) {
+	l, _ := newTestLimiter(Policy{User: Rate{Rate: 1, Burst: 1}, Client: Rate{Rate: 1, Burst: 2}})
+	if ok, _ := l.Allow("/login", "jon", "10.0.0.1"); !ok {
+		t.Fatalf("request rejected")
+	}
+	// note the rejection by the bucket of jon does not take the last token of the client
+	if ok, _ := l.Allow("/login", "jon", "10.0.0.1"); ok {
+		t.Fatalf("request over the user limit admitted")
+	}
+	if ok, _ := l.Allow("/login", "ana", "10.0.0.1"); !ok {
+		t.Fatalf("rejected request took a token of the client")
+	}
+}
+
+func TestSweep(t *testing.T) {
+	l, now := newTestLimiter(Policy{User: Rate{Rate: 1, Burst: 10}, Client: Rate{Rate: 0.001, Burst: 10}})
+	l.Allow("/login", "jon", "10.0.0.1")
+	*now = now.Add(sweepInterval)
+	l.Allow("/login", "", "10.0.0.2")
+	if _, ok := l.buckets["user:jon"]; ok {
+		t.Fatalf("refilled bucket was not swept")
+	}
+	if _, ok := l.buckets["client:10.0.0.1"]; !ok {
+		t.Fatalf("bucket swept before refilling")
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	for wait, want := range map[time.Duration]string{
+		time.Millisecond:        "1",
+		time.Second:             "1",
+		1500 * time.Millisecond: "2",
+		time.Minute:             "60",
+	} {
+		if got := retryAfter(wait); got != want {
+			t.Fatalf("retry after %s: got '%s', want '%s'", wait, got, want)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package session
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Session tokens are JWTs (RFC 7519) signed with Ed25519 (RFC 8037), so any service holding the verification key
+// can validate them offline, e.g: with Verify or any JWT library supporting EdDSA.
+
+const (
+	// Algorithm is the JWS algorithm of the session tokens.
+	Algorithm = "EdDSA"
+	// DefaultTTL is how long a session token is valid when no TTL is configured.
+	DefaultTTL = time.Hour
+	// idSize is the size in bytes of the random session ids.
+	idSize = 16
+)
+
+var (
+	// ErrInvalidToken is returned when a token is malformed or its signature does not verify.
+	ErrInvalidToken = errors.New("invalid session token")
+	// ErrTokenExpired is returned when a token is used after its expiry.
+	ErrTokenExpired = errors.New("session token expired")
+)
+
+// Claims are the claims of a session token.
+type Claims struct {
+	Issuer    string `json:"iss,omitempty"`
+	Subject   string `json:"sub"` // user the session belongs to
+	IssuedAt  int64  `json:"iat"` // unix time
+	ExpiresAt int64  `json:"exp"` // unix time
+	ID        string `json:"jti"` // session id
+}
+
+// header is the JOSE header of a session token.
+type header struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ"`
+	KeyID     string `json:"kid"`
+}
+
+// VerificationKey is the public key session tokens are verified with, the one published to other services.
+type VerificationKey struct {
+	ID        string            // key id, the kid header of the tokens it verifies
+	Algorithm string            // JWS algorithm, always Algorithm
+	PublicKey ed25519.PublicKey // raw 32 bytes Ed25519 public key
+}
+
+// Issuer issues signed session tokens.
+type Issuer interface {
+	Issue(user string) (token string, claims *Claims, err error)
+	VerificationKey() *VerificationKey
+}
+
+// Signer is an Issuer that signs the tokens with an Ed25519 private key.
+type Signer struct {
+	key    ed25519.PrivateKey
+	kid    string
+	issuer string
+	ttl    time.Duration
+	now    func() time.Time // clock, replaced in tests
+}
+
+// NewSigner initializes a new Signer that signs with key tokens issued by issuer and valid for ttl,
+// DefaultTTL if ttl is zero.
+// It returns a pointer to the created Signer.
+func NewSigner(key ed25519.PrivateKey, issuer string, ttl time.Duration) Issuer {
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+	return &Signer{
+		key:    key,
+		kid:    KeyID(key.Public().(ed25519.PublicKey)),
+		issuer: issuer,
+		ttl:    ttl,
+		now:    time.Now,
+	}
+}
+
+// Issue issues a session token of user with a new random session id.
+// Returns the token and its claims or an error if the session id cannot be generated.
+func (s *Signer) Issue(user string) (string, *Claims, error) {
+	id := make([]byte, idSize)
+	if _, err := rand.Read(id); err != nil {
+		return "", nil, fmt.Errorf("error generating session id: %s", err.Error())
+	}
+	now := s.now()
+	claims := &Claims{
+		Issuer:    s.issuer,
+		Subject:   user,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(s.ttl).Unix(),
+		ID:        base64.RawURLEncoding.EncodeToString(id),
+	}
+	token, err := s.sign(claims)
+	if err != nil {
+		return "", nil, err
+	}
+	return token, claims, nil
+}
+
+// VerificationKey returns the public key of the signer.
+func (s *Signer) VerificationKey() *VerificationKey {
+	return &VerificationKey{
+		ID:        s.kid,
+		Algorithm: Algorithm,
+		PublicKey: s.key.Public().(ed25519.PublicKey),
+	}
+}
+
+// sign serializes the claims into a compact JWS signed with the signer key.
+func (s *Signer) sign(claims *Claims) (string, error) {
+	h, err := json.Marshal(header{Algorithm: Algorithm, Type: "JWT", KeyID: s.kid})
+	if err != nil {
+		return "", fmt.Errorf("error encoding token header: %s", err.Error())
+	}
+	c, err := json.Marshal(claims)
+	if err != nil {
+		return "", fmt.Errorf("error encoding token claims: %s", err.Error())
+	}
+	signed := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c)
+	sig := ed25519.Sign(s.key, []byte(signed))
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// Verify checks that token is signed by the private key of pub and not expired at the given time.
+// Returns the claims of the token, ErrTokenExpired if it is expired or ErrInvalidToken if it is not valid.
+func Verify(pub ed25519.PublicKey, token string, now time.Time) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || len(pub) != ed25519.PublicKeySize {
+		return nil, ErrInvalidToken
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !ed25519.Verify(pub, []byte(parts[0]+"."+parts[1]), sig) {
+		return nil, ErrInvalidToken
+	}
+
+	// note the header is only checked once the signature verifies, the algorithm is never taken from it
+	var h header
+	if err = decodeSegment(parts[0], &h); err != nil || h.Algorithm != Algorithm {
+		return nil, ErrInvalidToken
+	}
+	if h.KeyID != "" && h.KeyID != KeyID(pub) {
+		return nil, ErrInvalidToken
+	}
+	claims := &Claims{}
+	if err = decodeSegment(parts[1], claims); err != nil || claims.Subject == "" || claims.ID == "" {
+		return nil, ErrInvalidToken
+	}
+	if now.Unix() >= claims.ExpiresAt {
+		return nil, ErrTokenExpired
+	}
+	return claims, nil
+}
+
+// decodeSegment decodes a base64url JSON segment of a token into v.
+func decodeSegment(seg string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// KeyID returns the key id of an Ed25519 public key: the base64url encoding of the first 8 bytes of its SHA-256 hash.
+func KeyID(pub ed25519.PublicKey) string {
+	h := sha256.Sum256(pub)
+	return base64.RawURLEncoding.EncodeToString(h[:8])
+}
+
+// LoadKey reads an Ed25519 private key from a PEM encoded PKCS #8 file,
+// e.g: generated with `openssl genpkey -algorithm ed25519`.
+func LoadKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, fmt.Errorf("no PKCS #8 private key found in '%s'", path)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing private key: %s", err.Error())
+	}
+	edKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("private key in '%s' is not an Ed25519 key", path)
+	}
+	return edKey, nil
+}
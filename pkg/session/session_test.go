@@ -0,0 +1,106 @@
+package session
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestSigner returns a signer with a new key whose clock is fixed at now.
+func newTestSigner(t *testing.T, now time.Time) *Signer {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("error generating key: %s", err.Error())
+	}
+	s := NewSigner(key, "zkp-api", time.Hour).(*Signer)
+	s.now = func() time.Time { return now }
+	return s
+}
+
+func TestIssueVerify(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	s := newTestSigner(t, now)
+	vk := s.VerificationKey()
+
+	token, claims, err := s.Issue("jon")
+	if err != nil {
+		t.Fatalf("error issuing token: %s", err.Error())
+	}
+	got, err := Verify(vk.PublicKey, token, now)
+	if err != nil {
+		t.Fatalf("unable to verify: %s", err.Error())
+	}
+	if *got != *claims || got.Subject != "jon" || got.Issuer != "zkp-api" || got.ExpiresAt != now.Add(time.Hour).Unix() {
+		t.Fatalf("unexpected claims: %+v", got)
+	}
+
+	other, _, _ := s.Issue("jon")
+	if otherClaims, _ := Verify(vk.PublicKey, other, now); otherClaims.ID == claims.ID {
+		t.Fatalf("session ids must be unique")
+	}
+}
+
+func TestVerifyRejects(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	s := newTestSigner(t, now)
+	pub := s.VerificationKey().PublicKey
+	token, _, _ := s.Issue("jon")
+	parts := strings.Split(token, ".")
+	otherPub := newTestSigner(t, now).VerificationKey().PublicKey
+
+	// a token whose header asks for no signature
+	none := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","typ":"JWT"}`)) + "." + parts[1] + "."
+
+	tests := []struct {
+		name    string
+		pub     ed25519.PublicKey
+		token   string
+		now     time.Time
+		wantErr error
+	}{
+		{"expired", pub, token, now.Add(time.Hour), ErrTokenExpired},
+		{"other key", otherPub, token, now, ErrInvalidToken},
+		{"tampered claims", pub, parts[0] + "." + parts[0] + "." + parts[2], now, ErrInvalidToken},
+		{"no signature", pub, parts[0] + "." + parts[1] + ".", now, ErrInvalidToken},
+		{"alg none", pub, none, now, ErrInvalidToken},
+		{"malformed", pub, "not a token", now, ErrInvalidToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Verify(tt.pub, tt.token, tt.now); !errors.Is(err, tt.wantErr) {
+				t.Errorf("got error %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadKey(t *testing.T) {
+	_, key, _ := ed25519.GenerateKey(nil)
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("error encoding key: %s", err.Error())
+	}
+	path := filepath.Join(t.TempDir(), "session.pem")
+	if err = os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatalf("error writing key: %s", err.Error())
+	}
+
+	loaded, err := LoadKey(path)
+	if err != nil {
+		t.Fatalf("error loading key: %s", err.Error())
+	}
+	if !key.Equal(loaded) {
+		t.Fatalf("loaded key differs")
+	}
+	if _, err = LoadKey(filepath.Join(t.TempDir(), "missing.pem")); err == nil {
+		t.Fatalf("expected an error loading a missing key")
+	}
+}
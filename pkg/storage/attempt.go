@@ -0,0 +1,29 @@
+package storage
+
+import (
+	"context"
+	"time"
+)
+
+// AttemptData are the failed authentication attempts recorded under a key, e.g: a user or a client address.
+type AttemptData struct {
+	Failures    int       // consecutive failures within the window
+	LastFailure time.Time // time of the last failure
+}
+
+// AttemptStorage holds the failed authentication attempts, shared by every verifier replica using the same storage.
+type AttemptStorage interface {
+	// GetAttempts returns the attempts recorded under a key, no failures if there are none.
+	GetAttempts(ctx context.Context, key string) (*AttemptData, error)
+	// AddFailure records a failed attempt under a key at the given time and returns the updated attempts,
+	// failures whose last one is older than the window are forgotten first.
+	AddFailure(ctx context.Context, key string, now time.Time, window time.Duration) (*AttemptData, error)
+	// ReleaseFailure takes back the failure recorded under a key by AddFailure at the time failed, e.g: an attempt counted
+	// before its proof was checked that succeeded. The last failure goes back to last unless another failure was recorded
+	// since, the attempts are forgotten once no failure is left.
+	ReleaseFailure(ctx context.Context, key string, failed, last time.Time) error
+	// ResetAttempts forgets the attempts recorded under a key.
+	ResetAttempts(ctx context.Context, key string) error
+	// DeleteStaleAttempts removes the attempts whose last failure is before the given time and returns how many were removed.
+	DeleteStaleAttempts(ctx context.Context, before time.Time) (int, error)
+}
@@ -0,0 +1,544 @@
+package file
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+	"zkp-api/pkg/storage"
+	"zkp-api/pkg/zkp"
+)
+
+const (
+	walFile      = "users.wal"      // changes made since the last snapshot
+	snapshotFile = "users.snapshot" // every user as of the last snapshot
+)
+
+// Options holds the durability settings of a VerifierFileStorage, the defaults are used for the zero values.
+type Options struct {
+	Sync             SyncPolicy    // when the appended records are flushed, SyncAlways if empty
+	SyncInterval     time.Duration // how often the SyncInterval policy flushes the log, DefaultSyncInterval if zero
+	SnapshotInterval time.Duration // how often the log is compacted into a snapshot, DefaultSnapshotInterval if zero
+	SnapshotRecords  int           // records after which the log is compacted, DefaultSnapshotRecords if zero
+}
+
+// VerifierFileStorage is a persistent storage for verifier user data.
+// The users are kept in memory and every change is appended to a write-ahead log before it is applied, the log is
+// compacted into a snapshot of every user periodically and once it holds too many records.
+// On startup the snapshot is loaded and the log replayed, a record torn by a crash is discarded.
+// It uses a read-write mutex for concurrent access protection.
+// note the directory must only be used by a single verifier, replicas cannot share it.
+type VerifierFileStorage struct {
+	mu   sync.RWMutex
+	dir  string
+	opts Options
+	// users holds the verifier user data indexed by username.
+	users map[string]*storage.VerifierUserData
+
+	wal     *os.File
+	size    int64  // size of the valid records of the log, a failed append is truncated back to it
+	seq     uint64 // sequence number of the last change
+	records int    // records appended since the last snapshot
+	dirty   bool   // records appended since the last flush
+	// err is set when the log can no longer be appended to, e.g: after a failed flush, the writes fail until a snapshot
+	// rewrites it
+	err error
+
+	compact chan struct{}
+	done    chan struct{}
+	wg      sync.WaitGroup
+	// closeOnce closes the storage once, closeErr is the error of the first Close returned by every call
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// NewVerifierStorage opens the storage kept in the given directory, creating it if needed, and loads its users.
+// It starts the background flushing and compaction of the log, which are stopped by Close.
+// Returns an error if the snapshot or the log cannot be read.
+func NewVerifierStorage(dir string, opts Options) (*VerifierFileStorage, error) {
+	if opts.Sync == "" {
+		opts.Sync = SyncAlways
+	}
+	if opts.SyncInterval <= 0 {
+		opts.SyncInterval = DefaultSyncInterval
+	}
+	if opts.SnapshotInterval <= 0 {
+		opts.SnapshotInterval = DefaultSnapshotInterval
+	}
+	if opts.SnapshotRecords <= 0 {
+		opts.SnapshotRecords = DefaultSnapshotRecords
+	}
+	if dir == "" {
+		return nil, fmt.Errorf("no storage directory")
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("unable to create storage directory: %w", err)
+	}
+
+	s := &VerifierFileStorage{
+		dir:     dir,
+		opts:    opts,
+		users:   make(map[string]*storage.VerifierUserData),
+		compact: make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+	if err := s.loadSnapshot(); err != nil {
+		return nil, err
+	}
+	if err := s.replay(); err != nil {
+		return nil, err
+	}
+
+	s.wg.Add(1)
+	go s.run()
+	return s, nil
+}
+
+// loadSnapshot loads the users of the snapshot, if any.
+func (s *VerifierFileStorage) loadSnapshot() error {
+	f, err := os.Open(filepath.Join(s.dir, snapshotFile))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("unable to open snapshot: %w", err)
+	}
+	defer f.Close()
+
+	// note snapshots are renamed into place once complete, so unlike the log a torn snapshot is a corruption
+	r := bufio.NewReader(f)
+	var header record
+	if err := readRecord(r, &header); err != nil {
+		return fmt.Errorf("unable to read snapshot header: %w", err)
+	}
+	if header.Op != opSnapshot || header.Version != snapshotVersion {
+		return fmt.Errorf("unknown snapshot format '%s' version %d", header.Op, header.Version)
+	}
+	for i := 0; i < header.Users; i++ {
+		var rec record
+		if err := readRecord(r, &rec); err != nil {
+			return fmt.Errorf("unable to read snapshot user %d: %w", i, err)
+		}
+		if err := s.apply(&rec); err != nil {
+			return fmt.Errorf("unable to load snapshot user %d: %w", i, err)
+		}
+	}
+	if _, err := readFrame(r); err != io.EOF {
+		return fmt.Errorf("unexpected data after %d snapshot users", header.Users)
+	}
+	s.seq = header.Seq
+	return nil
+}
+
+// replay applies the records of the log made after the snapshot, then opens the log for appending.
+// A torn record, and anything after it, is truncated from the log.
+func (s *VerifierFileStorage) replay() error {
+	f, err := os.OpenFile(filepath.Join(s.dir, walFile), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("unable to open write-ahead log: %w", err)
+	}
+
+	r := bufio.NewReader(f)
+	var size int64
+	for {
+		payload, err := readFrame(r)
+		if err == io.EOF {
+			break
+		}
+		if err == errTornFrame {
+			log.Printf("discarding torn write-ahead log record at offset %d", size)
+			if err = f.Truncate(size); err == nil {
+				err = f.Sync()
+			}
+			if err != nil {
+				f.Close()
+				return fmt.Errorf("unable to truncate write-ahead log: %w", err)
+			}
+			break
+		}
+		if err != nil {
+			f.Close()
+			return fmt.Errorf("unable to read write-ahead log: %w", err)
+		}
+
+		var rec record
+		if err = json.Unmarshal(payload, &rec); err != nil {
+			f.Close()
+			return fmt.Errorf("unable to decode write-ahead log record at offset %d: %w", size, err)
+		}
+		size += int64(frameHeaderSize + len(payload))
+		s.records++
+		// note the records already in the snapshot are kept if the log could not be truncated after it was taken
+		if rec.Seq <= s.seq {
+			continue
+		}
+		if rec.Seq != s.seq+1 {
+			f.Close()
+			return fmt.Errorf("write-ahead log record %d follows %d", rec.Seq, s.seq)
+		}
+		if err = s.apply(&rec); err != nil {
+			f.Close()
+			return fmt.Errorf("unable to replay write-ahead log record %d: %w", rec.Seq, err)
+		}
+		s.seq = rec.Seq
+	}
+
+	s.wal = f
+	s.size = size
+	return nil
+}
+
+// readRecord reads the next frame and decodes its record.
+func readRecord(r *bufio.Reader, rec *record) error {
+	payload, err := readFrame(r)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(payload, rec)
+}
+
+// apply makes the change of a record to the users.
+// Returns an error if it does not apply to them, which the writes check before appending the record.
+func (s *VerifierFileStorage) apply(rec *record) error {
+	d := s.users[rec.User]
+	switch rec.Op {
+	case opAddUser:
+		if d != nil {
+			return fmt.Errorf("user %w", storage.ErrExists)
+		}
+		if rec.Data == nil {
+			return fmt.Errorf("missing user data")
+		}
+		s.users[rec.User] = &storage.VerifierUserData{
+			State:    rec.Data.State,
+			Protocol: rec.Data.Protocol,
+			Salt:     rec.Data.Salt,
+			KDF:      rec.Data.KDF,
+			Y1:       rec.Data.Y1,
+			Y2:       rec.Data.Y2,
+		}
+	case opUpdateCommitments:
+		if d == nil {
+			return fmt.Errorf("user %w", storage.ErrNotFound)
+		}
+		if rec.Data == nil {
+			return fmt.Errorf("missing user data")
+		}
+		d.Salt = rec.Data.Salt
+		d.KDF = rec.Data.KDF
+		d.Y1 = rec.Data.Y1
+		d.Y2 = rec.Data.Y2
+	case opSetUserState:
+		if d == nil {
+			return fmt.Errorf("user %w", storage.ErrNotFound)
+		}
+		d.State = rec.State
+	case opDeleteUser:
+		if d == nil {
+			return fmt.Errorf("user %w", storage.ErrNotFound)
+		}
+		delete(s.users, rec.User)
+	default:
+		return fmt.Errorf("unknown operation '%s'", rec.Op)
+	}
+	return nil
+}
+
+// write appends the record of a change to the log, flushing it if the sync policy requires it, and then applies it.
+// Nothing is applied if the record cannot be appended.
+// note the caller has to hold the write lock.
+func (s *VerifierFileStorage) write(rec *record) error {
+	if s.err != nil {
+		return fmt.Errorf("write-ahead log unavailable: %w", s.err)
+	}
+	rec.Seq = s.seq + 1
+	payload, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	frame := encodeFrame(payload)
+	if _, err = s.wal.Write(frame); err == nil && s.opts.Sync == SyncAlways {
+		// note after a failed flush the state of the written data is unknown, the log is not appended to any more
+		// until a snapshot rewrites it
+		if err = s.wal.Sync(); err != nil {
+			s.err = err
+		}
+	}
+	if err != nil {
+		if terr := s.wal.Truncate(s.size); terr != nil {
+			s.err = terr
+		}
+		return fmt.Errorf("unable to append to write-ahead log: %w", err)
+	}
+
+	s.size += int64(len(frame))
+	s.seq = rec.Seq
+	s.dirty = s.opts.Sync != SyncAlways
+	if s.records++; s.records >= s.opts.SnapshotRecords {
+		select {
+		case s.compact <- struct{}{}:
+		default:
+		}
+	}
+	return s.apply(rec)
+}
+
+// run flushes the log every sync interval, if the policy is SyncInterval, and compacts it every snapshot interval or
+// once it holds too many records, until the storage is closed.
+func (s *VerifierFileStorage) run() {
+	defer s.wg.Done()
+	snapshots := time.NewTicker(s.opts.SnapshotInterval)
+	defer snapshots.Stop()
+	var syncs <-chan time.Time
+	if s.opts.Sync == SyncInterval {
+		t := time.NewTicker(s.opts.SyncInterval)
+		defer t.Stop()
+		syncs = t.C
+	}
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-syncs:
+			if err := s.Sync(); err != nil {
+				log.Printf(err.Error())
+			}
+		case <-snapshots.C:
+			if err := s.Compact(); err != nil {
+				log.Printf(err.Error())
+			}
+		case <-s.compact:
+			if err := s.Compact(); err != nil {
+				log.Printf(err.Error())
+			}
+		}
+	}
+}
+
+// Sync flushes the records appended to the log since the last flush.
+func (s *VerifierFileStorage) Sync() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sync()
+}
+
+// sync flushes the log if records were appended since the last flush.
+// note the caller has to hold the write lock.
+func (s *VerifierFileStorage) sync() error {
+	if !s.dirty || s.err != nil {
+		return nil
+	}
+	if err := s.wal.Sync(); err != nil {
+		s.err = err
+		return fmt.Errorf("unable to flush write-ahead log: %w", err)
+	}
+	s.dirty = false
+	return nil
+}
+
+// Compact writes a snapshot of every user and empties the log.
+// The snapshot is written to a temporary file which is renamed into place once flushed, so a crash leaves either the
+// previous snapshot and the whole log or the new snapshot, whose sequence number tells the records to skip in the log.
+// It locks the storage for writing, the writes wait for the snapshot to be taken.
+func (s *VerifierFileStorage) Compact() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.records == 0 && s.err == nil {
+		return nil
+	}
+
+	tmp := filepath.Join(s.dir, snapshotFile+".tmp")
+	if err := s.writeSnapshot(tmp); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("unable to write snapshot: %w", err)
+	}
+	if err := os.Rename(tmp, filepath.Join(s.dir, snapshotFile)); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("unable to write snapshot: %w", err)
+	}
+	if err := syncDir(s.dir); err != nil {
+		return fmt.Errorf("unable to write snapshot: %w", err)
+	}
+
+	// every record is in the snapshot now, including the ones whose flush failed
+	if err := s.wal.Truncate(0); err != nil {
+		return fmt.Errorf("unable to truncate write-ahead log: %w", err)
+	}
+	if err := s.wal.Sync(); err != nil {
+		return fmt.Errorf("unable to truncate write-ahead log: %w", err)
+	}
+	s.size = 0
+	s.records = 0
+	s.dirty = false
+	s.err = nil
+	return nil
+}
+
+// writeSnapshot writes a snapshot of every user to the given file and flushes it.
+func (s *VerifierFileStorage) writeSnapshot(path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	header := record{Seq: s.seq, Op: opSnapshot, Version: snapshotVersion, Users: len(s.users)}
+	if err = writeRecord(w, &header); err != nil {
+		return err
+	}
+	for user, d := range s.users {
+		rec := record{Seq: s.seq, Op: opAddUser, User: user, Data: &userData{
+			State:    d.State,
+			Protocol: d.Protocol,
+			Salt:     d.Salt,
+			KDF:      d.KDF,
+			Y1:       d.Y1,
+			Y2:       d.Y2,
+		}}
+		if err = writeRecord(w, &rec); err != nil {
+			return err
+		}
+	}
+	if err = w.Flush(); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// writeRecord encodes a record and writes its frame.
+func writeRecord(w io.Writer, rec *record) error {
+	payload, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(encodeFrame(payload))
+	return err
+}
+
+// Close stops the background flushing and compaction, compacts the log and closes it.
+// Closing the storage again does nothing and returns the error of the first Close.
+func (s *VerifierFileStorage) Close() error {
+	s.closeOnce.Do(func() {
+		s.closeErr = s.close()
+	})
+	return s.closeErr
+}
+
+// close stops the background goroutines, compacts and closes the log.
+func (s *VerifierFileStorage) close() error {
+	close(s.done)
+	s.wg.Wait()
+	err := s.Compact()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if serr := s.sync(); err == nil {
+		err = serr
+	}
+	if cerr := s.wal.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// AddUser adds a new user to the storage with the provided username and registration data, that is the account state,
+// the zkp protocol, the KDF salt and parameters and the public commitments (y1, y2).
+// It locks the storage for writing, checks if the user already exists, and if not,
+// logs and adds the user to the storage. Returns an error if the user already exists.
+func (s *VerifierFileStorage) AddUser(ctx context.Context, user string, usr *storage.VerifierUserData) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if d := s.users[user]; d != nil {
+		return fmt.Errorf("user %w", storage.ErrExists)
+	}
+	return s.write(&record{Op: opAddUser, User: user, Data: &userData{
+		State:    usr.State,
+		Protocol: usr.Protocol,
+		Salt:     usr.Salt,
+		KDF:      usr.KDF,
+		Y1:       usr.Y1,
+		Y2:       usr.Y2,
+	}})
+}
+
+// UpdateUserCommitments replaces the salt, the KDF parameters and the public commitments (y1, y2) of a given user.
+// It locks the storage for writing, checks if the user exists, and if so,
+// logs and updates the user's credentials. Returns an error if the user does not exist.
+func (s *VerifierFileStorage) UpdateUserCommitments(ctx context.Context, user string, salt []byte, kdf zkp.KDFParams, y1, y2 []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if d := s.users[user]; d == nil {
+		return fmt.Errorf("user %w", storage.ErrNotFound)
+	}
+	return s.write(&record{Op: opUpdateCommitments, User: user, Data: &userData{Salt: salt, KDF: kdf, Y1: y1, Y2: y2}})
+}
+
+// GetUser retrieves a copy of the verifier user data for the given user from the storage.
+// It locks the storage for reading, checks if the user exists, and if so,
+// returns the user's data. Returns an error if the user does not exist.
+func (s *VerifierFileStorage) GetUser(ctx context.Context, user string) (*storage.VerifierUserData, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	d := s.users[user]
+	if d == nil {
+		return nil, fmt.Errorf("user %w", storage.ErrNotFound)
+	}
+	usr := *d
+	return &usr, nil
+}
+
+// CheckUser checks if a user exists in the storage.
+// It locks the storage for reading and returns true if the user exists, false otherwise.
+func (s *VerifierFileStorage) CheckUser(ctx context.Context, user string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.users[user] != nil, nil
+}
+
+// SetUserState updates the lifecycle state of a given user in the storage.
+// It locks the storage for writing, checks if the user exists, and if so,
+// logs and updates the user's state. Returns an error if the user does not exist.
+func (s *VerifierFileStorage) SetUserState(ctx context.Context, user string, state storage.UserState) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if d := s.users[user]; d == nil {
+		return fmt.Errorf("user %w", storage.ErrNotFound)
+	}
+	return s.write(&record{Op: opSetUserState, User: user, State: state})
+}
+
+// DeleteUser deletes a given user from the storage.
+// It locks the storage for writing and returns an error if the user does not exist.
+func (s *VerifierFileStorage) DeleteUser(ctx context.Context, user string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if d := s.users[user]; d == nil {
+		return fmt.Errorf("user %w", storage.ErrNotFound)
+	}
+	return s.write(&record{Op: opDeleteUser, User: user})
+}
@@ -0,0 +1,284 @@
+package file
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"zkp-api/pkg/storage"
+	"zkp-api/pkg/zkp"
+)
+
+// open opens the storage of dir with the options, failing the test on error.
+func open(t *testing.T, dir string, opts Options) *VerifierFileStorage {
+	t.Helper()
+	s, err := NewVerifierStorage(dir, opts)
+	if err != nil {
+		t.Fatalf("unable to open storage: %s", err.Error())
+	}
+	return s
+}
+
+// crash stops a storage without compacting or closing its log, as a crash would.
+func crash(s *VerifierFileStorage) {
+	close(s.done)
+	s.wg.Wait()
+	s.wal.Close()
+}
+
+// testUser returns the registration data of a user whose commitments are derived from its name.
+func testUser(name string) *storage.VerifierUserData {
+	return &storage.VerifierUserData{
+		State:    storage.UserActive,
+		Protocol: zkp.Ristretto255,
+		Salt:     []byte("salt-" + name),
+		KDF:      zkp.DefaultKDFParams,
+		Y1:       []byte("y1-" + name),
+		Y2:       []byte("y2-" + name),
+	}
+}
+
+// populate makes a change of every kind: adds jon, ana and bob, rotates the credentials of ana, locks bob and deletes jon.
+func populate(t *testing.T, s *VerifierFileStorage) {
+	t.Helper()
+	ctx := context.Background()
+	for _, user := range []string{"jon", "ana", "bob"} {
+		if err := s.AddUser(ctx, user, testUser(user)); err != nil {
+			t.Fatalf("unable to add %s: %s", user, err.Error())
+		}
+	}
+	if err := s.UpdateUserCommitments(ctx, "ana", []byte("salt-2"), zkp.KDFParams{Time: 1, Memory: 8, Threads: 1}, []byte("y1-2"), []byte("y2-2")); err != nil {
+		t.Fatalf("unable to update commitments: %s", err.Error())
+	}
+	if err := s.SetUserState(ctx, "bob", storage.UserLocked); err != nil {
+		t.Fatalf("unable to set state: %s", err.Error())
+	}
+	if err := s.DeleteUser(ctx, "jon"); err != nil {
+		t.Fatalf("unable to delete user: %s", err.Error())
+	}
+}
+
+// checkPopulated checks that a storage holds the users left by populate.
+func checkPopulated(t *testing.T, s *VerifierFileStorage) {
+	t.Helper()
+	ctx := context.Background()
+	if ok, _ := s.CheckUser(ctx, "jon"); ok {
+		t.Fatalf("deleted user jon was restored")
+	}
+	ana, err := s.GetUser(ctx, "ana")
+	if err != nil {
+		t.Fatalf("unable to get ana: %s", err.Error())
+	}
+	if !bytes.Equal(ana.Salt, []byte("salt-2")) || !bytes.Equal(ana.Y1, []byte("y1-2")) || !bytes.Equal(ana.Y2, []byte("y2-2")) ||
+		ana.KDF != (zkp.KDFParams{Time: 1, Memory: 8, Threads: 1}) || ana.Protocol != zkp.Ristretto255 || ana.State != storage.UserActive {
+		t.Fatalf("got ana %+v", ana)
+	}
+	bob, err := s.GetUser(ctx, "bob")
+	if err != nil {
+		t.Fatalf("unable to get bob: %s", err.Error())
+	}
+	if bob.State != storage.UserLocked || !bytes.Equal(bob.Salt, []byte("salt-bob")) {
+		t.Fatalf("got bob %+v", bob)
+	}
+}
+
+func TestRecovery(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name string
+		stop func(t *testing.T, s *VerifierFileStorage)
+	}{
+		{"close", func(t *testing.T, s *VerifierFileStorage) {
+			if err := s.Close(); err != nil {
+				t.Fatalf("unable to close storage: %s", err.Error())
+			}
+		}},
+		{"crash", func(t *testing.T, s *VerifierFileStorage) { crash(s) }},
+		{"crash after snapshot", func(t *testing.T, s *VerifierFileStorage) {
+			if err := s.Compact(); err != nil {
+				t.Fatalf("unable to compact: %s", err.Error())
+			}
+			if err := s.AddUser(ctx, "eve", testUser("eve")); err != nil {
+				t.Fatalf("unable to add eve: %s", err.Error())
+			}
+			if err := s.DeleteUser(ctx, "eve"); err != nil {
+				t.Fatalf("unable to delete eve: %s", err.Error())
+			}
+			crash(s)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			s := open(t, dir, Options{})
+			populate(t, s)
+			tt.stop(t, s)
+
+			s = open(t, dir, Options{})
+			defer s.Close()
+			checkPopulated(t, s)
+			// the storage keeps appending after its recovery
+			if err := s.AddUser(ctx, "jon", testUser("jon")); err != nil {
+				t.Fatalf("unable to add jon again: %s", err.Error())
+			}
+			if err := s.AddUser(ctx, "ana", testUser("ana")); !errors.Is(err, storage.ErrExists) {
+				t.Fatalf("got error %v, want %v", err, storage.ErrExists)
+			}
+		})
+	}
+}
+
+func TestCompact(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	s := open(t, dir, Options{})
+	populate(t, s)
+	wal, err := os.ReadFile(filepath.Join(dir, walFile))
+	if err != nil {
+		t.Fatalf("unable to read log: %s", err.Error())
+	}
+	if err = s.Compact(); err != nil {
+		t.Fatalf("unable to compact: %s", err.Error())
+	}
+	if info, _ := os.Stat(filepath.Join(dir, walFile)); info.Size() != 0 {
+		t.Fatalf("log not truncated, %d bytes", info.Size())
+	}
+	crash(s)
+
+	// a crash before the log is truncated leaves records that are already in the snapshot
+	if err = os.WriteFile(filepath.Join(dir, walFile), wal, 0o600); err != nil {
+		t.Fatalf("unable to restore log: %s", err.Error())
+	}
+	s = open(t, dir, Options{})
+	checkPopulated(t, s)
+	if err = s.SetUserState(ctx, "ana", storage.UserDisabled); err != nil {
+		t.Fatalf("unable to set state: %s", err.Error())
+	}
+	crash(s)
+
+	s = open(t, dir, Options{})
+	defer s.Close()
+	if ana, _ := s.GetUser(ctx, "ana"); ana == nil || ana.State != storage.UserDisabled {
+		t.Fatalf("got ana %+v", ana)
+	}
+}
+
+func TestSnapshotRecords(t *testing.T) {
+	dir := t.TempDir()
+	s := open(t, dir, Options{Sync: SyncNone, SnapshotRecords: 3})
+	populate(t, s)
+	if err := s.Close(); err != nil {
+		t.Fatalf("unable to close storage: %s", err.Error())
+	}
+	if _, err := os.Stat(filepath.Join(dir, snapshotFile)); err != nil {
+		t.Fatalf("no snapshot taken: %s", err.Error())
+	}
+	s = open(t, dir, Options{})
+	defer s.Close()
+	checkPopulated(t, s)
+}
+
+func TestCloseTwice(t *testing.T) {
+	s := open(t, t.TempDir(), Options{})
+	populate(t, s)
+	if err := s.Close(); err != nil {
+		t.Fatalf("unable to close storage: %s", err.Error())
+	}
+	// e.g: a deferred close along with the one of the shutdown
+	if err := s.Close(); err != nil {
+		t.Fatalf("unable to close storage again: %s", err.Error())
+	}
+}
+
+func TestTornRecord(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name string
+		tail func(frame []byte) []byte // what is left of the last record
+	}{
+		{"partial header", func(frame []byte) []byte { return frame[:frameHeaderSize-3] }},
+		{"partial payload", func(frame []byte) []byte { return frame[:len(frame)-5] }},
+		{"checksum mismatch", func(frame []byte) []byte {
+			torn := bytes.Clone(frame)
+			torn[len(torn)-2] ^= 0xff
+			return torn
+		}},
+		{"oversized length", func(frame []byte) []byte { return []byte{0xff, 0xff, 0xff, 0xff, 0, 0, 0, 0} }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			s := open(t, dir, Options{})
+			populate(t, s)
+			crash(s)
+
+			path := filepath.Join(dir, walFile)
+			wal, _ := os.ReadFile(path)
+			frame := encodeFrame([]byte(`{"seq":9,"op":"add_user","user":"eve","data":{}}`))
+			if err := os.WriteFile(path, append(wal, tt.tail(frame)...), 0o600); err != nil {
+				t.Fatalf("unable to tear log: %s", err.Error())
+			}
+
+			s = open(t, dir, Options{})
+			checkPopulated(t, s)
+			if ok, _ := s.CheckUser(ctx, "eve"); ok {
+				t.Fatalf("torn record was applied")
+			}
+			if info, _ := os.Stat(path); info.Size() != int64(len(wal)) {
+				t.Fatalf("got log of %d bytes, want %d", info.Size(), len(wal))
+			}
+			if err := s.AddUser(ctx, "eve", testUser("eve")); err != nil {
+				t.Fatalf("unable to add eve: %s", err.Error())
+			}
+			crash(s)
+
+			s = open(t, dir, Options{})
+			defer s.Close()
+			if ok, _ := s.CheckUser(ctx, "eve"); !ok {
+				t.Fatalf("record appended after the recovery was lost")
+			}
+		})
+	}
+}
+
+func TestCorruptSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	s := open(t, dir, Options{})
+	populate(t, s)
+	if err := s.Close(); err != nil {
+		t.Fatalf("unable to close storage: %s", err.Error())
+	}
+	path := filepath.Join(dir, snapshotFile)
+	snapshot, _ := os.ReadFile(path)
+	if err := os.WriteFile(path, snapshot[:len(snapshot)-1], 0o600); err != nil {
+		t.Fatalf("unable to corrupt snapshot: %s", err.Error())
+	}
+	if _, err := NewVerifierStorage(dir, Options{}); err == nil {
+		t.Fatalf("corrupt snapshot was loaded")
+	}
+}
+
+func TestParseSyncPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    SyncPolicy
+		wantErr bool
+	}{
+		{"", SyncAlways, false},
+		{"always", SyncAlways, false},
+		{"interval", SyncInterval, false},
+		{"none", SyncNone, false},
+		{"never", "", true},
+	}
+	for _, tt := range tests {
+		policy, err := ParseSyncPolicy(tt.name)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%q: unexpected error: %v", tt.name, err)
+		}
+		if policy != tt.want {
+			t.Errorf("%q: got policy %q, want %q", tt.name, policy, tt.want)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package file
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"time"
+	"zkp-api/pkg/storage"
+	"zkp-api/pkg/zkp"
+)
+
+// SyncPolicy is when the records appended to the write-ahead log are flushed to the disk.
+type SyncPolicy string
+
+const (
+	// SyncAlways flushes every record before its write returns, no acknowledged write is lost on a crash.
+	SyncAlways SyncPolicy = "always"
+	// SyncInterval flushes the records every sync interval, a crash loses the writes of the last interval.
+	SyncInterval SyncPolicy = "interval"
+	// SyncNone leaves the flushing to the operating system, only snapshots and Close flush the log.
+	SyncNone SyncPolicy = "none"
+)
+
+// ParseSyncPolicy returns the sync policy with the given name, SyncAlways if the name is empty.
+// Returns an error if the policy is unknown.
+func ParseSyncPolicy(name string) (SyncPolicy, error) {
+	switch policy := SyncPolicy(name); policy {
+	case "":
+		return SyncAlways, nil
+	case SyncAlways, SyncInterval, SyncNone:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unknown sync policy '%s'", name)
+	}
+}
+
+const (
+	// frameHeaderSize is the size of the header of a frame: the length of its payload and its CRC-32C checksum.
+	frameHeaderSize = 8
+	// maxFrameSize is the largest payload of a frame, a larger length can only be read from a corrupted header.
+	maxFrameSize = 1 << 20
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// errTornFrame is returned when a frame is incomplete or does not match its checksum, e.g: the last record written
+// before a crash.
+var errTornFrame = errors.New("torn frame")
+
+// encodeFrame returns the frame of a payload: its big-endian length and checksum followed by the payload.
+func encodeFrame(payload []byte) []byte {
+	frame := make([]byte, frameHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(frame[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(frame[4:8], crc32.Checksum(payload, crcTable))
+	copy(frame[frameHeaderSize:], payload)
+	return frame
+}
+
+// readFrame reads the payload of the next frame.
+// Returns io.EOF if there are no more frames and errTornFrame if the next one is not complete and valid.
+func readFrame(r *bufio.Reader) ([]byte, error) {
+	var header [frameHeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		if err == io.ErrUnexpectedEOF {
+			return nil, errTornFrame
+		}
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(header[0:4])
+	if size > maxFrameSize {
+		return nil, errTornFrame
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, errTornFrame
+		}
+		return nil, err
+	}
+	if crc32.Checksum(payload, crcTable) != binary.BigEndian.Uint32(header[4:8]) {
+		return nil, errTornFrame
+	}
+	return payload, nil
+}
+
+// op is the change a record makes to the users.
+type op string
+
+const (
+	opAddUser           op = "add_user"
+	opUpdateCommitments op = "update_commitments"
+	opSetUserState      op = "set_user_state"
+	opDeleteUser        op = "delete_user"
+	opSnapshot          op = "snapshot" // header of a snapshot, followed by an add_user record per user
+)
+
+// snapshotVersion is the version of the format of the snapshots, written in their header.
+const snapshotVersion = 1
+
+// record is a change of the write-ahead log, or an entry of a snapshot, encoded as JSON.
+type record struct {
+	Seq   uint64            `json:"seq"` // position of the change in the log, the one of the last change for snapshots
+	Op    op                `json:"op"`
+	User  string            `json:"user,omitempty"`
+	Data  *userData         `json:"data,omitempty"`  // add_user and update_commitments
+	State storage.UserState `json:"state,omitempty"` // set_user_state
+	// Version and Users are set in the header of the snapshots, with the format and the number of users that follow
+	Version int `json:"version,omitempty"`
+	Users   int `json:"users,omitempty"`
+}
+
+// userData is the persisted data of a user.
+type userData struct {
+	State    storage.UserState `json:"state,omitempty"`
+	Protocol string            `json:"protocol,omitempty"`
+	Salt     []byte            `json:"salt"`
+	KDF      zkp.KDFParams     `json:"kdf"`
+	Y1       []byte            `json:"y1"`
+	Y2       []byte            `json:"y2"`
+}
+
+// syncDir flushes the entries of a directory, so that a file created or renamed in it survives a crash.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+const (
+	// DefaultSyncInterval is how often the SyncInterval policy flushes the log when no interval is configured.
+	DefaultSyncInterval = time.Second
+	// DefaultSnapshotInterval is how often the log is compacted into a snapshot when no interval is configured.
+	DefaultSnapshotInterval = 10 * time.Minute
+	// DefaultSnapshotRecords is how many records trigger a snapshot when no count is configured.
+	DefaultSnapshotRecords = 10000
+)
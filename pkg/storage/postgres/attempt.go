@@ -0,0 +1,83 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+	"zkp-api/pkg/storage"
+)
+
+// AttemptPostgresStorage is a PostgreSQL storage for the failed authentication attempts, kept in the attempts table.
+type AttemptPostgresStorage struct {
+	DB *sql.DB
+}
+
+// NewAttemptStorage returns a new instance of AttemptPostgresStorage using the given database,
+// whose migrations have to be applied.
+func NewAttemptStorage(db *sql.DB) *AttemptPostgresStorage {
+	return &AttemptPostgresStorage{DB: db}
+}
+
+// GetAttempts retrieves the attempts recorded under the given key.
+// It returns no failures if there are none.
+func (a *AttemptPostgresStorage) GetAttempts(ctx context.Context, key string) (*storage.AttemptData, error) {
+	var at storage.AttemptData
+	err := a.DB.QueryRowContext(ctx, `SELECT failures, last_failure FROM attempts WHERE key = $1`, key).Scan(&at.Failures, &at.LastFailure)
+	if errors.Is(err, sql.ErrNoRows) {
+		return &storage.AttemptData{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &at, nil
+}
+
+// AddFailure records a failed attempt under the given key.
+// A single upsert forgets the failures older than the window and increments the count, so concurrent failures of
+// every replica are counted. Returns the updated attempts.
+func (a *AttemptPostgresStorage) AddFailure(ctx context.Context, key string, now time.Time, window time.Duration) (*storage.AttemptData, error) {
+	var at storage.AttemptData
+	err := a.DB.QueryRowContext(ctx, `INSERT INTO attempts AS a (key, failures, last_failure) VALUES ($1, 1, $2)
+		ON CONFLICT (key) DO UPDATE SET
+			failures = CASE WHEN a.last_failure < $2::timestamptz - $3::bigint * interval '1 microsecond' THEN 1
+				ELSE a.failures + 1 END,
+			last_failure = $2
+		RETURNING failures, last_failure`, key, now, window.Microseconds()).Scan(&at.Failures, &at.LastFailure)
+	if err != nil {
+		return nil, err
+	}
+	return &at, nil
+}
+
+// ReleaseFailure takes back the failure recorded under the given key at the time failed.
+// An update decrements the count and sets the last failure back to last if no failure was recorded since, then the
+// attempts are deleted if no failure is left. Releasing a key without attempts is not an error.
+func (a *AttemptPostgresStorage) ReleaseFailure(ctx context.Context, key string, failed, last time.Time) error {
+	_, err := a.DB.ExecContext(ctx, `UPDATE attempts SET failures = failures - 1,
+			last_failure = CASE WHEN last_failure = $2 THEN $3 ELSE last_failure END
+		WHERE key = $1`, key, failed, last)
+	if err != nil {
+		return err
+	}
+	// note a failure recorded meanwhile keeps the attempts
+	_, err = a.DB.ExecContext(ctx, `DELETE FROM attempts WHERE key = $1 AND failures <= 0`, key)
+	return err
+}
+
+// ResetAttempts deletes the attempts recorded under the given key.
+// Resetting a key without attempts is not an error.
+func (a *AttemptPostgresStorage) ResetAttempts(ctx context.Context, key string) error {
+	_, err := a.DB.ExecContext(ctx, `DELETE FROM attempts WHERE key = $1`, key)
+	return err
+}
+
+// DeleteStaleAttempts deletes every attempt whose last failure is before the given time.
+// It returns the number of deleted attempts.
+func (a *AttemptPostgresStorage) DeleteStaleAttempts(ctx context.Context, before time.Time) (int, error) {
+	res, err := a.DB.ExecContext(ctx, `DELETE FROM attempts WHERE last_failure < $1`, before)
+	if err != nil {
+		return 0, err
+	}
+	return affected(res)
+}
@@ -0,0 +1,68 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+	"zkp-api/pkg/storage"
+)
+
+// ChallengePostgresStorage is a PostgreSQL storage for the pending authentication challenges, kept in the challenges table.
+type ChallengePostgresStorage struct {
+	DB *sql.DB
+}
+
+// NewChallengeStorage returns a new instance of ChallengePostgresStorage using the given database,
+// whose migrations have to be applied.
+func NewChallengeStorage(db *sql.DB) *ChallengePostgresStorage {
+	return &ChallengePostgresStorage{DB: db}
+}
+
+// BeginChallenge adds a pending challenge to the storage under the provided auth id.
+// The insert is skipped if the auth id is already in use, in which case an error is returned.
+func (c *ChallengePostgresStorage) BeginChallenge(ctx context.Context, authID string, ch *storage.ChallengeData) error {
+	res, err := c.DB.ExecContext(ctx, `INSERT INTO challenges (auth_id, user_name, r1, r2, c, created_at, ttl, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8) ON CONFLICT (auth_id) DO NOTHING`,
+		authID, ch.User, ch.R1, ch.R2, ch.C, ch.CreatedAt, int64(ch.TTL), ch.CreatedAt.Add(ch.TTL))
+	if err != nil {
+		return err
+	}
+	if n, err := affected(res); err != nil {
+		return err
+	} else if n == 0 {
+		return fmt.Errorf("challenge %w", storage.ErrExists)
+	}
+	return nil
+}
+
+// ConsumeChallenge deletes the challenge stored under the given auth id and returns it.
+// Both happen in a single statement, so two concurrent calls never get the same challenge.
+// Returns an error if there is no such challenge.
+func (c *ChallengePostgresStorage) ConsumeChallenge(ctx context.Context, authID string) (*storage.ChallengeData, error) {
+	var (
+		ch  storage.ChallengeData
+		ttl int64
+	)
+	err := c.DB.QueryRowContext(ctx, `DELETE FROM challenges WHERE auth_id = $1 RETURNING user_name, r1, r2, c, created_at, ttl`, authID).
+		Scan(&ch.User, &ch.R1, &ch.R2, &ch.C, &ch.CreatedAt, &ttl)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("challenge %w", storage.ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+	ch.TTL = time.Duration(ttl)
+	return &ch, nil
+}
+
+// DeleteExpiredChallenges deletes every challenge expired at the given time.
+// It returns the number of deleted challenges.
+func (c *ChallengePostgresStorage) DeleteExpiredChallenges(ctx context.Context, now time.Time) (int, error) {
+	res, err := c.DB.ExecContext(ctx, `DELETE FROM challenges WHERE expires_at <= $1`, now)
+	if err != nil {
+		return 0, err
+	}
+	return affected(res)
+}
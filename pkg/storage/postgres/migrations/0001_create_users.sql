@@ -0,0 +1,13 @@
+-- users are the registered users of the verifier along with their credentials
+CREATE TABLE users (
+    name        TEXT PRIMARY KEY,
+    state       TEXT        NOT NULL,
+    protocol    TEXT        NOT NULL,
+    salt        BYTEA       NOT NULL,
+    kdf_time    BIGINT      NOT NULL,
+    kdf_memory  BIGINT      NOT NULL,
+    kdf_threads SMALLINT    NOT NULL,
+    y1          BYTEA       NOT NULL,
+    y2          BYTEA       NOT NULL,
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT now()
+);
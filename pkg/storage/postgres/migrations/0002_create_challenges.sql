@@ -0,0 +1,13 @@
+-- challenges are the pending authentication challenges, deleted by their first answer
+CREATE TABLE challenges (
+    auth_id    TEXT PRIMARY KEY,
+    user_name  TEXT        NOT NULL,
+    r1         BYTEA       NOT NULL,
+    r2         BYTEA       NOT NULL,
+    c          BYTEA       NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL,
+    ttl        BIGINT      NOT NULL, -- nanoseconds
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX challenges_expires_at_idx ON challenges (expires_at);
@@ -0,0 +1,24 @@
+-- sessions are the active sessions, a session that is not stored is revoked
+CREATE TABLE sessions (
+    id         TEXT PRIMARY KEY,
+    user_name  TEXT        NOT NULL,
+    family     TEXT        NOT NULL,
+    issued_at  TIMESTAMPTZ NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX sessions_user_name_idx ON sessions (user_name);
+CREATE INDEX sessions_expires_at_idx ON sessions (expires_at);
+
+-- refresh_tokens are the refresh tokens indexed by their hash, used ones are kept until they expire
+CREATE TABLE refresh_tokens (
+    hash       TEXT PRIMARY KEY,
+    family     TEXT        NOT NULL,
+    user_name  TEXT        NOT NULL,
+    used       BOOLEAN     NOT NULL DEFAULT false,
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX refresh_tokens_family_idx ON refresh_tokens (family);
+CREATE INDEX refresh_tokens_user_name_idx ON refresh_tokens (user_name);
+CREATE INDEX refresh_tokens_expires_at_idx ON refresh_tokens (expires_at);
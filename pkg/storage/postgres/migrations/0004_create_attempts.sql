@@ -0,0 +1,8 @@
+-- attempts are the failed authentication attempts per user and per client address
+CREATE TABLE attempts (
+    key          TEXT PRIMARY KEY,
+    failures     INTEGER     NOT NULL,
+    last_failure TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX attempts_last_failure_idx ON attempts (last_failure);
@@ -0,0 +1,2 @@
+-- sessions are deleted by family when a refresh token of the family is reused
+CREATE INDEX sessions_family_idx ON sessions (family);
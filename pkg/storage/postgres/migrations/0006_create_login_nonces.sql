@@ -0,0 +1,10 @@
+-- login_nonces are the single use nonces of the non-interactive proofs, deleted by their first use
+CREATE TABLE login_nonces (
+    nonce      TEXT PRIMARY KEY,
+    user_name  TEXT        NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL,
+    ttl        BIGINT      NOT NULL, -- nanoseconds
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX login_nonces_expires_at_idx ON login_nonces (expires_at);
@@ -0,0 +1,68 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+	"zkp-api/pkg/storage"
+)
+
+// NoncePostgresStorage is a PostgreSQL storage for the login nonces, kept in the login_nonces table.
+type NoncePostgresStorage struct {
+	DB *sql.DB
+}
+
+// NewNonceStorage returns a new instance of NoncePostgresStorage using the given database,
+// whose migrations have to be applied.
+func NewNonceStorage(db *sql.DB) *NoncePostgresStorage {
+	return &NoncePostgresStorage{DB: db}
+}
+
+// AddNonce adds a login nonce to the storage.
+// The insert is skipped if the nonce is already in use, in which case an error is returned.
+func (s *NoncePostgresStorage) AddNonce(ctx context.Context, nonce string, n *storage.NonceData) error {
+	res, err := s.DB.ExecContext(ctx, `INSERT INTO login_nonces (nonce, user_name, created_at, ttl, expires_at)
+		VALUES ($1, $2, $3, $4, $5) ON CONFLICT (nonce) DO NOTHING`,
+		nonce, n.User, n.CreatedAt, int64(n.TTL), n.CreatedAt.Add(n.TTL))
+	if err != nil {
+		return err
+	}
+	if rows, err := affected(res); err != nil {
+		return err
+	} else if rows == 0 {
+		return fmt.Errorf("nonce %w", storage.ErrExists)
+	}
+	return nil
+}
+
+// ConsumeNonce deletes the given login nonce and returns it.
+// Both happen in a single statement, so two concurrent calls never get the same nonce.
+// Returns an error if there is no such nonce.
+func (s *NoncePostgresStorage) ConsumeNonce(ctx context.Context, nonce string) (*storage.NonceData, error) {
+	var (
+		n   storage.NonceData
+		ttl int64
+	)
+	err := s.DB.QueryRowContext(ctx, `DELETE FROM login_nonces WHERE nonce = $1 RETURNING user_name, created_at, ttl`, nonce).
+		Scan(&n.User, &n.CreatedAt, &ttl)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("nonce %w", storage.ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+	n.TTL = time.Duration(ttl)
+	return &n, nil
+}
+
+// DeleteExpiredNonces deletes every login nonce expired at the given time.
+// It returns the number of deleted nonces.
+func (s *NoncePostgresStorage) DeleteExpiredNonces(ctx context.Context, now time.Time) (int, error) {
+	res, err := s.DB.ExecContext(ctx, `DELETE FROM login_nonces WHERE expires_at <= $1`, now)
+	if err != nil {
+		return 0, err
+	}
+	return affected(res)
+}
@@ -0,0 +1,85 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+	"zkp-api/pkg/storage"
+)
+
+// RefreshTokenPostgresStorage is a PostgreSQL storage for the refresh tokens, kept in the refresh_tokens table.
+type RefreshTokenPostgresStorage struct {
+	DB *sql.DB
+}
+
+// NewRefreshTokenStorage returns a new instance of RefreshTokenPostgresStorage using the given database,
+// whose migrations have to be applied.
+func NewRefreshTokenStorage(db *sql.DB) *RefreshTokenPostgresStorage {
+	return &RefreshTokenPostgresStorage{DB: db}
+}
+
+// AddRefreshToken adds a refresh token to the storage under its hash.
+// The insert is skipped if the hash is already in use, in which case an error is returned.
+func (r *RefreshTokenPostgresStorage) AddRefreshToken(ctx context.Context, rt *storage.RefreshTokenData) error {
+	res, err := r.DB.ExecContext(ctx, `INSERT INTO refresh_tokens (hash, family, user_name, used, expires_at)
+		VALUES ($1, $2, $3, $4, $5) ON CONFLICT (hash) DO NOTHING`,
+		rt.Hash, rt.Family, rt.User, rt.Used, rt.ExpiresAt)
+	if err != nil {
+		return err
+	}
+	if n, err := affected(res); err != nil {
+		return err
+	} else if n == 0 {
+		return fmt.Errorf("refresh token %w", storage.ErrExists)
+	}
+	return nil
+}
+
+// UseRefreshToken marks the refresh token with the given hash as used and returns it as it was before.
+// The row is locked by the subquery until the update commits, so a concurrent call waits for it and reads the token
+// used: only one of them gets it unused.
+// Returns an error if there is no such token.
+func (r *RefreshTokenPostgresStorage) UseRefreshToken(ctx context.Context, hash string) (*storage.RefreshTokenData, error) {
+	rt := storage.RefreshTokenData{Hash: hash}
+	err := r.DB.QueryRowContext(ctx, `UPDATE refresh_tokens t SET used = true
+		FROM (SELECT hash, used FROM refresh_tokens WHERE hash = $1 FOR UPDATE) prev
+		WHERE t.hash = prev.hash
+		RETURNING t.family, t.user_name, prev.used, t.expires_at`, hash).
+		Scan(&rt.Family, &rt.User, &rt.Used, &rt.ExpiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("refresh token %w", storage.ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &rt, nil
+}
+
+// DeleteRefreshFamily deletes every refresh token of the given family.
+// It returns the number of deleted tokens.
+func (r *RefreshTokenPostgresStorage) DeleteRefreshFamily(ctx context.Context, family string) (int, error) {
+	return r.deleteWhere(ctx, `family = $1`, family)
+}
+
+// DeleteUserRefreshTokens deletes every refresh token of the given user.
+// It returns the number of deleted tokens.
+func (r *RefreshTokenPostgresStorage) DeleteUserRefreshTokens(ctx context.Context, user string) (int, error) {
+	return r.deleteWhere(ctx, `user_name = $1`, user)
+}
+
+// DeleteExpiredRefreshTokens deletes every refresh token expired at the given time.
+// It returns the number of deleted tokens.
+func (r *RefreshTokenPostgresStorage) DeleteExpiredRefreshTokens(ctx context.Context, now time.Time) (int, error) {
+	return r.deleteWhere(ctx, `expires_at <= $1`, now)
+}
+
+// deleteWhere deletes the refresh tokens matching the given condition on its single argument.
+func (r *RefreshTokenPostgresStorage) deleteWhere(ctx context.Context, cond string, arg any) (int, error) {
+	res, err := r.DB.ExecContext(ctx, `DELETE FROM refresh_tokens WHERE `+cond, arg)
+	if err != nil {
+		return 0, err
+	}
+	return affected(res)
+}
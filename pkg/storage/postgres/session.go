@@ -0,0 +1,98 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+	"zkp-api/pkg/storage"
+)
+
+// SessionPostgresStorage is a PostgreSQL storage for the active sessions, kept in the sessions table.
+type SessionPostgresStorage struct {
+	DB *sql.DB
+}
+
+// NewSessionStorage returns a new instance of SessionPostgresStorage using the given database,
+// whose migrations have to be applied.
+func NewSessionStorage(db *sql.DB) *SessionPostgresStorage {
+	return &SessionPostgresStorage{DB: db}
+}
+
+// AddSession adds a session to the storage under its id.
+// The insert is skipped if the id is already in use, in which case an error is returned.
+func (s *SessionPostgresStorage) AddSession(ctx context.Context, sess *storage.SessionData) error {
+	res, err := s.DB.ExecContext(ctx, `INSERT INTO sessions (id, user_name, family, issued_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5) ON CONFLICT (id) DO NOTHING`,
+		sess.ID, sess.User, sess.Family, sess.IssuedAt, sess.ExpiresAt)
+	if err != nil {
+		return err
+	}
+	if n, err := affected(res); err != nil {
+		return err
+	} else if n == 0 {
+		return fmt.Errorf("session %w", storage.ErrExists)
+	}
+	return nil
+}
+
+// GetSession retrieves the session with the given id.
+// Returns an error if there is no such session.
+func (s *SessionPostgresStorage) GetSession(ctx context.Context, id string) (*storage.SessionData, error) {
+	sess := storage.SessionData{ID: id}
+	err := s.DB.QueryRowContext(ctx, `SELECT user_name, family, issued_at, expires_at FROM sessions WHERE id = $1`, id).
+		Scan(&sess.User, &sess.Family, &sess.IssuedAt, &sess.ExpiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("session %w", storage.ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &sess, nil
+}
+
+// DeleteSession deletes the session with the given id.
+// Returns an error if there is no such session.
+func (s *SessionPostgresStorage) DeleteSession(ctx context.Context, id string) error {
+	res, err := s.DB.ExecContext(ctx, `DELETE FROM sessions WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	if n, err := affected(res); err != nil {
+		return err
+	} else if n == 0 {
+		return fmt.Errorf("session %w", storage.ErrNotFound)
+	}
+	return nil
+}
+
+// DeleteUserSessions deletes every session of the given user.
+// It returns the number of deleted sessions.
+func (s *SessionPostgresStorage) DeleteUserSessions(ctx context.Context, user string) (int, error) {
+	res, err := s.DB.ExecContext(ctx, `DELETE FROM sessions WHERE user_name = $1`, user)
+	if err != nil {
+		return 0, err
+	}
+	return affected(res)
+}
+
+// DeleteFamilySessions deletes every session issued with the given refresh token family.
+// It returns the number of deleted sessions.
+func (s *SessionPostgresStorage) DeleteFamilySessions(ctx context.Context, family string) (int, error) {
+	res, err := s.DB.ExecContext(ctx, `DELETE FROM sessions WHERE family = $1`, family)
+	if err != nil {
+		return 0, err
+	}
+	return affected(res)
+}
+
+// DeleteExpiredSessions deletes every session expired at the given time.
+// It returns the number of deleted sessions.
+func (s *SessionPostgresStorage) DeleteExpiredSessions(ctx context.Context, now time.Time) (int, error) {
+	res, err := s.DB.ExecContext(ctx, `DELETE FROM sessions WHERE expires_at <= $1`, now)
+	if err != nil {
+		return 0, err
+	}
+	return affected(res)
+}
@@ -0,0 +1,107 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"zkp-api/pkg/storage"
+	"zkp-api/pkg/zkp"
+)
+
+// VerifierPostgresStorage is a PostgreSQL storage for verifier user data, kept in the users table.
+type VerifierPostgresStorage struct {
+	DB *sql.DB
+}
+
+// NewVerifierStorage returns a new instance of VerifierPostgresStorage using the given database,
+// whose migrations have to be applied.
+func NewVerifierStorage(db *sql.DB) *VerifierPostgresStorage {
+	return &VerifierPostgresStorage{DB: db}
+}
+
+// AddUser adds a new user to the storage with the provided username and registration data, that is the account state,
+// the zkp protocol, the KDF salt and parameters and the public commitments (y1, y2).
+// The insert is skipped if the user already exists, in which case an error is returned.
+func (u *VerifierPostgresStorage) AddUser(ctx context.Context, user string, usr *storage.VerifierUserData) error {
+	res, err := u.DB.ExecContext(ctx, `INSERT INTO users (name, state, protocol, salt, kdf_time, kdf_memory, kdf_threads, y1, y2)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) ON CONFLICT (name) DO NOTHING`,
+		user, string(usr.State), usr.Protocol, usr.Salt, int64(usr.KDF.Time), int64(usr.KDF.Memory), int16(usr.KDF.Threads),
+		usr.Y1, usr.Y2)
+	if err != nil {
+		return err
+	}
+	if n, err := affected(res); err != nil {
+		return err
+	} else if n == 0 {
+		return fmt.Errorf("user %w", storage.ErrExists)
+	}
+	return nil
+}
+
+// UpdateUserCommitments replaces the salt, the KDF parameters and the public commitments (y1, y2) of a given user.
+// Returns an error if the user does not exist.
+func (u *VerifierPostgresStorage) UpdateUserCommitments(ctx context.Context, user string, salt []byte, kdf zkp.KDFParams, y1, y2 []byte) error {
+	return u.update(ctx, user, `UPDATE users SET salt = $2, kdf_time = $3, kdf_memory = $4, kdf_threads = $5, y1 = $6, y2 = $7
+		WHERE name = $1`, salt, int64(kdf.Time), int64(kdf.Memory), int16(kdf.Threads), y1, y2)
+}
+
+// GetUser retrieves the verifier user data for the given user from the storage.
+// Returns an error if the user does not exist.
+func (u *VerifierPostgresStorage) GetUser(ctx context.Context, user string) (*storage.VerifierUserData, error) {
+	var (
+		usr                storage.VerifierUserData
+		state              string
+		kdfTime, kdfMemory int64
+		kdfThreads         int16
+	)
+	err := u.DB.QueryRowContext(ctx, `SELECT state, protocol, salt, kdf_time, kdf_memory, kdf_threads, y1, y2
+		FROM users WHERE name = $1`, user).
+		Scan(&state, &usr.Protocol, &usr.Salt, &kdfTime, &kdfMemory, &kdfThreads, &usr.Y1, &usr.Y2)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("user %w", storage.ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+	usr.State = storage.UserState(state)
+	usr.KDF = zkp.KDFParams{Time: uint32(kdfTime), Memory: uint32(kdfMemory), Threads: uint8(kdfThreads)}
+	return &usr, nil
+}
+
+// CheckUser checks if a user exists in the storage.
+// It returns true if the user exists, false otherwise.
+func (u *VerifierPostgresStorage) CheckUser(ctx context.Context, user string) (bool, error) {
+	var exists bool
+	if err := u.DB.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM users WHERE name = $1)`, user).Scan(&exists); err != nil {
+		return false, err
+	}
+	return exists, nil
+}
+
+// SetUserState updates the lifecycle state of a given user in the storage.
+// Returns an error if the user does not exist.
+func (u *VerifierPostgresStorage) SetUserState(ctx context.Context, user string, state storage.UserState) error {
+	return u.update(ctx, user, `UPDATE users SET state = $2 WHERE name = $1`, string(state))
+}
+
+// DeleteUser deletes a given user from the storage.
+// Returns an error if the user does not exist.
+func (u *VerifierPostgresStorage) DeleteUser(ctx context.Context, user string) error {
+	return u.update(ctx, user, `DELETE FROM users WHERE name = $1`)
+}
+
+// update runs a statement on the row of a user, whose name is its first argument.
+// Returns an error if the user does not exist.
+func (u *VerifierPostgresStorage) update(ctx context.Context, user, query string, args ...any) error {
+	res, err := u.DB.ExecContext(ctx, query, append([]any{user}, args...)...)
+	if err != nil {
+		return err
+	}
+	if n, err := affected(res); err != nil {
+		return err
+	} else if n == 0 {
+		return fmt.Errorf("user %w", storage.ErrNotFound)
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package redis
+
+import (
+	"context"
+	goredis "github.com/redis/go-redis/v9"
+	"strconv"
+	"time"
+	"zkp-api/pkg/storage"
+)
+
+// addFailure counts a failed attempt in the hash of a key, forgetting the failures whose last one is older than the
+// window first, and returns the updated failures.
+var addFailure = goredis.NewScript(`
+local last = tonumber(redis.call('HGET', KEYS[1], 'last_failure'))
+local failures = 1
+if last and last >= tonumber(ARGV[1]) - tonumber(ARGV[2]) then
+	failures = tonumber(redis.call('HGET', KEYS[1], 'failures')) + 1
+end
+redis.call('HSET', KEYS[1], 'failures', failures, 'last_failure', ARGV[1])
+redis.call('PEXPIRE', KEYS[1], ARGV[3])
+return failures
+`)
+
+// releaseFailure takes back a failure from the hash of a key, deleting it once no failure is left and setting the last
+// failure back if it is still the released one.
+var releaseFailure = goredis.NewScript(`
+local failures = tonumber(redis.call('HGET', KEYS[1], 'failures'))
+if not failures then
+	return 0
+end
+if failures <= 1 then
+	return redis.call('DEL', KEYS[1])
+end
+redis.call('HSET', KEYS[1], 'failures', failures - 1)
+if redis.call('HGET', KEYS[1], 'last_failure') == ARGV[1] then
+	redis.call('HSET', KEYS[1], 'last_failure', ARGV[2])
+end
+return 1
+`)
+
+// AttemptRedisStorage is a redis storage for the failed authentication attempts, shared by every verifier replica
+// using the same server so that a lockout holds whichever replica is called. The attempts of a key are a hash,
+// <prefix>attempts:<key>, that redis expires once they no longer count.
+type AttemptRedisStorage struct {
+	Client goredis.UniversalClient
+	Prefix string // prefix of the keys, e.g: zkp:
+	// Retention is how long the attempts are kept after the last failure, e.g: the longest of the lockout window and
+	// delay, the window of each failure if shorter
+	Retention time.Duration
+}
+
+// NewAttemptStorage returns a new instance of AttemptRedisStorage using the given client and key prefix,
+// which keeps the attempts for the given retention after their last failure.
+func NewAttemptStorage(client goredis.UniversalClient, prefix string, retention time.Duration) *AttemptRedisStorage {
+	return &AttemptRedisStorage{Client: client, Prefix: prefix, Retention: retention}
+}
+
+// key returns the key of the attempts recorded under the given key.
+func (a *AttemptRedisStorage) key(key string) string {
+	return a.Prefix + "attempts:" + key
+}
+
+// GetAttempts retrieves the attempts recorded under the given key.
+// It returns no failures if there are none.
+func (a *AttemptRedisStorage) GetAttempts(ctx context.Context, key string) (*storage.AttemptData, error) {
+	values, err := a.Client.HMGet(ctx, a.key(key), "failures", "last_failure").Result()
+	if err != nil {
+		return nil, err
+	}
+	if values[0] == nil || values[1] == nil {
+		return &storage.AttemptData{}, nil
+	}
+	failures, err := strconv.Atoi(values[0].(string))
+	if err != nil {
+		return nil, err
+	}
+	last, err := strconv.ParseInt(values[1].(string), 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	return &storage.AttemptData{Failures: failures, LastFailure: time.UnixMilli(last)}, nil
+}
+
+// AddFailure records a failed attempt under the given key.
+// A script forgets the failures older than the window and increments the count, so concurrent failures of every
+// replica are counted, and keeps the attempts for the retention, or the window if longer. Returns the updated attempts.
+// note the time of the failure is kept in milliseconds.
+func (a *AttemptRedisStorage) AddFailure(ctx context.Context, key string, now time.Time, window time.Duration) (*storage.AttemptData, error) {
+	retention := a.Retention
+	if retention < window {
+		retention = window
+	}
+	if retention < time.Millisecond {
+		retention = time.Millisecond
+	}
+	failures, err := addFailure.Run(ctx, a.Client, []string{a.key(key)},
+		now.UnixMilli(), window.Milliseconds(), retention.Milliseconds()).Int()
+	if err != nil {
+		return nil, err
+	}
+	return &storage.AttemptData{Failures: failures, LastFailure: time.UnixMilli(now.UnixMilli())}, nil
+}
+
+// ReleaseFailure takes back the failure recorded under the given key at the time failed.
+// A script decrements the count, deleting the attempts once no failure is left, and sets the last failure back to last
+// if no failure was recorded since. Releasing a key without attempts is not an error.
+func (a *AttemptRedisStorage) ReleaseFailure(ctx context.Context, key string, failed, last time.Time) error {
+	return releaseFailure.Run(ctx, a.Client, []string{a.key(key)},
+		strconv.FormatInt(failed.UnixMilli(), 10), last.UnixMilli()).Err()
+}
+
+// ResetAttempts deletes the attempts recorded under the given key.
+// Resetting a key without attempts is not an error.
+func (a *AttemptRedisStorage) ResetAttempts(ctx context.Context, key string) error {
+	return a.Client.Del(ctx, a.key(key)).Err()
+}
+
+// DeleteStaleAttempts does nothing since redis expires the attempts on its own, it always returns zero.
+func (a *AttemptRedisStorage) DeleteStaleAttempts(context.Context, time.Time) (int, error) {
+	return 0, nil
+}
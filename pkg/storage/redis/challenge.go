@@ -0,0 +1,97 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	goredis "github.com/redis/go-redis/v9"
+	"time"
+	"zkp-api/pkg/storage"
+)
+
+// challengeGrace is how long a challenge is kept after it expires, so that a late answer is told its challenge
+// expired rather than that there is no such challenge.
+const challengeGrace = time.Minute
+
+// challengeData is a pending challenge as stored under its key, encoded as JSON.
+type challengeData struct {
+	User      string        `json:"user"`
+	R1        []byte        `json:"r1"`
+	R2        []byte        `json:"r2"`
+	C         []byte        `json:"c"`
+	CreatedAt time.Time     `json:"created_at"`
+	TTL       time.Duration `json:"ttl"`
+}
+
+// ChallengeRedisStorage is a redis storage for the pending authentication challenges, shared by every verifier
+// replica using the same server so that a challenge can be answered to any of them.
+// Every challenge is a key, <prefix>challenge:<auth id>, that redis expires on its own.
+type ChallengeRedisStorage struct {
+	Client goredis.UniversalClient
+	Prefix string // prefix of the keys, e.g: zkp:
+}
+
+// NewChallengeStorage returns a new instance of ChallengeRedisStorage using the given client and key prefix.
+func NewChallengeStorage(client goredis.UniversalClient, prefix string) *ChallengeRedisStorage {
+	return &ChallengeRedisStorage{Client: client, Prefix: prefix}
+}
+
+// key returns the key of the challenge stored under an auth id.
+func (c *ChallengeRedisStorage) key(authID string) string {
+	return c.Prefix + "challenge:" + authID
+}
+
+// BeginChallenge adds a pending challenge to the storage under the provided auth id, expiring a grace period after the
+// challenge does. The key is only set if it does not exist, otherwise an error is returned.
+func (c *ChallengeRedisStorage) BeginChallenge(ctx context.Context, authID string, ch *storage.ChallengeData) error {
+	value, err := json.Marshal(&challengeData{
+		User:      ch.User,
+		R1:        ch.R1,
+		R2:        ch.R2,
+		C:         ch.C,
+		CreatedAt: ch.CreatedAt,
+		TTL:       ch.TTL,
+	})
+	if err != nil {
+		return err
+	}
+	ok, err := c.Client.SetNX(ctx, c.key(authID), value, ttl(ch.CreatedAt.Add(ch.TTL+challengeGrace))).Result()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("challenge %w", storage.ErrExists)
+	}
+	return nil
+}
+
+// ConsumeChallenge retrieves and deletes the challenge stored under the given auth id with a single GETDEL,
+// so two concurrent calls, even to different replicas, never get the same challenge.
+// Returns an error if there is no such challenge.
+func (c *ChallengeRedisStorage) ConsumeChallenge(ctx context.Context, authID string) (*storage.ChallengeData, error) {
+	value, err := c.Client.GetDel(ctx, c.key(authID)).Bytes()
+	if errors.Is(err, goredis.Nil) {
+		return nil, fmt.Errorf("challenge %w", storage.ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var ch challengeData
+	if err = json.Unmarshal(value, &ch); err != nil {
+		return nil, err
+	}
+	return &storage.ChallengeData{
+		User:      ch.User,
+		R1:        ch.R1,
+		R2:        ch.R2,
+		C:         ch.C,
+		CreatedAt: ch.CreatedAt,
+		TTL:       ch.TTL,
+	}, nil
+}
+
+// DeleteExpiredChallenges does nothing since redis expires the challenges on its own, it always returns zero.
+func (c *ChallengeRedisStorage) DeleteExpiredChallenges(context.Context, time.Time) (int, error) {
+	return 0, nil
+}
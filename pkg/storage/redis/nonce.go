@@ -0,0 +1,76 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	goredis "github.com/redis/go-redis/v9"
+	"time"
+	"zkp-api/pkg/storage"
+)
+
+// nonceData is a login nonce as stored under its key, encoded as JSON.
+type nonceData struct {
+	User      string        `json:"user"`
+	CreatedAt time.Time     `json:"created_at"`
+	TTL       time.Duration `json:"ttl"`
+}
+
+// NonceRedisStorage is a redis storage for the login nonces, shared by every verifier replica using the same server
+// so that a nonce can be used with any of them. Every nonce is a key, <prefix>nonce:<nonce>, that redis expires along
+// with the nonce.
+type NonceRedisStorage struct {
+	Client goredis.UniversalClient
+	Prefix string // prefix of the keys, e.g: zkp:
+}
+
+// NewNonceStorage returns a new instance of NonceRedisStorage using the given client and key prefix.
+func NewNonceStorage(client goredis.UniversalClient, prefix string) *NonceRedisStorage {
+	return &NonceRedisStorage{Client: client, Prefix: prefix}
+}
+
+// key returns the key of the given login nonce.
+func (s *NonceRedisStorage) key(nonce string) string {
+	return s.Prefix + "nonce:" + nonce
+}
+
+// AddNonce adds a login nonce to the storage, expiring along with it.
+// The key is only set if it does not exist, otherwise an error is returned.
+func (s *NonceRedisStorage) AddNonce(ctx context.Context, nonce string, n *storage.NonceData) error {
+	value, err := json.Marshal(&nonceData{User: n.User, CreatedAt: n.CreatedAt, TTL: n.TTL})
+	if err != nil {
+		return err
+	}
+	ok, err := s.Client.SetNX(ctx, s.key(nonce), value, ttl(n.CreatedAt.Add(n.TTL))).Result()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("nonce %w", storage.ErrExists)
+	}
+	return nil
+}
+
+// ConsumeNonce retrieves and deletes the given login nonce with a single GETDEL,
+// so two concurrent calls, even to different replicas, never get the same nonce.
+// Returns an error if there is no such nonce.
+func (s *NonceRedisStorage) ConsumeNonce(ctx context.Context, nonce string) (*storage.NonceData, error) {
+	value, err := s.Client.GetDel(ctx, s.key(nonce)).Bytes()
+	if errors.Is(err, goredis.Nil) {
+		return nil, fmt.Errorf("nonce %w", storage.ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var n nonceData
+	if err = json.Unmarshal(value, &n); err != nil {
+		return nil, err
+	}
+	return &storage.NonceData{User: n.User, CreatedAt: n.CreatedAt, TTL: n.TTL}, nil
+}
+
+// DeleteExpiredNonces does nothing since redis expires the nonces on its own, it always returns zero.
+func (s *NonceRedisStorage) DeleteExpiredNonces(context.Context, time.Time) (int, error) {
+	return 0, nil
+}
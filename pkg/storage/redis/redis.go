@@ -0,0 +1,55 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	goredis "github.com/redis/go-redis/v9"
+	"time"
+)
+
+// Open connects to the redis server at the given address, e.g: localhost:6379, and checks that it answers.
+// note the storages need redis 6.2 or later (GETDEL) and keep related keys together, so they are not meant for
+// redis cluster.
+func Open(ctx context.Context, addr, password string, db int) (*goredis.Client, error) {
+	client := goredis.NewClient(&goredis.Options{Addr: addr, Password: password, DB: db})
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("unable to connect to redis: %w", err)
+	}
+	return client, nil
+}
+
+// indexLua defines the index function of the scripts, which adds a member to the sorted set indexing the keys of a
+// user or a family, scored by the expiry of its key in unix milliseconds.
+// The members expired at now are removed first and the set is kept until its last member expires.
+const indexLua = `
+local function index(key, member, expiry, now)
+	redis.call('ZREMRANGEBYSCORE', key, '-inf', now)
+	redis.call('ZADD', key, expiry, member)
+	local ttl = redis.call('PTTL', key)
+	if ttl < 0 or ttl < expiry - now then
+		redis.call('PEXPIRE', key, math.max(expiry - now, 1))
+	end
+end
+`
+
+// deleteIndexed deletes the keys indexed by a sorted set, named by the prefix of the script followed by the member,
+// along with the set itself. Returns how many keys were deleted.
+var deleteIndexed = goredis.NewScript(`
+local members = redis.call('ZRANGE', KEYS[1], 0, -1)
+local n = 0
+for _, member in ipairs(members) do
+	n = n + redis.call('DEL', ARGV[1] .. member)
+end
+redis.call('DEL', KEYS[1])
+return n
+`)
+
+// ttl returns how long a key expiring at the given time has to be kept, at least a millisecond since redis rejects
+// keys without time to live.
+func ttl(expiry time.Time) time.Duration {
+	if d := time.Until(expiry); d > time.Millisecond {
+		return d
+	}
+	return time.Millisecond
+}
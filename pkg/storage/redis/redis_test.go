@@ -0,0 +1,401 @@
+package redis
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/alicebob/miniredis/v2"
+	goredis "github.com/redis/go-redis/v9"
+	"os"
+	"sync"
+	"testing"
+	"time"
+	"zkp-api/pkg/storage"
+)
+
+// addrEnv is the environment variable with the address of a redis server to run the tests against, e.g: localhost:6379,
+// they run against an in-process miniredis otherwise.
+const addrEnv = "ZKP_REDIS_ADDR"
+
+// testClient returns a client, the prefix of the keys of the test and a function advancing the clock of the server,
+// nil for a real server. The keys of the test are deleted at its end.
+func testClient(t *testing.T) (*goredis.Client, string, func(time.Duration)) {
+	t.Helper()
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	prefix := "zkp-test-" + hex.EncodeToString(b) + ":"
+
+	var advance func(time.Duration)
+	addr := os.Getenv(addrEnv)
+	if addr == "" {
+		m := miniredis.RunT(t)
+		addr, advance = m.Addr(), m.FastForward
+	}
+	client, err := Open(context.Background(), addr, "", 0)
+	if err != nil {
+		t.Fatalf("unable to open redis: %s", err.Error())
+	}
+	t.Cleanup(func() {
+		ctx := context.Background()
+		iter := client.Scan(ctx, 0, prefix+"*", 100).Iterator()
+		for iter.Next(ctx) {
+			client.Del(ctx, iter.Val())
+		}
+		client.Close()
+	})
+	return client, prefix, advance
+}
+
+func TestChallengeStorage(t *testing.T) {
+	ctx := context.Background()
+	client, prefix, advance := testClient(t)
+	s := NewChallengeStorage(client, prefix)
+	now := time.Now()
+	ch := &storage.ChallengeData{User: "jon", R1: []byte("r1"), R2: []byte("r2"), C: []byte("c"), CreatedAt: now, TTL: 30 * time.Second}
+	if err := s.BeginChallenge(ctx, "a1", ch); err != nil {
+		t.Fatalf("unable to add challenge: %s", err.Error())
+	}
+	if err := s.BeginChallenge(ctx, "a1", ch); !errors.Is(err, storage.ErrExists) {
+		t.Fatalf("got error %v, want %v", err, storage.ErrExists)
+	}
+
+	got, err := s.ConsumeChallenge(ctx, "a1")
+	if err != nil {
+		t.Fatalf("unable to take challenge: %s", err.Error())
+	}
+	if got.User != "jon" || !bytes.Equal(got.R1, ch.R1) || !bytes.Equal(got.R2, ch.R2) || !bytes.Equal(got.C, ch.C) ||
+		got.TTL != ch.TTL || !got.CreatedAt.Equal(now) {
+		t.Fatalf("got challenge %+v, want %+v", got, ch)
+	}
+
+	if advance == nil {
+		return
+	}
+	// challenges are kept a grace period after they expire, so that late answers are told so
+	for _, authID := range []string{"a2", "a3"} {
+		if err := s.BeginChallenge(ctx, authID, ch); err != nil {
+			t.Fatalf("unable to add challenge: %s", err.Error())
+		}
+	}
+	advance(ch.TTL + time.Second)
+	if got, err := s.ConsumeChallenge(ctx, "a2"); err != nil || !got.Expired(now.Add(ch.TTL)) {
+		t.Fatalf("expired challenge not kept: %v", err)
+	}
+	advance(challengeGrace)
+	if _, err := s.ConsumeChallenge(ctx, "a3"); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("got error %v, want %v", err, storage.ErrNotFound)
+	}
+}
+
+func TestNonceStorage(t *testing.T) {
+	ctx := context.Background()
+	client, prefix, advance := testClient(t)
+	s := NewNonceStorage(client, prefix)
+	now := time.Now()
+	n := &storage.NonceData{User: "jon", CreatedAt: now, TTL: time.Minute}
+	if err := s.AddNonce(ctx, "n1", n); err != nil {
+		t.Fatalf("unable to add nonce: %s", err.Error())
+	}
+	if err := s.AddNonce(ctx, "n1", n); !errors.Is(err, storage.ErrExists) {
+		t.Fatalf("got error %v, want %v", err, storage.ErrExists)
+	}
+	got, err := s.ConsumeNonce(ctx, "n1")
+	if err != nil {
+		t.Fatalf("unable to consume nonce: %s", err.Error())
+	}
+	if got.User != "jon" || got.TTL != n.TTL || !got.CreatedAt.Equal(now) {
+		t.Fatalf("got nonce %+v, want %+v", got, n)
+	}
+	if _, err = s.ConsumeNonce(ctx, "n1"); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("got error %v, want %v", err, storage.ErrNotFound)
+	}
+
+	if advance == nil {
+		return
+	}
+	// nonces expire along with their TTL
+	if err = s.AddNonce(ctx, "n2", n); err != nil {
+		t.Fatalf("unable to add nonce: %s", err.Error())
+	}
+	advance(n.TTL)
+	if _, err = s.ConsumeNonce(ctx, "n2"); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("got error %v, want %v", err, storage.ErrNotFound)
+	}
+}
+
+// consumeRace runs take from the given number of goroutines released at once and returns how many succeeded,
+// failing the test on any error but storage.ErrNotFound.
+func consumeRace(t *testing.T, takers int, take func() error) int {
+	t.Helper()
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	taken := make(chan struct{}, takers)
+	for i := 0; i < takers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			if err := take(); err == nil {
+				taken <- struct{}{}
+			} else if !errors.Is(err, storage.ErrNotFound) {
+				t.Errorf("got error %v, want %v", err, storage.ErrNotFound)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+	return len(taken)
+}
+
+func TestConcurrentConsumeChallenge(t *testing.T) {
+	ctx := context.Background()
+	client, prefix, _ := testClient(t)
+	s := NewChallengeStorage(client, prefix)
+	ch := &storage.ChallengeData{User: "jon", R1: []byte("r1"), R2: []byte("r2"), C: []byte("c"), CreatedAt: time.Now(), TTL: 30 * time.Second}
+	// only one of concurrent takes gets each challenge
+	for i := 0; i < 16; i++ {
+		authID := fmt.Sprintf("a%d", i)
+		if err := s.BeginChallenge(ctx, authID, ch); err != nil {
+			t.Fatalf("unable to add challenge: %s", err.Error())
+		}
+		n := consumeRace(t, 16, func() error {
+			_, err := s.ConsumeChallenge(ctx, authID)
+			return err
+		})
+		if n != 1 {
+			t.Fatalf("challenge %s taken %d times", authID, n)
+		}
+	}
+}
+
+func TestConcurrentConsumeNonce(t *testing.T) {
+	ctx := context.Background()
+	client, prefix, _ := testClient(t)
+	s := NewNonceStorage(client, prefix)
+	n := &storage.NonceData{User: "jon", CreatedAt: time.Now(), TTL: time.Minute}
+	// only one of concurrent logins consumes each nonce
+	for i := 0; i < 16; i++ {
+		nonce := fmt.Sprintf("n%d", i)
+		if err := s.AddNonce(ctx, nonce, n); err != nil {
+			t.Fatalf("unable to add nonce: %s", err.Error())
+		}
+		taken := consumeRace(t, 16, func() error {
+			_, err := s.ConsumeNonce(ctx, nonce)
+			return err
+		})
+		if taken != 1 {
+			t.Fatalf("nonce %s consumed %d times", nonce, taken)
+		}
+	}
+}
+
+func TestSessionStorage(t *testing.T) {
+	ctx := context.Background()
+	client, prefix, advance := testClient(t)
+	s := NewSessionStorage(client, prefix)
+	now := time.Now()
+	sessions := []*storage.SessionData{
+		{ID: "s1", User: "jon", Family: "f1", IssuedAt: now, ExpiresAt: now.Add(time.Hour)},
+		{ID: "s2", User: "jon", Family: "f2", IssuedAt: now, ExpiresAt: now.Add(2 * time.Hour)},
+		{ID: "s3", User: "ana", Family: "f3", IssuedAt: now, ExpiresAt: now.Add(time.Hour)},
+	}
+	for _, sess := range sessions {
+		if err := s.AddSession(ctx, sess); err != nil {
+			t.Fatalf("unable to add session: %s", err.Error())
+		}
+	}
+	if err := s.AddSession(ctx, sessions[0]); !errors.Is(err, storage.ErrExists) {
+		t.Fatalf("got error %v, want %v", err, storage.ErrExists)
+	}
+	got, err := s.GetSession(ctx, "s1")
+	if err != nil {
+		t.Fatalf("unable to get session: %s", err.Error())
+	}
+	if got.ID != "s1" || got.User != "jon" || got.Family != "f1" || !got.IssuedAt.Equal(now) || !got.ExpiresAt.Equal(now.Add(time.Hour)) {
+		t.Fatalf("got session %+v", got)
+	}
+
+	if err = s.DeleteSession(ctx, "s1"); err != nil {
+		t.Fatalf("unable to delete session: %s", err.Error())
+	}
+	if err = s.DeleteSession(ctx, "s1"); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("got error %v, want %v", err, storage.ErrNotFound)
+	}
+	if n, err := s.DeleteFamilySessions(ctx, "f1"); err != nil || n != 0 {
+		t.Fatalf("deleted %d sessions of f1, want 0: %v", n, err)
+	}
+	if n, err := s.DeleteFamilySessions(ctx, "f2"); err != nil || n != 1 {
+		t.Fatalf("deleted %d sessions of f2, want 1: %v", n, err)
+	}
+	if _, err = s.GetSession(ctx, "s2"); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("got error %v, want %v", err, storage.ErrNotFound)
+	}
+	if n, err := s.DeleteUserSessions(ctx, "jon"); err != nil || n != 0 {
+		t.Fatalf("deleted %d sessions of jon, want 0: %v", n, err)
+	}
+
+	if advance == nil {
+		return
+	}
+	// sessions and their index expire along with the sessions
+	advance(time.Hour)
+	if _, err = s.GetSession(ctx, "s3"); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("got error %v, want %v", err, storage.ErrNotFound)
+	}
+	if n, _ := client.Exists(context.Background(), s.userKey("ana"), s.familyKey("f3")).Result(); n != 0 {
+		t.Fatalf("index of expired sessions kept")
+	}
+}
+
+func TestRefreshTokenStorage(t *testing.T) {
+	ctx := context.Background()
+	client, prefix, advance := testClient(t)
+	s := NewRefreshTokenStorage(client, prefix)
+	now := time.Now().Truncate(time.Millisecond) // note the expiry is kept in milliseconds
+	tokens := []*storage.RefreshTokenData{
+		{Hash: "h1", Family: "f1", User: "jon", ExpiresAt: now.Add(time.Hour)},
+		{Hash: "h2", Family: "f1", User: "jon", Used: true, ExpiresAt: now.Add(time.Hour)},
+		{Hash: "h3", Family: "f2", User: "jon", ExpiresAt: now.Add(2 * time.Hour)},
+		{Hash: "h4", Family: "f3", User: "ana", ExpiresAt: now.Add(time.Hour)},
+	}
+	for _, rt := range tokens {
+		if err := s.AddRefreshToken(ctx, rt); err != nil {
+			t.Fatalf("unable to add refresh token: %s", err.Error())
+		}
+	}
+	if err := s.AddRefreshToken(ctx, tokens[0]); !errors.Is(err, storage.ErrExists) {
+		t.Fatalf("got error %v, want %v", err, storage.ErrExists)
+	}
+
+	// only one of concurrent uses gets the token unused
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	unused := 0
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rt, err := s.UseRefreshToken(ctx, "h1")
+			if err != nil {
+				t.Errorf("unable to use refresh token: %s", err.Error())
+				return
+			}
+			if rt.Hash != "h1" || rt.Family != "f1" || rt.User != "jon" || !rt.ExpiresAt.Equal(now.Add(time.Hour)) {
+				t.Errorf("got refresh token %+v", rt)
+			}
+			if !rt.Used {
+				mu.Lock()
+				unused++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if unused != 1 {
+		t.Fatalf("refresh token used unused %d times", unused)
+	}
+	if rt, err := s.UseRefreshToken(ctx, "h2"); err != nil || !rt.Used {
+		t.Fatalf("got refresh token %+v: %v", rt, err)
+	}
+	if _, err := s.UseRefreshToken(ctx, "h0"); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("got error %v, want %v", err, storage.ErrNotFound)
+	}
+
+	if n, err := s.DeleteRefreshFamily(ctx, "f1"); err != nil || n != 2 {
+		t.Fatalf("deleted %d refresh tokens of f1, want 2: %v", n, err)
+	}
+	if n, err := s.DeleteUserRefreshTokens(ctx, "jon"); err != nil || n != 1 {
+		t.Fatalf("deleted %d refresh tokens of jon, want 1: %v", n, err)
+	}
+	if _, err := s.UseRefreshToken(ctx, "h3"); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("got error %v, want %v", err, storage.ErrNotFound)
+	}
+
+	if advance == nil {
+		return
+	}
+	advance(time.Hour)
+	if _, err := s.UseRefreshToken(ctx, "h4"); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("got error %v, want %v", err, storage.ErrNotFound)
+	}
+	if n, err := s.DeleteUserRefreshTokens(ctx, "ana"); err != nil || n != 0 {
+		t.Fatalf("deleted %d expired refresh tokens of ana, want 0: %v", n, err)
+	}
+}
+
+func TestAttemptStorage(t *testing.T) {
+	ctx := context.Background()
+	client, prefix, advance := testClient(t)
+	s := NewAttemptStorage(client, prefix, 2*time.Hour)
+	now := time.Now().Truncate(time.Millisecond) // note the time of the failures is kept in milliseconds
+
+	if at, err := s.GetAttempts(ctx, "user:jon"); err != nil || at.Failures != 0 {
+		t.Fatalf("got attempts %+v: %v", at, err)
+	}
+	// concurrent failures are all counted
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := s.AddFailure(ctx, "user:jon", now, time.Hour); err != nil {
+				t.Errorf("unable to add failure: %s", err.Error())
+			}
+		}()
+	}
+	wg.Wait()
+	at, err := s.AddFailure(ctx, "user:jon", now.Add(time.Hour), time.Hour)
+	if err != nil || at.Failures != 9 || !at.LastFailure.Equal(now.Add(time.Hour)) {
+		t.Fatalf("got attempts %+v: %v", at, err)
+	}
+	if got, err := s.GetAttempts(ctx, "user:jon"); err != nil || *got != *at {
+		t.Fatalf("got attempts %+v, want %+v: %v", got, at, err)
+	}
+	// failures older than the window are forgotten
+	if at, err = s.AddFailure(ctx, "user:jon", now.Add(2*time.Hour+time.Millisecond), time.Hour); err != nil || at.Failures != 1 {
+		t.Fatalf("got attempts %+v: %v", at, err)
+	}
+	if err = s.ResetAttempts(ctx, "user:jon"); err != nil {
+		t.Fatalf("unable to reset attempts: %s", err.Error())
+	}
+	if at, _ = s.GetAttempts(ctx, "user:jon"); at.Failures != 0 {
+		t.Fatalf("got attempts %+v after reset", at)
+	}
+	// a released failure is taken back along with its time, the attempts are forgotten once no failure is left
+	first, err := s.AddFailure(ctx, "client:10.0.0.2", now, time.Hour)
+	if err != nil {
+		t.Fatalf("unable to add failure: %s", err.Error())
+	}
+	second, _ := s.AddFailure(ctx, "client:10.0.0.2", now.Add(time.Minute), time.Hour)
+	if err = s.ReleaseFailure(ctx, "client:10.0.0.2", second.LastFailure, first.LastFailure); err != nil {
+		t.Fatalf("unable to release failure: %s", err.Error())
+	}
+	if got, _ := s.GetAttempts(ctx, "client:10.0.0.2"); got.Failures != 1 || !got.LastFailure.Equal(first.LastFailure) {
+		t.Fatalf("got attempts %+v, want %+v", got, first)
+	}
+	if err = s.ReleaseFailure(ctx, "client:10.0.0.2", first.LastFailure, time.Time{}); err != nil {
+		t.Fatalf("unable to release failure: %s", err.Error())
+	}
+	if at, _ = s.GetAttempts(ctx, "client:10.0.0.2"); at.Failures != 0 {
+		t.Fatalf("got attempts %+v after release", at)
+	}
+
+	if advance == nil {
+		return
+	}
+	// attempts are kept for the retention after their last failure
+	if _, err = s.AddFailure(ctx, "client:10.0.0.1", now, time.Hour); err != nil {
+		t.Fatalf("unable to add failure: %s", err.Error())
+	}
+	advance(time.Hour + time.Second)
+	if at, _ = s.GetAttempts(ctx, "client:10.0.0.1"); at.Failures != 1 {
+		t.Fatalf("attempts forgotten before their retention")
+	}
+	advance(time.Hour)
+	if at, _ = s.GetAttempts(ctx, "client:10.0.0.1"); at.Failures != 0 {
+		t.Fatalf("got attempts %+v after their retention", at)
+	}
+}
@@ -0,0 +1,126 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	goredis "github.com/redis/go-redis/v9"
+	"strconv"
+	"time"
+	"zkp-api/pkg/storage"
+)
+
+// addRefreshToken sets the hash of a refresh token if it does not exist and indexes it by its family and its user,
+// returns 0 if it existed.
+var addRefreshToken = goredis.NewScript(indexLua + `
+if redis.call('EXISTS', KEYS[1]) == 1 then
+	return 0
+end
+redis.call('HSET', KEYS[1], 'family', ARGV[1], 'user', ARGV[2], 'used', ARGV[3], 'expires_at', ARGV[4])
+redis.call('PEXPIRE', KEYS[1], ARGV[5])
+index(KEYS[2], ARGV[6], tonumber(ARGV[4]), tonumber(ARGV[7]))
+index(KEYS[3], ARGV[6], tonumber(ARGV[4]), tonumber(ARGV[7]))
+return 1
+`)
+
+// useRefreshToken marks a refresh token as used and returns its fields as they were before, nil if it does not exist.
+var useRefreshToken = goredis.NewScript(`
+local rt = redis.call('HMGET', KEYS[1], 'family', 'user', 'used', 'expires_at')
+if not rt[1] then
+	return false
+end
+redis.call('HSET', KEYS[1], 'used', '1')
+return rt
+`)
+
+// RefreshTokenRedisStorage is a redis storage for the refresh tokens, shared by every verifier replica using the same
+// server. Every token is a hash, <prefix>refresh:<hash>, that redis expires along with the token, indexed by the
+// sorted sets <prefix>refresh-family:<family> and <prefix>user-refresh:<user>.
+type RefreshTokenRedisStorage struct {
+	Client goredis.UniversalClient
+	Prefix string // prefix of the keys, e.g: zkp:
+}
+
+// NewRefreshTokenStorage returns a new instance of RefreshTokenRedisStorage using the given client and key prefix.
+func NewRefreshTokenStorage(client goredis.UniversalClient, prefix string) *RefreshTokenRedisStorage {
+	return &RefreshTokenRedisStorage{Client: client, Prefix: prefix}
+}
+
+// key returns the key of the refresh token with the given hash.
+func (r *RefreshTokenRedisStorage) key(hash string) string {
+	return r.Prefix + "refresh:" + hash
+}
+
+// familyKey returns the key of the index of the refresh tokens of a family.
+func (r *RefreshTokenRedisStorage) familyKey(family string) string {
+	return r.Prefix + "refresh-family:" + family
+}
+
+// userKey returns the key of the index of the refresh tokens of a user.
+func (r *RefreshTokenRedisStorage) userKey(user string) string {
+	return r.Prefix + "user-refresh:" + user
+}
+
+// AddRefreshToken adds a refresh token to the storage under its hash, expiring along with it, and indexes it by its
+// family and its user. Everything happens in a script, the token is only added if the hash is not in use,
+// otherwise an error is returned.
+func (r *RefreshTokenRedisStorage) AddRefreshToken(ctx context.Context, rt *storage.RefreshTokenData) error {
+	used := 0
+	if rt.Used {
+		used = 1
+	}
+	added, err := addRefreshToken.Run(ctx, r.Client,
+		[]string{r.key(rt.Hash), r.familyKey(rt.Family), r.userKey(rt.User)},
+		rt.Family, rt.User, used, rt.ExpiresAt.UnixMilli(), ttl(rt.ExpiresAt).Milliseconds(), rt.Hash, time.Now().UnixMilli()).Int()
+	if err != nil {
+		return err
+	}
+	if added == 0 {
+		return fmt.Errorf("refresh token %w", storage.ErrExists)
+	}
+	return nil
+}
+
+// UseRefreshToken marks the refresh token with the given hash as used and returns it as it was before.
+// Both happen in a script, so only one of concurrent calls, even to different replicas, gets the token unused.
+// Returns an error if there is no such token.
+func (r *RefreshTokenRedisStorage) UseRefreshToken(ctx context.Context, hash string) (*storage.RefreshTokenData, error) {
+	fields, err := useRefreshToken.Run(ctx, r.Client, []string{r.key(hash)}).StringSlice()
+	if errors.Is(err, goredis.Nil) {
+		return nil, fmt.Errorf("refresh token %w", storage.ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) != 4 {
+		return nil, fmt.Errorf("unexpected refresh token fields %v", fields)
+	}
+	expiresAt, err := strconv.ParseInt(fields[3], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid refresh token expiry: %w", err)
+	}
+	return &storage.RefreshTokenData{
+		Hash:      hash,
+		Family:    fields[0],
+		User:      fields[1],
+		Used:      fields[2] == "1",
+		ExpiresAt: time.UnixMilli(expiresAt),
+	}, nil
+}
+
+// DeleteRefreshFamily deletes every refresh token of the given family along with its index in a script.
+// It returns the number of deleted tokens.
+func (r *RefreshTokenRedisStorage) DeleteRefreshFamily(ctx context.Context, family string) (int, error) {
+	return deleteIndexed.Run(ctx, r.Client, []string{r.familyKey(family)}, r.key("")).Int()
+}
+
+// DeleteUserRefreshTokens deletes every refresh token of the given user along with its index in a script.
+// It returns the number of deleted tokens.
+func (r *RefreshTokenRedisStorage) DeleteUserRefreshTokens(ctx context.Context, user string) (int, error) {
+	return deleteIndexed.Run(ctx, r.Client, []string{r.userKey(user)}, r.key("")).Int()
+}
+
+// DeleteExpiredRefreshTokens does nothing since redis expires the refresh tokens on its own, it always returns zero.
+func (r *RefreshTokenRedisStorage) DeleteExpiredRefreshTokens(context.Context, time.Time) (int, error) {
+	return 0, nil
+}
@@ -0,0 +1,136 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	goredis "github.com/redis/go-redis/v9"
+	"time"
+	"zkp-api/pkg/storage"
+)
+
+// sessionData is a session as stored under its key, encoded as JSON.
+type sessionData struct {
+	User      string    `json:"user"`
+	Family    string    `json:"family"`
+	IssuedAt  time.Time `json:"issued_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// addSession sets the key of a session if it does not exist and indexes it by its user and its refresh token family,
+// returns 0 if it existed.
+var addSession = goredis.NewScript(indexLua + `
+if not redis.call('SET', KEYS[1], ARGV[1], 'NX', 'PX', ARGV[2]) then
+	return 0
+end
+index(KEYS[2], ARGV[3], tonumber(ARGV[4]), tonumber(ARGV[5]))
+index(KEYS[3], ARGV[3], tonumber(ARGV[4]), tonumber(ARGV[5]))
+return 1
+`)
+
+// SessionRedisStorage is a redis storage for the active sessions, shared by every verifier replica using the same
+// server. Every session is a key, <prefix>session:<id>, that redis expires along with the session, and the sessions
+// of a user and of a refresh token family are indexed by the sorted sets <prefix>user-sessions:<user>
+// and <prefix>session-family:<family>.
+type SessionRedisStorage struct {
+	Client goredis.UniversalClient
+	Prefix string // prefix of the keys, e.g: zkp:
+}
+
+// NewSessionStorage returns a new instance of SessionRedisStorage using the given client and key prefix.
+func NewSessionStorage(client goredis.UniversalClient, prefix string) *SessionRedisStorage {
+	return &SessionRedisStorage{Client: client, Prefix: prefix}
+}
+
+// key returns the key of the session with the given id.
+func (s *SessionRedisStorage) key(id string) string {
+	return s.Prefix + "session:" + id
+}
+
+// userKey returns the key of the index of the sessions of a user.
+func (s *SessionRedisStorage) userKey(user string) string {
+	return s.Prefix + "user-sessions:" + user
+}
+
+// familyKey returns the key of the index of the sessions of a refresh token family.
+func (s *SessionRedisStorage) familyKey(family string) string {
+	return s.Prefix + "session-family:" + family
+}
+
+// AddSession adds a session to the storage under its id, expiring along with it, and indexes it by its user and family.
+// Both happen in a script, the session is only added if the id is not in use, otherwise an error is returned.
+func (s *SessionRedisStorage) AddSession(ctx context.Context, sess *storage.SessionData) error {
+	value, err := json.Marshal(&sessionData{
+		User:      sess.User,
+		Family:    sess.Family,
+		IssuedAt:  sess.IssuedAt,
+		ExpiresAt: sess.ExpiresAt,
+	})
+	if err != nil {
+		return err
+	}
+	added, err := addSession.Run(ctx, s.Client, []string{s.key(sess.ID), s.userKey(sess.User), s.familyKey(sess.Family)},
+		value, ttl(sess.ExpiresAt).Milliseconds(), sess.ID, sess.ExpiresAt.UnixMilli(), time.Now().UnixMilli()).Int()
+	if err != nil {
+		return err
+	}
+	if added == 0 {
+		return fmt.Errorf("session %w", storage.ErrExists)
+	}
+	return nil
+}
+
+// GetSession retrieves the session with the given id.
+// Returns an error if there is no such session.
+func (s *SessionRedisStorage) GetSession(ctx context.Context, id string) (*storage.SessionData, error) {
+	value, err := s.Client.Get(ctx, s.key(id)).Bytes()
+	if errors.Is(err, goredis.Nil) {
+		return nil, fmt.Errorf("session %w", storage.ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var sess sessionData
+	if err = json.Unmarshal(value, &sess); err != nil {
+		return nil, err
+	}
+	return &storage.SessionData{
+		ID:        id,
+		User:      sess.User,
+		Family:    sess.Family,
+		IssuedAt:  sess.IssuedAt,
+		ExpiresAt: sess.ExpiresAt,
+	}, nil
+}
+
+// DeleteSession deletes the session with the given id.
+// Returns an error if there is no such session.
+// note the session is left in the indexes of its user and family, where it is removed once expired.
+func (s *SessionRedisStorage) DeleteSession(ctx context.Context, id string) error {
+	n, err := s.Client.Del(ctx, s.key(id)).Result()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("session %w", storage.ErrNotFound)
+	}
+	return nil
+}
+
+// DeleteUserSessions deletes every session of the given user along with their index in a script.
+// It returns the number of deleted sessions.
+func (s *SessionRedisStorage) DeleteUserSessions(ctx context.Context, user string) (int, error) {
+	return deleteIndexed.Run(ctx, s.Client, []string{s.userKey(user)}, s.key("")).Int()
+}
+
+// DeleteFamilySessions deletes every session issued with the given refresh token family along with their index
+// in a script. It returns the number of deleted sessions.
+func (s *SessionRedisStorage) DeleteFamilySessions(ctx context.Context, family string) (int, error) {
+	return deleteIndexed.Run(ctx, s.Client, []string{s.familyKey(family)}, s.key("")).Int()
+}
+
+// DeleteExpiredSessions does nothing since redis expires the sessions on its own, it always returns zero.
+func (s *SessionRedisStorage) DeleteExpiredSessions(context.Context, time.Time) (int, error) {
+	return 0, nil
+}
@@ -0,0 +1,67 @@
+package storage
+
+import (
+	"context"
+	"time"
+)
+
+// SessionData is an active session, issued to a user by a successful authentication.
+type SessionData struct {
+	ID        string // session id, the jti claim of the session token
+	User      string
+	Family    string // refresh token family the session was issued with
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+}
+
+// Expired reports whether the session is over at the given time.
+func (s *SessionData) Expired(now time.Time) bool {
+	return !now.Before(s.ExpiresAt)
+}
+
+// SessionStorage holds the active sessions indexed by their id, a session that is not stored is revoked.
+type SessionStorage interface {
+	// AddSession stores a session, it fails if the session id is already in use.
+	AddSession(ctx context.Context, s *SessionData) error
+	// GetSession returns the session with the given id, it fails if there is no such session.
+	GetSession(ctx context.Context, id string) (*SessionData, error)
+	// DeleteSession removes the session with the given id, it fails if there is no such session.
+	DeleteSession(ctx context.Context, id string) error
+	// DeleteUserSessions removes every session of a user and returns how many were removed.
+	DeleteUserSessions(ctx context.Context, user string) (int, error)
+	// DeleteFamilySessions removes every session issued with a refresh token family and returns how many were removed.
+	DeleteFamilySessions(ctx context.Context, family string) (int, error)
+	// DeleteExpiredSessions removes the sessions expired at the given time and returns how many were removed.
+	DeleteExpiredSessions(ctx context.Context, now time.Time) (int, error)
+}
+
+// RefreshTokenData is a refresh token, stored by the hash of the token. Every refresh token is used once to get a new
+// session and the next refresh token of its family, the tokens rotated from the same login.
+type RefreshTokenData struct {
+	Hash      string // hex encoded SHA-256 of the token
+	Family    string // id of the family, shared by the tokens rotated from the same login
+	User      string
+	Used      bool // whether the token was already exchanged, using it again revokes the family
+	ExpiresAt time.Time
+}
+
+// Expired reports whether the refresh token can no longer be used at the given time.
+func (r *RefreshTokenData) Expired(now time.Time) bool {
+	return !now.Before(r.ExpiresAt)
+}
+
+// RefreshTokenStorage holds the refresh tokens indexed by their hash, used tokens are kept until they expire
+// so that their reuse is detected.
+type RefreshTokenStorage interface {
+	// AddRefreshToken stores a refresh token, it fails if the hash is already in use.
+	AddRefreshToken(ctx context.Context, rt *RefreshTokenData) error
+	// UseRefreshToken marks the refresh token with the given hash as used and returns it as it was before,
+	// so that only one of concurrent uses sees it unused. It fails if there is no such token.
+	UseRefreshToken(ctx context.Context, hash string) (*RefreshTokenData, error)
+	// DeleteRefreshFamily removes every refresh token of a family and returns how many were removed.
+	DeleteRefreshFamily(ctx context.Context, family string) (int, error)
+	// DeleteUserRefreshTokens removes every refresh token of a user and returns how many were removed.
+	DeleteUserRefreshTokens(ctx context.Context, user string) (int, error)
+	// DeleteExpiredRefreshTokens removes the refresh tokens expired at the given time and returns how many were removed.
+	DeleteExpiredRefreshTokens(ctx context.Context, now time.Time) (int, error)
+}
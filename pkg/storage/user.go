@@ -1,22 +1,107 @@
 package storage
 
+import (
+	"context"
+	"errors"
+	"time"
+	"zkp-api/pkg/zkp"
+)
+
+var (
+	// ErrNotFound is wrapped by the errors of the storages when there is no data under a key, e.g: "user does not exist".
+	ErrNotFound = errors.New("does not exist")
+	// ErrExists is wrapped by the errors of the storages when adding data under a key that already has some.
+	ErrExists = errors.New("does exist")
+)
+
+// UserState is the lifecycle state of a user account, only active users can authenticate.
+type UserState string
+
+const (
+	UserActive   UserState = "active"   // the user can authenticate
+	UserLocked   UserState = "locked"   // temporarily blocked, e.g: after too many failed attempts
+	UserDisabled UserState = "disabled" // blocked by an administrator
+	UserPending  UserState = "pending"  // registered but not yet allowed to authenticate
+)
+
+// Valid reports whether the state is one of the known states.
+func (s UserState) Valid() bool {
+	switch s {
+	case UserActive, UserLocked, UserDisabled, UserPending:
+		return true
+	default:
+		return false
+	}
+}
+
 type VerifierUserData struct {
-	Y1, Y2, R1, R2, C []byte
+	State    UserState     // lifecycle state of the account
+	Protocol string        // name of the zkp backend the user registered with
+	Salt     []byte        // salt the secret is derived from the password with
+	KDF      zkp.KDFParams // parameters the secret is derived from the password with
+	Y1, Y2   []byte
 }
 
+// VerifierStorage holds the registered users indexed by name.
+// note every method of the storages takes the context of the call it serves and fails with the error of the context
+// once it is done, e.g: the deadline of a gRPC call, so that a slow backend does not outlive the call.
 type VerifierStorage interface {
-	AddUser(user string, y1, y2 []byte) error
-	UpdateUserRand(user string, r1, r2 []byte) error
-	UpdateUserChallenge(user string, c []byte) error
-	GetUser(user string) (*VerifierUserData, error)
-	CheckUser(user string) (bool, error)
+	AddUser(ctx context.Context, user string, usr *VerifierUserData) error
+	UpdateUserCommitments(ctx context.Context, user string, salt []byte, kdf zkp.KDFParams, y1, y2 []byte) error
+	GetUser(ctx context.Context, user string) (*VerifierUserData, error)
+	CheckUser(ctx context.Context, user string) (bool, error)
+	SetUserState(ctx context.Context, user string, state UserState) error
+	DeleteUser(ctx context.Context, user string) error
+}
+
+// ChallengeData is a pending authentication challenge: the random commitments (r1, r2) the user sent and the challenge c
+// issued by the verifier, along with when it was issued and for how long it can be answered.
+type ChallengeData struct {
+	User      string
+	R1, R2, C []byte
+	CreatedAt time.Time
+	TTL       time.Duration
+}
+
+// Expired reports whether the challenge can no longer be answered at the given time.
+func (c *ChallengeData) Expired(now time.Time) bool {
+	return !now.Before(c.CreatedAt.Add(c.TTL))
+}
+
+// ChallengeStorage holds the pending challenges indexed by their auth id, a user can have several of them at once.
+// A challenge is a single record holding the user, the commitments (r1, r2) and the challenge c, so that concurrent
+// logins of a user never mix their values: every backend writes it and takes it back in one atomic operation.
+type ChallengeStorage interface {
+	// BeginChallenge atomically stores a challenge under an auth id, it fails if the auth id is already in use.
+	BeginChallenge(ctx context.Context, authID string, ch *ChallengeData) error
+	// ConsumeChallenge atomically removes the challenge stored under an auth id and returns it, so that only one of
+	// concurrent calls gets it and it can only be answered once. It fails if there is no such challenge.
+	ConsumeChallenge(ctx context.Context, authID string) (*ChallengeData, error)
+	// DeleteExpiredChallenges removes the challenges expired at the given time and returns how many were removed.
+	DeleteExpiredChallenges(ctx context.Context, now time.Time) (int, error)
+}
+
+// NonceData is a login nonce issued to a user for a non-interactive proof, along with when it was issued
+// and for how long it can be used.
+type NonceData struct {
+	User      string
+	CreatedAt time.Time
+	TTL       time.Duration
 }
 
-type ProverUserData struct {
-	Password []byte
+// Expired reports whether the nonce can no longer be used at the given time.
+func (n *NonceData) Expired(now time.Time) bool {
+	return !now.Before(n.CreatedAt.Add(n.TTL))
 }
 
-type ProverStorage interface {
-	AddUser(user string, password []byte) error
-	GetUser(user string) ([]byte, error)
+// NonceStorage holds the login nonces indexed by the nonce itself, a user can have several of them at once so that
+// concurrent logins of a user do not invalidate each other's nonce.
+type NonceStorage interface {
+	// AddNonce stores a nonce, it fails if the nonce is already in use.
+	AddNonce(ctx context.Context, nonce string, n *NonceData) error
+	// ConsumeNonce atomically removes a nonce and returns it, so that only one of concurrent calls gets it and it can
+	// only be used once. It fails if there is no such nonce.
+	ConsumeNonce(ctx context.Context, nonce string) (*NonceData, error)
+	// DeleteExpiredNonces removes the nonces expired at the given time and returns how many were removed.
+	DeleteExpiredNonces(ctx context.Context, now time.Time) (int, error)
 }
@@ -0,0 +1,115 @@
+package virtual
+
+import (
+	"context"
+	"sync"
+	"time"
+	"zkp-api/pkg/storage"
+)
+
+// AttemptVirtualStorage is an in-memory storage for the failed authentication attempts.
+// It uses a mutex for concurrent access protection.
+type AttemptVirtualStorage struct {
+	// Embedding a pointer to a sync.Mutex to protect concurrent access.
+	*sync.Mutex
+	// Storage is a map that holds the failed attempts indexed by key.
+	Storage map[string]*storage.AttemptData
+}
+
+// NewAttemptStorage initializes and returns a new instance of AttemptVirtualStorage.
+// It sets up the internal map to store the failed attempts.
+func NewAttemptStorage() *AttemptVirtualStorage {
+	return &AttemptVirtualStorage{
+		Mutex:   new(sync.Mutex),
+		Storage: make(map[string]*storage.AttemptData),
+	}
+}
+
+// GetAttempts retrieves a copy of the attempts recorded under the given key.
+// It locks the storage and returns no failures if there are none.
+func (a *AttemptVirtualStorage) GetAttempts(ctx context.Context, key string) (*storage.AttemptData, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	a.Lock()
+	defer a.Unlock()
+	if d := a.Storage[key]; d != nil {
+		at := *d
+		return &at, nil
+	}
+	return &storage.AttemptData{}, nil
+}
+
+// AddFailure records a failed attempt under the given key.
+// It locks the storage, forgets the failures older than the window and increments the count.
+// Returns a copy of the updated attempts.
+func (a *AttemptVirtualStorage) AddFailure(ctx context.Context, key string, now time.Time, window time.Duration) (*storage.AttemptData, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	a.Lock()
+	defer a.Unlock()
+	d := a.Storage[key]
+	if d == nil || now.Sub(d.LastFailure) > window {
+		d = &storage.AttemptData{}
+		a.Storage[key] = d
+	}
+	d.Failures++
+	d.LastFailure = now
+	at := *d
+	return &at, nil
+}
+
+// ReleaseFailure takes back the failure recorded under the given key at the time failed.
+// It locks the storage, decrements the count and sets the last failure back to last if no failure was recorded since,
+// the attempts are deleted once no failure is left. Releasing a key without attempts is not an error.
+func (a *AttemptVirtualStorage) ReleaseFailure(ctx context.Context, key string, failed, last time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	a.Lock()
+	defer a.Unlock()
+	d := a.Storage[key]
+	if d == nil {
+		return nil
+	}
+	if d.Failures <= 1 {
+		delete(a.Storage, key)
+		return nil
+	}
+	d.Failures--
+	if d.LastFailure.Equal(failed) {
+		d.LastFailure = last
+	}
+	return nil
+}
+
+// ResetAttempts deletes the attempts recorded under the given key.
+// It locks the storage, resetting a key without attempts is not an error.
+func (a *AttemptVirtualStorage) ResetAttempts(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	a.Lock()
+	defer a.Unlock()
+	delete(a.Storage, key)
+	return nil
+}
+
+// DeleteStaleAttempts deletes every attempt whose last failure is before the given time.
+// It locks the storage and returns the number of deleted attempts.
+func (a *AttemptVirtualStorage) DeleteStaleAttempts(ctx context.Context, before time.Time) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	a.Lock()
+	defer a.Unlock()
+	n := 0
+	for key, d := range a.Storage {
+		if d.LastFailure.Before(before) {
+			delete(a.Storage, key)
+			n++
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,85 @@
+package virtual
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+	"zkp-api/pkg/storage"
+)
+
+// ChallengeVirtualStorage is an in-memory storage for the pending authentication challenges.
+// It uses a mutex for concurrent access protection.
+type ChallengeVirtualStorage struct {
+	// Embedding a pointer to a sync.Mutex to protect concurrent access.
+	*sync.Mutex
+	// Storage is a map that holds the pending challenges indexed by auth id.
+	Storage map[string]*storage.ChallengeData
+}
+
+// NewChallengeStorage initializes and returns a new instance of ChallengeVirtualStorage.
+// It sets up the internal map to store the pending challenges.
+func NewChallengeStorage() *ChallengeVirtualStorage {
+	return &ChallengeVirtualStorage{
+		Mutex:   new(sync.Mutex),
+		Storage: make(map[string]*storage.ChallengeData),
+	}
+}
+
+// BeginChallenge adds a pending challenge to the storage under the provided auth id.
+// It locks the storage, checks if the auth id is already in use, and if not,
+// adds the challenge to the storage. Returns an error if the auth id is already in use.
+func (c *ChallengeVirtualStorage) BeginChallenge(ctx context.Context, authID string, ch *storage.ChallengeData) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	c.Lock()
+	defer c.Unlock()
+	if d := c.Storage[authID]; d != nil {
+		return fmt.Errorf("challenge %w", storage.ErrExists)
+	}
+	c.Storage[authID] = &storage.ChallengeData{
+		User:      ch.User,
+		R1:        ch.R1,
+		R2:        ch.R2,
+		C:         ch.C,
+		CreatedAt: ch.CreatedAt,
+		TTL:       ch.TTL,
+	}
+	return nil
+}
+
+// ConsumeChallenge retrieves and deletes the challenge stored under the given auth id.
+// Both happen while holding the lock, so two concurrent calls never get the same challenge.
+// Returns an error if there is no such challenge.
+func (c *ChallengeVirtualStorage) ConsumeChallenge(ctx context.Context, authID string) (*storage.ChallengeData, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	c.Lock()
+	defer c.Unlock()
+	ch := c.Storage[authID]
+	if ch == nil {
+		return nil, fmt.Errorf("challenge %w", storage.ErrNotFound)
+	}
+	delete(c.Storage, authID)
+	return ch, nil
+}
+
+// DeleteExpiredChallenges deletes every challenge expired at the given time.
+// It locks the storage and returns the number of deleted challenges.
+func (c *ChallengeVirtualStorage) DeleteExpiredChallenges(ctx context.Context, now time.Time) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	c.Lock()
+	defer c.Unlock()
+	n := 0
+	for authID, ch := range c.Storage {
+		if ch.Expired(now) {
+			delete(c.Storage, authID)
+			n++
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,82 @@
+package virtual
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+	"zkp-api/pkg/storage"
+)
+
+// NonceVirtualStorage is an in-memory storage for the login nonces.
+// It uses a mutex for concurrent access protection.
+type NonceVirtualStorage struct {
+	// Embedding a pointer to a sync.Mutex to protect concurrent access.
+	*sync.Mutex
+	// Storage is a map that holds the login nonces indexed by nonce.
+	Storage map[string]*storage.NonceData
+}
+
+// NewNonceStorage initializes and returns a new instance of NonceVirtualStorage.
+// It sets up the internal map to store the login nonces.
+func NewNonceStorage() *NonceVirtualStorage {
+	return &NonceVirtualStorage{
+		Mutex:   new(sync.Mutex),
+		Storage: make(map[string]*storage.NonceData),
+	}
+}
+
+// AddNonce adds a login nonce to the storage.
+// It locks the storage, checks if the nonce is already in use, and if not,
+// adds it to the storage. Returns an error if the nonce is already in use.
+func (s *NonceVirtualStorage) AddNonce(ctx context.Context, nonce string, n *storage.NonceData) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+	if d := s.Storage[nonce]; d != nil {
+		return fmt.Errorf("nonce %w", storage.ErrExists)
+	}
+	s.Storage[nonce] = &storage.NonceData{
+		User:      n.User,
+		CreatedAt: n.CreatedAt,
+		TTL:       n.TTL,
+	}
+	return nil
+}
+
+// ConsumeNonce retrieves and deletes the given login nonce.
+// Both happen while holding the lock, so two concurrent calls never get the same nonce.
+// Returns an error if there is no such nonce.
+func (s *NonceVirtualStorage) ConsumeNonce(ctx context.Context, nonce string) (*storage.NonceData, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.Lock()
+	defer s.Unlock()
+	n := s.Storage[nonce]
+	if n == nil {
+		return nil, fmt.Errorf("nonce %w", storage.ErrNotFound)
+	}
+	delete(s.Storage, nonce)
+	return n, nil
+}
+
+// DeleteExpiredNonces deletes every login nonce expired at the given time.
+// It locks the storage and returns the number of deleted nonces.
+func (s *NonceVirtualStorage) DeleteExpiredNonces(ctx context.Context, now time.Time) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	s.Lock()
+	defer s.Unlock()
+	n := 0
+	for nonce, d := range s.Storage {
+		if d.Expired(now) {
+			delete(s.Storage, nonce)
+			n++
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,102 @@
+package virtual
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+	"zkp-api/pkg/storage"
+)
+
+// RefreshTokenVirtualStorage is an in-memory storage for the refresh tokens.
+// It uses a mutex for concurrent access protection.
+type RefreshTokenVirtualStorage struct {
+	// Embedding a pointer to a sync.Mutex to protect concurrent access.
+	*sync.Mutex
+	// Storage is a map that holds the refresh tokens indexed by hash.
+	Storage map[string]*storage.RefreshTokenData
+}
+
+// NewRefreshTokenStorage initializes and returns a new instance of RefreshTokenVirtualStorage.
+// It sets up the internal map to store the refresh tokens.
+func NewRefreshTokenStorage() *RefreshTokenVirtualStorage {
+	return &RefreshTokenVirtualStorage{
+		Mutex:   new(sync.Mutex),
+		Storage: make(map[string]*storage.RefreshTokenData),
+	}
+}
+
+// AddRefreshToken adds a refresh token to the storage under its hash.
+// It locks the storage, checks if the hash is already in use, and if not,
+// adds the token to the storage. Returns an error if the hash is already in use.
+func (r *RefreshTokenVirtualStorage) AddRefreshToken(ctx context.Context, rt *storage.RefreshTokenData) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	r.Lock()
+	defer r.Unlock()
+	if d := r.Storage[rt.Hash]; d != nil {
+		return fmt.Errorf("refresh token %w", storage.ErrExists)
+	}
+	r.Storage[rt.Hash] = &storage.RefreshTokenData{
+		Hash:      rt.Hash,
+		Family:    rt.Family,
+		User:      rt.User,
+		Used:      rt.Used,
+		ExpiresAt: rt.ExpiresAt,
+	}
+	return nil
+}
+
+// UseRefreshToken marks the refresh token with the given hash as used and returns a copy of it as it was before.
+// Both happen while holding the lock, so only one of concurrent calls gets the token unused.
+// Returns an error if there is no such token.
+func (r *RefreshTokenVirtualStorage) UseRefreshToken(ctx context.Context, hash string) (*storage.RefreshTokenData, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r.Lock()
+	defer r.Unlock()
+	rt := r.Storage[hash]
+	if rt == nil {
+		return nil, fmt.Errorf("refresh token %w", storage.ErrNotFound)
+	}
+	prev := *rt
+	rt.Used = true
+	return &prev, nil
+}
+
+// DeleteRefreshFamily deletes every refresh token of the given family.
+// It locks the storage and returns the number of deleted tokens.
+func (r *RefreshTokenVirtualStorage) DeleteRefreshFamily(ctx context.Context, family string) (int, error) {
+	return r.deleteWhere(ctx, func(rt *storage.RefreshTokenData) bool { return rt.Family == family })
+}
+
+// DeleteUserRefreshTokens deletes every refresh token of the given user.
+// It locks the storage and returns the number of deleted tokens.
+func (r *RefreshTokenVirtualStorage) DeleteUserRefreshTokens(ctx context.Context, user string) (int, error) {
+	return r.deleteWhere(ctx, func(rt *storage.RefreshTokenData) bool { return rt.User == user })
+}
+
+// DeleteExpiredRefreshTokens deletes every refresh token expired at the given time.
+// It locks the storage and returns the number of deleted tokens.
+func (r *RefreshTokenVirtualStorage) DeleteExpiredRefreshTokens(ctx context.Context, now time.Time) (int, error) {
+	return r.deleteWhere(ctx, func(rt *storage.RefreshTokenData) bool { return rt.Expired(now) })
+}
+
+// deleteWhere deletes the refresh tokens matching the given predicate while holding the lock.
+func (r *RefreshTokenVirtualStorage) deleteWhere(ctx context.Context, match func(rt *storage.RefreshTokenData) bool) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	r.Lock()
+	defer r.Unlock()
+	n := 0
+	for hash, rt := range r.Storage {
+		if match(rt) {
+			delete(r.Storage, hash)
+			n++
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,133 @@
+package virtual
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+	"zkp-api/pkg/storage"
+)
+
+// SessionVirtualStorage is an in-memory storage for the active sessions.
+// It uses a read-write mutex for concurrent access protection.
+type SessionVirtualStorage struct {
+	// Embedding a pointer to a sync.RWMutex to protect concurrent access.
+	*sync.RWMutex
+	// Storage is a map that holds the active sessions indexed by session id.
+	Storage map[string]*storage.SessionData
+}
+
+// NewSessionStorage initializes and returns a new instance of SessionVirtualStorage.
+// It sets up the internal map to store the active sessions.
+func NewSessionStorage() *SessionVirtualStorage {
+	return &SessionVirtualStorage{
+		RWMutex: new(sync.RWMutex),
+		Storage: make(map[string]*storage.SessionData),
+	}
+}
+
+// AddSession adds a session to the storage under its id.
+// It locks the storage for writing, checks if the id is already in use, and if not,
+// adds the session to the storage. Returns an error if the id is already in use.
+func (s *SessionVirtualStorage) AddSession(ctx context.Context, sess *storage.SessionData) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+	if d := s.Storage[sess.ID]; d != nil {
+		return fmt.Errorf("session %w", storage.ErrExists)
+	}
+	s.Storage[sess.ID] = &storage.SessionData{
+		ID:        sess.ID,
+		User:      sess.User,
+		Family:    sess.Family,
+		IssuedAt:  sess.IssuedAt,
+		ExpiresAt: sess.ExpiresAt,
+	}
+	return nil
+}
+
+// GetSession retrieves the session with the given id.
+// It locks the storage for reading and returns an error if there is no such session.
+func (s *SessionVirtualStorage) GetSession(ctx context.Context, id string) (*storage.SessionData, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.RLock()
+	defer s.RUnlock()
+	sess := s.Storage[id]
+	if sess == nil {
+		return nil, fmt.Errorf("session %w", storage.ErrNotFound)
+	}
+	return sess, nil
+}
+
+// DeleteSession deletes the session with the given id.
+// It locks the storage for writing and returns an error if there is no such session.
+func (s *SessionVirtualStorage) DeleteSession(ctx context.Context, id string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+	if d := s.Storage[id]; d == nil {
+		return fmt.Errorf("session %w", storage.ErrNotFound)
+	}
+	delete(s.Storage, id)
+	return nil
+}
+
+// DeleteUserSessions deletes every session of the given user.
+// It locks the storage for writing and returns the number of deleted sessions.
+func (s *SessionVirtualStorage) DeleteUserSessions(ctx context.Context, user string) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	s.Lock()
+	defer s.Unlock()
+	n := 0
+	for id, sess := range s.Storage {
+		if sess.User == user {
+			delete(s.Storage, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// DeleteFamilySessions deletes every session issued with the given refresh token family.
+// It locks the storage for writing and returns the number of deleted sessions.
+func (s *SessionVirtualStorage) DeleteFamilySessions(ctx context.Context, family string) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	s.Lock()
+	defer s.Unlock()
+	n := 0
+	for id, sess := range s.Storage {
+		if sess.Family == family {
+			delete(s.Storage, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// DeleteExpiredSessions deletes every session expired at the given time.
+// It locks the storage for writing and returns the number of deleted sessions.
+func (s *SessionVirtualStorage) DeleteExpiredSessions(ctx context.Context, now time.Time) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	s.Lock()
+	defer s.Unlock()
+	n := 0
+	for id, sess := range s.Storage {
+		if sess.Expired(now) {
+			delete(s.Storage, id)
+			n++
+		}
+	}
+	return n, nil
+}
@@ -1,9 +1,11 @@
 package virtual
 
 import (
+	"context"
 	"fmt"
 	"sync"
 	"zkp-api/pkg/storage"
+	"zkp-api/pkg/zkp"
 )
 
 // VerifierVirtualStorage is an in-memory storage for verifier user data.
@@ -24,69 +26,78 @@ func NewVerifierStorage() *VerifierVirtualStorage {
 	}
 }
 
-// AddUser adds a new user to the storage with the provided username and public commitments (y1, y2).
+// AddUser adds a new user to the storage with the provided username and registration data, that is the account state,
+// the zkp protocol, the KDF salt and parameters and the public commitments (y1, y2).
 // It locks the storage for writing, checks if the user already exists, and if not,
 // adds the user to the storage. Returns an error if the user already exists.
-func (u *VerifierVirtualStorage) AddUser(user string, y1, y2 []byte) error {
+func (u *VerifierVirtualStorage) AddUser(ctx context.Context, user string, usr *storage.VerifierUserData) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	u.Lock()
 	defer u.Unlock()
 	if d := u.Storage[user]; d != nil {
-		return fmt.Errorf("user does exist")
+		return fmt.Errorf("user %w", storage.ErrExists)
 	}
 	ud := &storage.VerifierUserData{
-		Y1: y1,
-		Y2: y2,
+		State:    usr.State,
+		Protocol: usr.Protocol,
+		Salt:     usr.Salt,
+		KDF:      usr.KDF,
+		Y1:       usr.Y1,
+		Y2:       usr.Y2,
 	}
 	u.Storage[user] = ud
 	return nil
 }
 
-// UpdateUserRand updates the random values (r1, r2) for a given user in the storage.
+// UpdateUserCommitments replaces the salt, the KDF parameters and the public commitments (y1, y2) of a given user.
 // It locks the storage for writing, checks if the user exists, and if so,
-// updates the user's random values. Returns an error if the user does not exist.
-func (u *VerifierVirtualStorage) UpdateUserRand(user string, r1, r2 []byte) error {
-	u.Lock()
-	defer u.Unlock()
-	if d := u.Storage[user]; d == nil {
-		return fmt.Errorf("user does not exist")
+// stores the user's data with the new credentials. Returns an error if the user does not exist.
+func (u *VerifierVirtualStorage) UpdateUserCommitments(ctx context.Context, user string, salt []byte, kdf zkp.KDFParams, y1, y2 []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
 	}
-	u.Storage[user].R1 = r1
-	u.Storage[user].R2 = r2
-	return nil
-}
-
-// UpdateUserChallenge updates the challenge (c) for a given user in the storage.
-// It locks the storage for writing, checks if the user exists, and if so,
-// updates the user's challenge. Returns an error if the user does not exist.
-func (u *VerifierVirtualStorage) UpdateUserChallenge(user string, c []byte) error {
 	u.Lock()
 	defer u.Unlock()
-	if d := u.Storage[user]; d == nil {
-		return fmt.Errorf("user does not exist")
+	d := u.Storage[user]
+	if d == nil {
+		return fmt.Errorf("user %w", storage.ErrNotFound)
 	}
-	u.Storage[user].C = c
+	usr := *d
+	usr.Salt = salt
+	usr.KDF = kdf
+	usr.Y1 = y1
+	usr.Y2 = y2
+	u.Storage[user] = &usr
 	return nil
 }
 
 // GetUser retrieves the verifier user data for the given user from the storage.
 // It locks the storage for reading, checks if the user exists, and if so,
-// returns the user's data. Returns an error if the user does not exist.
-func (u *VerifierVirtualStorage) GetUser(user string) (*storage.VerifierUserData, error) {
-	u.Lock()
-	defer u.Unlock()
-	usr := u.Storage[user]
-	if usr == nil {
-		return nil, fmt.Errorf("user does not exist")
+// returns a copy of the user's data, which later updates do not change. Returns an error if the user does not exist.
+func (u *VerifierVirtualStorage) GetUser(ctx context.Context, user string) (*storage.VerifierUserData, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
 	}
-
-	return usr, nil
+	u.RLock()
+	defer u.RUnlock()
+	d := u.Storage[user]
+	if d == nil {
+		return nil, fmt.Errorf("user %w", storage.ErrNotFound)
+	}
+	usr := *d
+	return &usr, nil
 }
 
 // CheckUser checks if a user exists in the storage.
 // It locks the storage for reading and returns true if the user exists, false otherwise.
 // It does not return an error if the user does not exist, as the absence of a user is not
 // considered an error condition in this context.
-func (u *VerifierVirtualStorage) CheckUser(user string) (bool, error) {
+func (u *VerifierVirtualStorage) CheckUser(ctx context.Context, user string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
 	u.Lock()
 	defer u.Unlock()
 	if d := u.Storage[user]; d == nil {
@@ -94,3 +105,37 @@ func (u *VerifierVirtualStorage) CheckUser(user string) (bool, error) {
 	}
 	return true, nil
 }
+
+// SetUserState updates the lifecycle state of a given user in the storage.
+// It locks the storage for writing, checks if the user exists, and if so,
+// stores the user's data with the new state. Returns an error if the user does not exist.
+func (u *VerifierVirtualStorage) SetUserState(ctx context.Context, user string, state storage.UserState) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	u.Lock()
+	defer u.Unlock()
+	d := u.Storage[user]
+	if d == nil {
+		return fmt.Errorf("user %w", storage.ErrNotFound)
+	}
+	usr := *d
+	usr.State = state
+	u.Storage[user] = &usr
+	return nil
+}
+
+// DeleteUser deletes a given user from the storage.
+// It locks the storage for writing and returns an error if the user does not exist.
+func (u *VerifierVirtualStorage) DeleteUser(ctx context.Context, user string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	u.Lock()
+	defer u.Unlock()
+	if d := u.Storage[user]; d == nil {
+		return fmt.Errorf("user %w", storage.ErrNotFound)
+	}
+	delete(u.Storage, user)
+	return nil
+}
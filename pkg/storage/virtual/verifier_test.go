@@ -0,0 +1,66 @@
+package virtual
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"zkp-api/pkg/storage"
+	"zkp-api/pkg/zkp"
+)
+
+// TestConcurrentUserAccess reads users while their state and credentials change, the users returned by GetUser must not be changed
+// by later writes (go test -race).
+func TestConcurrentUserAccess(t *testing.T) {
+	ctx := context.Background()
+	s := NewVerifierStorage()
+	usr := &storage.VerifierUserData{State: storage.UserActive, Protocol: zkp.Ristretto255, Salt: []byte("salt"),
+		KDF: zkp.DefaultKDFParams, Y1: []byte("y1"), Y2: []byte("y2")}
+	if err := s.AddUser(ctx, "jon", usr); err != nil {
+		t.Fatalf("unable to add user: %s", err.Error())
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		state := storage.UserDisabled
+		if i%2 == 0 {
+			state = storage.UserActive
+		}
+		go func() {
+			defer wg.Done()
+			if err := s.SetUserState(ctx, "jon", state); err != nil {
+				t.Errorf("unable to set state: %s", err.Error())
+			}
+			if err := s.UpdateUserCommitments(ctx, "jon", []byte("salt-2"), zkp.DefaultKDFParams, []byte("y1-2"), []byte("y2-2")); err != nil {
+				t.Errorf("unable to update commitments: %s", err.Error())
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			got, err := s.GetUser(ctx, "jon")
+			if err != nil {
+				t.Errorf("unable to get user: %s", err.Error())
+				return
+			}
+			if got.State != storage.UserActive && got.State != storage.UserDisabled {
+				t.Errorf("got state %q", got.State)
+			}
+			if string(got.Y1) != "y1" && string(got.Y1) != "y1-2" {
+				t.Errorf("got commitment %q", got.Y1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	got, _ := s.GetUser(ctx, "jon")
+	if err := s.SetUserState(ctx, "jon", storage.UserDisabled); err != nil {
+		t.Fatalf("unable to set state: %s", err.Error())
+	}
+	if err := s.SetUserState(ctx, "jon", storage.UserActive); err != nil {
+		t.Fatalf("unable to set state: %s", err.Error())
+	}
+	got.State = storage.UserDisabled
+	if stored, _ := s.GetUser(ctx, "jon"); stored.State != storage.UserActive {
+		t.Fatalf("user changed through the data returned by GetUser")
+	}
+}
@@ -0,0 +1,277 @@
+package zkp
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math/big"
+	"testing"
+
+	"go.dedis.ch/kyber/v3"
+)
+
+// proof holds every value exchanged during one honest run of the protocol.
+type proof struct {
+	y1, y2, r1, r2, nonce, c, s []byte
+}
+
+// honestProof runs the protocol with the given secret and fails the test on any error.
+func honestProof(t *testing.T, p Protocol, secret []byte) proof {
+	t.Helper()
+	var pr proof
+	var err error
+	if pr.y1, pr.y2, err = p.GeneratePublicCommitments(secret); err != nil {
+		t.Fatalf("error generating public commitments: %s", err.Error())
+	}
+	if pr.r1, pr.r2, pr.nonce, err = p.ProverCommitment(); err != nil {
+		t.Fatalf("error generating prover commitments: %s", err.Error())
+	}
+	if pr.c, err = p.GenerateChallenge(pr.r1, pr.r2); err != nil {
+		t.Fatalf("error generating challenge: %s", err.Error())
+	}
+	if pr.s, err = p.SolveChallenge(secret, pr.nonce, pr.c); err != nil {
+		t.Fatalf("error solving challenge: %s", err.Error())
+	}
+	return pr
+}
+
+func mustHex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// flip returns a copy of b with the bits of its last byte inverted.
+func flip(b []byte) []byte {
+	f := bytes.Clone(b)
+	f[len(f)-1] ^= 0xff
+	return f
+}
+
+// invalidEncodings returns per backend encodings that must be rejected as elements and as scalars:
+// the identity, small order points, points outside the prime order subgroup, non canonical and
+// non compressed encodings, and scalars not lower than the group order.
+func invalidEncodings(t *testing.T) map[string]struct{ elements, scalars [][]byte } {
+	t.Helper()
+	invalid := make(map[string]struct{ elements, scalars [][]byte })
+
+	for _, name := range Groups() {
+		grp, _ := GetGroup(name)
+		pm1 := new(big.Int).Sub(grp.P, one)
+		invalid[name] = struct{ elements, scalars [][]byte }{
+			elements: [][]byte{
+				grp.encode(big.NewInt(0)),
+				grp.encode(one), // identity
+				grp.encode(pm1), // order 2
+				grp.encode(grp.P),
+				grp.G.Bytes(), // not of the encoded size of p
+				append([]byte{0}, grp.encode(grp.G)...),
+				grp.encode(new(big.Int).Sub(grp.P, grp.G)), // -g has order 2q
+			},
+			scalars: [][]byte{
+				grp.encodeScalar(grp.Q),
+				big.NewInt(1).Bytes(), // not of the encoded size of q
+			},
+		}
+	}
+
+	l := ed25519Order.FillBytes(make([]byte, 32))
+	for i, j := 0, len(l)-1; i < j; i, j = i+1, j-1 {
+		l[i], l[j] = l[j], l[i]
+	}
+	ed := newEdwards()
+	mixed, _ := ed.encodePoint(suite.Point().Add(ed.G, pointFromHex(t, "26e8958fc2b227b045c3f489f2ef98f0d5dfac05d3c63339b13802886d53fc05")))
+	invalid[Ed25519] = struct{ elements, scalars [][]byte }{
+		elements: [][]byte{
+			mustHex("0100000000000000000000000000000000000000000000000000000000000000"), // identity
+			mustHex("ecffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff7f"), // order 2
+			mustHex("0000000000000000000000000000000000000000000000000000000000000000"), // order 4
+			mustHex("0000000000000000000000000000000000000000000000000000000000000080"), // order 4
+			mustHex("c7176a703d4dd84fba3c0b760d10670f2a2053fa2c39ccc64ec7fd7792ac037a"), // order 8
+			mustHex("26e8958fc2b227b045c3f489f2ef98f0d5dfac05d3c63339b13802886d53fc05"), // order 8
+			mustHex("edffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff7f"), // y = p
+			mixed, // G plus a point of order 8
+			mustHex("0100000000000000000000000000000000000000000000000000000000"), // short
+		},
+		scalars: [][]byte{l, bytes.Repeat([]byte{0xff}, 32), make([]byte, 31)},
+	}
+
+	for _, name := range []string{Secp256k1, P256} {
+		var field, order, uncompressedG string
+		if name == Secp256k1 {
+			field = "fffffffffffffffffffffffffffffffffffffffffffffffffffffffefffffc2f"
+			order = "fffffffffffffffffffffffffffffffebaaedce6af48a03bbfd25e8cd0364141"
+			uncompressedG = "0479be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798483ada7726a3c4655da4fbfc0e1108a8fd17b448a68554199c47d08ffb10d4b8"
+		} else {
+			field = "ffffffff00000001000000000000000000000000ffffffffffffffffffffffff"
+			order = "ffffffff00000000ffffffffffffffffbce6faada7179e84f3b9cac2fc632551"
+			uncompressedG = "046b17d1f2e12c4247f8bce6e563a440f277037d812deb33a0f4a13945d898c2964fe342e2fe1a7f9b8ee7eb4a7c0f9e162bce33576b315ececbb6406837bf51f5"
+		}
+		invalid[name] = struct{ elements, scalars [][]byte }{
+			elements: [][]byte{
+				{0x00},                                  // infinity
+				append([]byte{0x02}, mustHex(field)...), // x = p
+				append([]byte{0x04}, make([]byte, 32)...),
+				mustHex(uncompressedG),
+			},
+			scalars: [][]byte{mustHex(order), bytes.Repeat([]byte{0xff}, 32), make([]byte, 31)},
+		}
+	}
+
+	invalid[Ristretto255] = struct{ elements, scalars [][]byte }{
+		elements: [][]byte{
+			make([]byte, 32), // identity
+			mustHex("0100000000000000000000000000000000000000000000000000000000000000"), // negative s
+			mustHex("edffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff7f"), // s = p
+			mustHex("e2f2ae0a6abc4e71a884a961c500515f58e30b6aa582dd8db6a65945e08d2df6"), // G with its top bit set
+			make([]byte, 31),
+		},
+		scalars: [][]byte{l, bytes.Repeat([]byte{0xff}, 32), make([]byte, 31)},
+	}
+
+	return invalid
+}
+
+func pointFromHex(t *testing.T, s string) kyber.Point {
+	t.Helper()
+	p := suite.Point()
+	if err := p.UnmarshalBinary(mustHex(s)); err != nil {
+		t.Fatalf("error decoding point: %s", err.Error())
+	}
+	return p
+}
+
+// TestConformance runs the checks every backend registered in the package has to pass.
+func TestConformance(t *testing.T) {
+	secret.SetString("929283747463652525354647586969473", 10)
+	sec := secret.Bytes()
+	invalid := invalidEncodings(t)
+
+	for _, name := range Protocols() {
+		name := name
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			p, _ := GetProtocol(name)
+			cd, ok := p.(codec)
+			if !ok {
+				t.Fatalf("backend does not expose its encoding")
+			}
+			bad, ok := invalid[name]
+			if !ok {
+				t.Fatalf("no invalid encodings defined for the backend")
+			}
+
+			pr := honestProof(t, p, sec)
+			if !p.Verify(pr.y1, pr.y2, pr.r1, pr.r2, pr.s, pr.c) {
+				t.Fatalf("honest proof does not verify")
+			}
+			// a second proof of another secret provides valid values to swap in
+			other := honestProof(t, p, []byte("another secret"))
+
+			t.Run("tampered", func(t *testing.T) {
+				tests := []struct {
+					name                 string
+					y1, y2, r1, r2, s, c []byte
+				}{
+					{"y1 flipped", flip(pr.y1), pr.y2, pr.r1, pr.r2, pr.s, pr.c},
+					{"y2 flipped", pr.y1, flip(pr.y2), pr.r1, pr.r2, pr.s, pr.c},
+					{"r1 flipped", pr.y1, pr.y2, flip(pr.r1), pr.r2, pr.s, pr.c},
+					{"r2 flipped", pr.y1, pr.y2, pr.r1, flip(pr.r2), pr.s, pr.c},
+					{"s flipped", pr.y1, pr.y2, pr.r1, pr.r2, flip(pr.s), pr.c},
+					{"c flipped", pr.y1, pr.y2, pr.r1, pr.r2, pr.s, flip(pr.c)},
+					{"y1 swapped", other.y1, pr.y2, pr.r1, pr.r2, pr.s, pr.c},
+					{"y2 swapped", pr.y1, other.y2, pr.r1, pr.r2, pr.s, pr.c},
+					{"r1 swapped", pr.y1, pr.y2, other.r1, pr.r2, pr.s, pr.c},
+					{"r2 swapped", pr.y1, pr.y2, pr.r1, other.r2, pr.s, pr.c},
+					{"s swapped", pr.y1, pr.y2, pr.r1, pr.r2, other.s, pr.c},
+					{"c swapped", pr.y1, pr.y2, pr.r1, pr.r2, pr.s, other.c},
+					{"y1 and y2 exchanged", pr.y2, pr.y1, pr.r1, pr.r2, pr.s, pr.c},
+					{"r1 and r2 exchanged", pr.y1, pr.y2, pr.r2, pr.r1, pr.s, pr.c},
+					{"other user commitments", other.y1, other.y2, pr.r1, pr.r2, pr.s, pr.c},
+					{"empty response", pr.y1, pr.y2, pr.r1, pr.r2, nil, pr.c},
+					{"empty challenge", pr.y1, pr.y2, pr.r1, pr.r2, pr.s, nil},
+				}
+				for _, tt := range tests {
+					if p.Verify(tt.y1, tt.y2, tt.r1, tt.r2, tt.s, tt.c) {
+						t.Errorf("%s: tampered proof verified", tt.name)
+					}
+				}
+			})
+
+			t.Run("invalid encodings", func(t *testing.T) {
+				for i, b := range bad.elements {
+					if _, err := cd.elementRoundTrip(b); err == nil {
+						t.Errorf("element %d (%x) should be rejected", i, b)
+					}
+					// an invalid element in place of any commitment fails the verification
+					if p.Verify(b, pr.y2, pr.r1, pr.r2, pr.s, pr.c) || p.Verify(pr.y1, b, pr.r1, pr.r2, pr.s, pr.c) ||
+						p.Verify(pr.y1, pr.y2, b, pr.r2, pr.s, pr.c) || p.Verify(pr.y1, pr.y2, pr.r1, b, pr.s, pr.c) {
+						t.Errorf("element %d (%x) verified", i, b)
+					}
+				}
+				for i, b := range bad.scalars {
+					if _, err := cd.scalarRoundTrip(b); err == nil {
+						t.Errorf("scalar %d (%x) should be rejected", i, b)
+					}
+					if p.Verify(pr.y1, pr.y2, pr.r1, pr.r2, b, pr.c) || p.Verify(pr.y1, pr.y2, pr.r1, pr.r2, pr.s, b) {
+						t.Errorf("scalar %d (%x) verified", i, b)
+					}
+				}
+			})
+
+			t.Run("random challenge", func(t *testing.T) {
+				c1, err := p.RandomChallenge()
+				if err != nil {
+					t.Fatalf("error generating challenge: %s", err.Error())
+				}
+				c2, err := p.RandomChallenge()
+				if err != nil {
+					t.Fatalf("error generating challenge: %s", err.Error())
+				}
+				if bytes.Equal(c1, c2) {
+					t.Errorf("two random challenges are equal")
+				}
+				if rt, err := cd.scalarRoundTrip(c1); err != nil || !bytes.Equal(rt, c1) {
+					t.Errorf("random challenge is not a canonical scalar")
+				}
+				// the interactive protocol, with the challenge picked by the verifier
+				r1, r2, k, err := p.ProverCommitment()
+				if err != nil {
+					t.Fatalf("error generating prover commitments: %s", err.Error())
+				}
+				s, err := p.SolveChallenge(sec, k, c1)
+				if err != nil {
+					t.Fatalf("error solving challenge: %s", err.Error())
+				}
+				if !p.Verify(pr.y1, pr.y2, r1, r2, s, c1) {
+					t.Errorf("proof with a random challenge does not verify")
+				}
+				if p.Verify(pr.y1, pr.y2, r1, r2, s, c2) {
+					t.Errorf("proof verified with another challenge")
+				}
+			})
+
+			t.Run("round trip", func(t *testing.T) {
+				for i, b := range [][]byte{pr.y1, pr.y2, pr.r1, pr.r2} {
+					rt, err := cd.elementRoundTrip(b)
+					if err != nil {
+						t.Fatalf("element %d: %s", i, err.Error())
+					}
+					if !bytes.Equal(rt, b) {
+						t.Errorf("element %d encoding does not round trip: %x != %x", i, rt, b)
+					}
+				}
+				for i, b := range [][]byte{pr.nonce, pr.c, pr.s} {
+					rt, err := cd.scalarRoundTrip(b)
+					if err != nil {
+						t.Fatalf("scalar %d: %s", i, err.Error())
+					}
+					if !bytes.Equal(rt, b) {
+						t.Errorf("scalar %d encoding does not round trip: %x != %x", i, rt, b)
+					}
+				}
+			})
+		})
+	}
+}
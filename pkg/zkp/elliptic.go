@@ -1,78 +1,276 @@
-//go:build curve
-
 package zkp
 
 import (
 	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+
 	"go.dedis.ch/kyber/v3"
 	"go.dedis.ch/kyber/v3/group/edwards25519"
 	"go.dedis.ch/kyber/v3/util/random"
-	"math/big"
 )
 
+// Ed25519 is the name the Edwards25519 backend is registered under.
+const Ed25519 = "ed25519"
+
 var suite = edwards25519.NewBlakeSHA256Ed25519()
 var rng = random.New()
 
-// GeneratePublicCommitments generates public commitments for a given secret value
-func GeneratePublicCommitments(secret *big.Int) (g kyber.Point, h kyber.Point, xG kyber.Point, xH kyber.Point, x kyber.Scalar) {
-	// Initialize the cryptographic suite
-	//suite := edwards25519.NewBlakeSHA256Ed25519()
+// ed25519Order is the order l of the prime order subgroup of Edwards25519, 2^252 + 27742317777372353535851937790883648493.
+var ed25519Order, _ = new(big.Int).SetString("1000000000000000000000000000000014def9dea2f79cd65812631a5cf5d3ed", 16)
 
-	// Default secret value
-	secretB := secret.Bytes()
-	// Hash the secret to generate a scalar
-	scal := sha256.Sum256(secretB[:])
-	// Convert the hash to a scalar value
-	x = suite.Scalar().SetBytes(scal[:32])
+func init() {
+	RegisterProtocol(newEdwards())
+}
+
+// edwardsPoint exposes the validation methods of the kyber Edwards25519 points,
+// which are not part of the kyber.Point interface.
+type edwardsPoint interface {
+	kyber.Point
+	IsCanonical(b []byte) bool
+	HasSmallOrder() bool
+}
+
+// Edwards implements Protocol over the prime order subgroup of the Edwards25519 curve.
+// G is the standard base point and H is derived by hashing a public seed to the curve,
+// so nobody knows the discrete logarithm of H to base G.
+// Points are encoded in their 32 bytes compressed form and scalars as 32 bytes little-endian integers.
+type Edwards struct {
+	G, H kyber.Point
+}
+
+// newEdwards builds the Edwards25519 backend with its fixed generators.
+func newEdwards() *Edwards {
+	return &Edwards{
+		G: suite.Point().Base(),
+		H: hashToPoint("zkp-api/chaum-pedersen/" + Ed25519 + "/h"),
+	}
+}
+
+// hashToPoint maps a public seed to a point of the prime order subgroup using try-and-increment:
+// SHA-256(seed || counter) is decoded as a compressed point until the decoding succeeds, the point is then
+// multiplied by the cofactor 8 to clear its small order component.
+func hashToPoint(seed string) kyber.Point {
+	eight := suite.Scalar().SetInt64(8)
+	for ctr := uint32(0); ; ctr++ {
+		d := sha256.Sum256(binary.BigEndian.AppendUint32([]byte(seed), ctr))
+		p := suite.Point()
+		if err := p.UnmarshalBinary(d[:]); err != nil {
+			continue // not the y coordinate of a point, try the next counter
+		}
+		p.Mul(eight, p)
+		if p.Equal(suite.Point().Null()) {
+			continue
+		}
+		return p
+	}
+}
+
+// Name returns the name the backend is registered under.
+func (e *Edwards) Name() string {
+	return Ed25519
+}
+
+// Parameters returns the compressed encodings of G and H.
+func (e *Edwards) Parameters() []byte {
+	g, _ := e.encodePoint(e.G)
+	h, _ := e.encodePoint(e.H)
+	return append(g, h...)
+}
+
+// encodePoint serializes a point in its compressed form.
+func (e *Edwards) encodePoint(p kyber.Point) ([]byte, error) {
+	return p.MarshalBinary()
+}
 
-	// Randomly pick two points G and H from the group
-	g = suite.Point().Pick(rng)
-	h = suite.Point().Pick(rng)
+// decodePoint deserializes a compressed point and checks that it is a canonical encoding
+// of a point of the prime order subgroup other than the identity. Returns an error otherwise.
+func (e *Edwards) decodePoint(b []byte) (kyber.Point, error) {
+	p := suite.Point().(edwardsPoint)
+	if !p.IsCanonical(b) {
+		return nil, fmt.Errorf("non canonical point encoding")
+	}
+	if err := p.UnmarshalBinary(b); err != nil {
+		return nil, err
+	}
+	if p.HasSmallOrder() {
+		return nil, fmt.Errorf("point of small order")
+	}
+	// l*P is the identity only for points of the prime order subgroup, since l is 0 as a scalar
+	// the check is done as (8^-1)*(8*P) = P, which removes any small order component of P
+	eight := suite.Scalar().SetInt64(8)
+	inv := suite.Scalar().Inv(eight)
+	if !suite.Point().Mul(inv, suite.Point().Mul(eight, p)).Equal(p) {
+		return nil, fmt.Errorf("point outside the prime order subgroup")
+	}
+	return p, nil
+}
+
+// encodeScalar serializes a scalar as a 32 bytes little-endian integer.
+func (e *Edwards) encodeScalar(s kyber.Scalar) ([]byte, error) {
+	return s.MarshalBinary()
+}
+
+// decodeScalar deserializes a 32 bytes little-endian integer and checks that it is lower than l.
+// Returns an error otherwise.
+func (e *Edwards) decodeScalar(b []byte) (kyber.Scalar, error) {
+	if len(b) != 32 {
+		return nil, fmt.Errorf("scalar must be 32 bytes long")
+	}
+	be := make([]byte, len(b))
+	for i := range b {
+		be[len(b)-1-i] = b[i]
+	}
+	if new(big.Int).SetBytes(be).Cmp(ed25519Order) >= 0 {
+		return nil, fmt.Errorf("non canonical scalar encoding")
+	}
+	s := suite.Scalar()
+	if err := s.UnmarshalBinary(b); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// elementRoundTrip decodes and encodes back a point, see codec.
+func (e *Edwards) elementRoundTrip(b []byte) ([]byte, error) {
+	p, err := e.decodePoint(b)
+	if err != nil {
+		return nil, err
+	}
+	return e.encodePoint(p)
+}
+
+// scalarRoundTrip decodes and encodes back a scalar, see codec.
+func (e *Edwards) scalarRoundTrip(b []byte) ([]byte, error) {
+	s, err := e.decodeScalar(b)
+	if err != nil {
+		return nil, err
+	}
+	return e.encodeScalar(s)
+}
+
+// randomScalar picks a non zero scalar uniformly at random.
+func (e *Edwards) randomScalar() kyber.Scalar {
+	s := suite.Scalar().Pick(rng)
+	for s.Equal(suite.Scalar().Zero()) {
+		s = suite.Scalar().Pick(rng)
+	}
+	return s
+}
+
+// secretScalar maps a secret to a scalar by hashing it, the hash is reduced modulo l.
+func (e *Edwards) secretScalar(secret []byte) kyber.Scalar {
+	scal := sha256.Sum256(secret)
+	return suite.Scalar().SetBytes(scal[:])
+}
+
+// GeneratePublicCommitments generates the public commitments xG and xH for a given secret value.
+func (e *Edwards) GeneratePublicCommitments(secret []byte) (y1, y2 []byte, err error) {
+	// Hash the secret to generate a scalar
+	x := e.secretScalar(secret)
+	if x.Equal(suite.Scalar().Zero()) {
+		return nil, nil, fmt.Errorf("secret maps to the zero scalar")
+	}
 	// Compute xG and xH
-	xG = suite.Point().Mul(x, g)
-	xH = suite.Point().Mul(x, h)
+	if y1, err = e.encodePoint(suite.Point().Mul(x, e.G)); err != nil {
+		return nil, nil, err
+	}
+	if y2, err = e.encodePoint(suite.Point().Mul(x, e.H)); err != nil {
+		return nil, nil, err
+	}
 
-	return g, h, xG, xH, x
+	return y1, y2, nil
 }
 
-// ProverCommitment generates a Chaum-Pedersen proof commitment given two points g and h
-func ProverCommitment(g, h kyber.Point) (kyber.Point, kyber.Point, kyber.Scalar) {
+// ProverCommitment generates a Chaum-Pedersen proof commitment kG and kH for a random scalar k.
+func (e *Edwards) ProverCommitment() (r1, r2, nonce []byte, err error) {
 	// Begin Chaum-Pedersen proof
-	// Randomly pick a scalar k
-	k := suite.Scalar().Pick(rng)
+	// Randomly pick a non zero scalar k
+	k := e.randomScalar()
 	// Compute kG and kH
-	kG := suite.Point().Mul(k, g)
-	kH := suite.Point().Mul(k, h)
+	if r1, err = e.encodePoint(suite.Point().Mul(k, e.G)); err != nil {
+		return nil, nil, nil, err
+	}
+	if r2, err = e.encodePoint(suite.Point().Mul(k, e.H)); err != nil {
+		return nil, nil, nil, err
+	}
+	if nonce, err = e.encodeScalar(k); err != nil {
+		return nil, nil, nil, err
+	}
+
+	return r1, r2, nonce, nil
+}
 
-	return kG, kH, k
+// GenerateChallenge derives the challenge scalar from the prover's commitments kG and kH.
+func (e *Edwards) GenerateChallenge(r1, r2 []byte) ([]byte, error) {
+	return e.HashChallenge(append(append([]byte{}, r1...), r2...))
 }
 
-// GenerateChallenge generates a random scalar to act as a challenge
-func GenerateChallenge() kyber.Scalar {
-	// Randomly pick a scalar to act as a challenge
-	return suite.Scalar().Pick(rng) //cScalar
+// HashChallenge derives a challenge scalar by hashing msg, the hash is reduced modulo l.
+func (e *Edwards) HashChallenge(msg []byte) ([]byte, error) {
+	hash := sha256.Sum256(msg)
+	// Convert the hash to a scalar, SetBytes reduces it modulo l
+	c := suite.Scalar().SetBytes(hash[:])
+	if c.Equal(suite.Scalar().Zero()) {
+		return nil, fmt.Errorf("challenge cannot be zero")
+	}
+	return e.encodeScalar(c)
+}
+
+// RandomChallenge samples a non zero challenge scalar uniformly at random for the interactive protocol.
+func (e *Edwards) RandomChallenge() ([]byte, error) {
+	return e.encodeScalar(e.randomScalar())
 }
 
 // SolveChallenge computes the response to a challenge in an elliptic curve cryptographic system.
 // It takes three parameters:
-//   - cScalar: the scalar value representing the challenge c
-//   - x: the scalar value representing x
-//   - k: the scalar value representing the prover's commitment k
+//   - secret: the secret the scalar x is derived from
+//   - nonce: the scalar value representing the prover's commitment k
+//   - c: the scalar value representing the challenge c
 //
 // It computes the response r as r = k - cx and returns it as a scalar value.
-func SolveChallenge(cScalar, x, k kyber.Scalar) kyber.Scalar {
+func (e *Edwards) SolveChallenge(secret, nonce, c []byte) ([]byte, error) {
+	k, err := e.decodeScalar(nonce)
+	if err != nil {
+		return nil, fmt.Errorf("invalid nonce: %s", err.Error())
+	}
+	cScalar, err := e.decodeScalar(c)
+	if err != nil {
+		return nil, fmt.Errorf("invalid challenge: %s", err.Error())
+	}
+	x := e.secretScalar(secret)
+
 	// Compute the response r = k - cx
 	r := suite.Scalar()
 	r.Mul(x, cScalar).Sub(k, r)
 
-	return r
+	return e.encodeScalar(r)
 }
 
 // Verify performs a verification step and returns a boolean value indicating whether the verification is successful or not.
-func Verify(cScalar, r kyber.Scalar, g, h, xG, xH, kG, kH kyber.Point) bool {
+// It checks that kG == rG + cxG and kH == rH + cxH, any invalid point or scalar encoding fails the verification.
+func (e *Edwards) Verify(y1, y2, r1, r2, s, c []byte) bool {
+	var err error
+	points := make([]kyber.Point, 4)
+	for i, b := range [][]byte{y1, y2, r1, r2} {
+		if points[i], err = e.decodePoint(b); err != nil {
+			return false
+		}
+	}
+	xG, xH, kG, kH := points[0], points[1], points[2], points[3]
+	r, err := e.decodeScalar(s)
+	if err != nil {
+		return false
+	}
+	cScalar, err := e.decodeScalar(c)
+	if err != nil || cScalar.Equal(suite.Scalar().Zero()) {
+		return false
+	}
+
 	// Compute rG and rH
-	rG := suite.Point().Mul(r, g)
-	rH := suite.Point().Mul(r, h)
+	rG := suite.Point().Mul(r, e.G)
+	rH := suite.Point().Mul(r, e.H)
 	// Compute cxG and cxH
 	cxG := suite.Point().Mul(cScalar, xG)
 	cxH := suite.Point().Mul(cScalar, xH)
@@ -85,12 +283,7 @@ func Verify(cScalar, r kyber.Scalar, g, h, xG, xH, kG, kH kyber.Point) bool {
 
 // oneStepEllipticCurveCP performs one step semi-interactive (way of generating challenge) a Chaum-Pedersen proof using
 // elliptic curve cryptography.
-func oneStepEllipticCurveCP(secret *big.Int) bool {
-	var rng = random.New()
-
-	// Initialize the cryptographic suite
-	suite := edwards25519.NewBlakeSHA256Ed25519()
-
+func (e *Edwards) oneStepEllipticCurveCP(secret *big.Int) bool {
 	// Default secret value
 	secretB := secret.Bytes()
 	// Hash the secret to generate a scalar
@@ -98,19 +291,16 @@ func oneStepEllipticCurveCP(secret *big.Int) bool {
 	// Convert the hash to a scalar value
 	x := suite.Scalar().SetBytes(scal[:32])
 
-	// Randomly pick two points G and H from the group
-	G := suite.Point().Pick(rng)
-	H := suite.Point().Pick(rng)
 	// Compute xG and xH
-	xG := suite.Point().Mul(x, G)
-	xH := suite.Point().Mul(x, H)
+	xG := suite.Point().Mul(x, e.G)
+	xH := suite.Point().Mul(x, e.H)
 
 	// Begin Chaum-Pedersen proof
 	// Randomly pick a scalar k
 	k := suite.Scalar().Pick(rng)
 	// Compute kG and kH
-	kG := suite.Point().Mul(k, G)
-	kH := suite.Point().Mul(k, H)
+	kG := suite.Point().Mul(k, e.G)
+	kH := suite.Point().Mul(k, e.H)
 
 	// Alice sends challenge - Randomly pick a scalar to act as a challenge
 	cScalar := suite.Scalar().Pick(rng)
@@ -121,8 +311,8 @@ func oneStepEllipticCurveCP(secret *big.Int) bool {
 
 	// Verification step
 	// Compute rG and rH
-	rG := suite.Point().Mul(r, G)
-	rH := suite.Point().Mul(r, H)
+	rG := suite.Point().Mul(r, e.G)
+	rH := suite.Point().Mul(r, e.H)
 	// Compute cxG and cxH
 	cxG := suite.Point().Mul(cScalar, xG)
 	cxH := suite.Point().Mul(cScalar, xH)
@@ -1,19 +1,11 @@
-//go:build curve
-
 package zkp
 
 import (
 	"crypto/sha256"
-	"go.dedis.ch/kyber/v3"
-	"go.dedis.ch/kyber/v3/group/edwards25519"
 	"math/big"
 	"testing"
 )
 
-var (
-	secret = new(big.Int)
-)
-
 func TestOneStepElliptic(t *testing.T) {
 	secret.SetString("929283747463652525354647586969473", 10)
 
@@ -31,9 +23,10 @@ func TestOneStepElliptic(t *testing.T) {
 		},
 	}
 
+	ed := newEdwards()
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
-			if !oneStepEllipticCurveCP(test.input) {
+			if !ed.oneStepEllipticCurveCP(test.input) {
 				t.Fatalf("unable to verify")
 			}
 		})
@@ -57,18 +50,31 @@ func TestEllipticCurveFlow(t *testing.T) {
 		},
 	}
 
+	ed := newEdwards()
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
 
-			g, h, xg, xh, x := GeneratePublicCommitments(test.input)
+			xg, xh, err := ed.GeneratePublicCommitments(test.input.Bytes())
+			if err != nil {
+				t.Fatalf("error generating public commitments: %s", err.Error())
+			}
 
-			kg, kh, k := ProverCommitment(g, h)
+			kg, kh, k, err := ed.ProverCommitment()
+			if err != nil {
+				t.Fatalf("error generating prover commitments: %s", err.Error())
+			}
 
-			cScalar := GenerateChallenge()
+			c, err := ed.GenerateChallenge(kg, kh)
+			if err != nil {
+				t.Fatalf("error generating challenge: %s", err.Error())
+			}
 
-			r := SolveChallenge(cScalar, x, k)
+			r, err := ed.SolveChallenge(test.input.Bytes(), k, c)
+			if err != nil {
+				t.Fatalf("error solving challenge: %s", err.Error())
+			}
 
-			if valid := Verify(cScalar, r, g, h, xg, xh, kg, kh); !valid {
+			if valid := ed.Verify(xg, xh, kg, kh, r, c); !valid {
 				t.Fatalf("unable to verify")
 			}
 
@@ -76,6 +82,23 @@ func TestEllipticCurveFlow(t *testing.T) {
 	}
 }
 
+func TestEllipticGenerators(t *testing.T) {
+	ed, other := newEdwards(), newEdwards()
+	// generators must be the same on every instance, otherwise prover and verifier could not agree
+	if !ed.G.Equal(other.G) || !ed.H.Equal(other.H) {
+		t.Fatalf("generators must be deterministic")
+	}
+	if ed.G.Equal(ed.H) {
+		t.Fatalf("g and h must be independent")
+	}
+	for _, p := range []interface{ MarshalBinary() ([]byte, error) }{ed.G, ed.H} {
+		b, _ := p.MarshalBinary()
+		if _, err := ed.decodePoint(b); err != nil {
+			t.Fatalf("generator is not a valid point: %s", err.Error())
+		}
+	}
+}
+
 func TestEllipticFailProveCommitmentCurveFlow(t *testing.T) {
 	secret.SetString("929283747463652525354647586969473", 10)
 
@@ -93,22 +116,31 @@ func TestEllipticFailProveCommitmentCurveFlow(t *testing.T) {
 		},
 	}
 
+	ed := newEdwards()
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
 
-			g, h, xg, xh, x := GeneratePublicCommitments(test.input)
+			xg, xh, err := ed.GeneratePublicCommitments(test.input.Bytes())
+			if err != nil {
+				t.Fatalf("error generating public commitments: %s", err.Error())
+			}
 
-			//pick random values for ProverCommitment(g, h) in order to fail the test
-			kg, kh, k := func() (kyber.Point, kyber.Point, kyber.Scalar) {
-				NotValidSuite := edwards25519.NewBlakeSHA256Ed25519()
-				return NotValidSuite.Point().Pick(rng), NotValidSuite.Point().Pick(rng), NotValidSuite.Scalar().Pick(rng)
-			}()
+			//pick random values for ProverCommitment in order to fail the test
+			kg, _ := suite.Point().Pick(rng).MarshalBinary()
+			kh, _ := suite.Point().Pick(rng).MarshalBinary()
+			k, _ := suite.Scalar().Pick(rng).MarshalBinary()
 
-			cScalar := GenerateChallenge()
+			c, err := ed.GenerateChallenge(kg, kh)
+			if err != nil {
+				t.Fatalf("error generating challenge: %s", err.Error())
+			}
 
-			r := SolveChallenge(cScalar, x, k)
+			r, err := ed.SolveChallenge(test.input.Bytes(), k, c)
+			if err != nil {
+				t.Fatalf("error solving challenge: %s", err.Error())
+			}
 
-			if valid := Verify(cScalar, r, g, h, xg, xh, kg, kh); valid {
+			if valid := ed.Verify(xg, xh, kg, kh, r, c); valid {
 				t.Fatalf("test should fail")
 			}
 		})
@@ -132,23 +164,34 @@ func TestEllipticFailVerificationBySolveChallengeCurveFlow(t *testing.T) {
 		},
 	}
 
+	ed := newEdwards()
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
 
-			g, h, xg, xh, _ := GeneratePublicCommitments(test.input)
+			xg, xh, err := ed.GeneratePublicCommitments(test.input.Bytes())
+			if err != nil {
+				t.Fatalf("error generating public commitments: %s", err.Error())
+			}
 
-			kg, kh, k := ProverCommitment(g, h)
+			kg, kh, k, err := ed.ProverCommitment()
+			if err != nil {
+				t.Fatalf("error generating prover commitments: %s", err.Error())
+			}
 
-			cScalar := GenerateChallenge()
+			c, err := ed.GenerateChallenge(kg, kh)
+			if err != nil {
+				t.Fatalf("error generating challenge: %s", err.Error())
+			}
 
-			// GenerateChallenge fake challenge
-			// Generate fake values of x or k based on unrelated or invalid data
-			fakeX := suite.Scalar().SetInt64(1)
-			//fakeK := suite.Scalar().SetInt64(1)
+			// Generate fake values of x based on unrelated or invalid data
+			fakeX := []byte("not the secret")
 
-			r := SolveChallenge(cScalar, fakeX, k)
+			r, err := ed.SolveChallenge(fakeX, k, c)
+			if err != nil {
+				t.Fatalf("error solving challenge: %s", err.Error())
+			}
 
-			if valid := Verify(cScalar, r, g, h, xg, xh, kg, kh); valid {
+			if valid := ed.Verify(xg, xh, kg, kh, r, c); valid {
 				t.Fatalf("test should fail")
 			}
 		})
@@ -172,37 +215,83 @@ func TestEllipticFailVerifyCurveFlow(t *testing.T) {
 		},
 	}
 
+	ed := newEdwards()
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
 
-			g, h, xg, xh, x := GeneratePublicCommitments(test.input)
+			xg, xh, err := ed.GeneratePublicCommitments(test.input.Bytes())
+			if err != nil {
+				t.Fatalf("error generating public commitments: %s", err.Error())
+			}
 
-			kg, kh, k := ProverCommitment(g, h)
+			kg, kh, k, err := ed.ProverCommitment()
+			if err != nil {
+				t.Fatalf("error generating prover commitments: %s", err.Error())
+			}
 
-			cScalar := GenerateChallenge()
+			c, err := ed.GenerateChallenge(kg, kh)
+			if err != nil {
+				t.Fatalf("error generating challenge: %s", err.Error())
+			}
 
-			r := SolveChallenge(cScalar, x, k)
+			r, err := ed.SolveChallenge(test.input.Bytes(), k, c)
+			if err != nil {
+				t.Fatalf("error solving challenge: %s", err.Error())
+			}
 
 			// change params to fail verification step
 			zz := sha256.Sum256([]byte("intentional mismatch"))
-			cScalar = suite.Scalar().SetBytes(zz[:32]) // fails the test #1
-			//r = cScalar // fails the test #2
-			//cScalar = r // fails the test #3
-
-			// Verification step
-			// Compute rG and rH
-			rG := suite.Point().Mul(r, g)
-			rH := suite.Point().Mul(r, h)
-			// Compute cxG and cxH
-			cxG := suite.Point().Mul(cScalar, xg)
-			cxH := suite.Point().Mul(cScalar, xh)
-			// Check if kG == rG + cxG and kH == rH + cxH
-			a := suite.Point().Add(rG, cxG)
-			b := suite.Point().Add(rH, cxH)
-
-			if kg.Equal(a) && kh.Equal(b) {
+			c, _ = suite.Scalar().SetBytes(zz[:32]).MarshalBinary()
+
+			if valid := ed.Verify(xg, xh, kg, kh, r, c); valid {
 				t.Fatalf("test should fail")
 			}
 		})
 	}
 }
+
+func TestEllipticRejectInvalidEncodings(t *testing.T) {
+	secret.SetString("929283747463652525354647586969473", 10)
+	ed := newEdwards()
+
+	xg, xh, err := ed.GeneratePublicCommitments(secret.Bytes())
+	if err != nil {
+		t.Fatalf("error generating public commitments: %s", err.Error())
+	}
+	kg, kh, k, err := ed.ProverCommitment()
+	if err != nil {
+		t.Fatalf("error generating prover commitments: %s", err.Error())
+	}
+	c, err := ed.GenerateChallenge(kg, kh)
+	if err != nil {
+		t.Fatalf("error generating challenge: %s", err.Error())
+	}
+	r, err := ed.SolveChallenge(secret.Bytes(), k, c)
+	if err != nil {
+		t.Fatalf("error solving challenge: %s", err.Error())
+	}
+
+	identity, _ := suite.Point().Null().MarshalBinary()
+	// l, the group order, as a little-endian scalar is not canonical
+	order := ed25519Order.FillBytes(make([]byte, 32))
+	for i, j := 0, len(order)-1; i < j; i, j = i+1, j-1 {
+		order[i], order[j] = order[j], order[i]
+	}
+
+	tests := []struct {
+		name              string
+		xg, xh, kg, kh, r []byte
+	}{
+		{name: "identity xG", xg: identity, xh: xh, kg: kg, kh: kh, r: r},
+		{name: "truncated kH", xg: xg, xh: xh, kg: kg, kh: kh[:31], r: r},
+		{name: "non canonical r", xg: xg, xh: xh, kg: kg, kh: kh, r: order},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if valid := ed.Verify(test.xg, test.xh, test.kg, test.kh, test.r, c); valid {
+				t.Fatalf("verification should fail")
+			}
+		})
+	}
+}
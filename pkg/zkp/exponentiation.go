@@ -1,5 +1,3 @@
-//go:build expo
-
 package zkp
 
 import (
@@ -9,12 +7,7 @@ import (
 	"math/big"
 )
 
-// Assuming p, g, and h are defined globally
-var (
-	p = big.NewInt(23) // prime number
-	g = big.NewInt(4)  // generator g
-	h = big.NewInt(9)  // generator h
-)
+var one = big.NewInt(1)
 
 // modExp calculates (base^exp) % mod using big.Int for large numbers.
 // This function is a fundamental operation in many cryptographic protocols,
@@ -24,140 +17,195 @@ func modExp(base, exp, mod *big.Int) *big.Int {
 	return new(big.Int).Exp(base, exp, mod)
 }
 
-// generateNonce generates a random nonce less than max.
+// generateNonce generates a random nonce in the range [1, max).
 // In the context of the Chaum–Pedersen protocol, a nonce is a secret random
 // number used once to ensure that the outputs of the protocol are not reusable,
 // preserving the security properties of the protocol.
 func generateNonce(max *big.Int) (*big.Int, error) {
-	n, err := rand.Int(rand.Reader, max)
+	n, err := rand.Int(rand.Reader, new(big.Int).Sub(max, one))
 	if err != nil {
 		return nil, err
 	}
-	return n, nil
+	return n.Add(n, one), nil
+}
+
+// Name returns the name of the group, which is the name it is registered under as a Protocol.
+func (grp *Group) Name() string {
+	return grp.name
+}
+
+// encode serializes a group element as a big-endian byte slice with the length of p,
+// so that every element of the group has the same encoded size.
+func (grp *Group) encode(e *big.Int) []byte {
+	return e.FillBytes(make([]byte, (grp.P.BitLen()+7)/8))
+}
+
+// encodeScalar serializes an exponent as a big-endian byte slice with the length of q.
+func (grp *Group) encodeScalar(e *big.Int) []byte {
+	return e.FillBytes(make([]byte, (grp.Q.BitLen()+7)/8))
+}
+
+// decodeElement deserializes a group element and checks that it has the encoded size of p,
+// belongs to the subgroup of order q and is not the identity. Returns nil if the element is not valid.
+func (grp *Group) decodeElement(b []byte) *big.Int {
+	if len(b) != (grp.P.BitLen()+7)/8 {
+		return nil
+	}
+	e := new(big.Int).SetBytes(b)
+	if e.Cmp(one) <= 0 || e.Cmp(grp.P) >= 0 {
+		return nil
+	}
+	// for a safe prime the subgroup of order q is the only one where e^q = 1
+	if modExp(e, grp.Q, grp.P).Cmp(one) != 0 {
+		return nil
+	}
+	return e
+}
+
+// decodeScalar deserializes an exponent and checks that it has the encoded size of q and lies in [min, q).
+// Returns nil if the exponent is not valid.
+func (grp *Group) decodeScalar(b []byte, min int64) *big.Int {
+	if len(b) != (grp.Q.BitLen()+7)/8 {
+		return nil
+	}
+	e := new(big.Int).SetBytes(b)
+	if e.Cmp(big.NewInt(min)) < 0 || e.Cmp(grp.Q) >= 0 {
+		return nil
+	}
+	return e
+}
+
+// Parameters returns p, g and h encoded as group elements, q is implied by p.
+func (grp *Group) Parameters() []byte {
+	params := grp.encode(grp.P)
+	params = append(params, grp.encode(grp.G)...)
+	return append(params, grp.encode(grp.H)...)
+}
+
+// elementRoundTrip decodes and encodes back a group element, see codec.
+func (grp *Group) elementRoundTrip(b []byte) ([]byte, error) {
+	e := grp.decodeElement(b)
+	if e == nil {
+		return nil, fmt.Errorf("invalid group element")
+	}
+	return grp.encode(e), nil
+}
+
+// scalarRoundTrip decodes and encodes back an exponent, see codec.
+func (grp *Group) scalarRoundTrip(b []byte) ([]byte, error) {
+	e := grp.decodeScalar(b, 0)
+	if e == nil {
+		return nil, fmt.Errorf("invalid exponent")
+	}
+	return grp.encodeScalar(e), nil
 }
 
 // GeneratePublicCommitments generates public commitments y1 and y2 from a secret
 // within the Chaum–Pedersen protocol. These commitments are used to publicly
 // demonstrate knowledge of a secret while keeping the secret hidden.
-func GeneratePublicCommitments(secret *big.Int) (y1, y2 []byte, err error) {
-	y1I := modExp(g, secret, p)
-	y2I := modExp(h, secret, p)
-	// note: error being return for compatibility with elliptic curve implementation
-	return y1I.Bytes(), y2I.Bytes(), nil
+// The secret is read as a big-endian integer and reduced modulo q.
+func (grp *Group) GeneratePublicCommitments(secret []byte) (y1, y2 []byte, err error) {
+	x := new(big.Int).SetBytes(secret)
+	x.Mod(x, grp.Q)
+	if x.Sign() == 0 {
+		return nil, nil, fmt.Errorf("secret must not be a multiple of the group order")
+	}
+	y1I := modExp(grp.G, x, grp.P)
+	y2I := modExp(grp.H, x, grp.P)
+
+	return grp.encode(y1I), grp.encode(y2I), nil
 }
 
 // ProverCommitment generates random commitments r1 and r2 for the prover
 // within the Chaum–Pedersen protocol. These commitments are used to create
 // a proof of knowledge of the secret that corresponds to the public commitments.
-func ProverCommitment() (r1, r2 []byte, r *big.Int, err error) {
-	r, err = generateNonce(new(big.Int).Sub(p, big.NewInt(1))) // nonce should be less than p
+func (grp *Group) ProverCommitment() (r1, r2, nonce []byte, err error) {
+	r, err := generateNonce(grp.Q) // nonce should be in Z_q
 	if err != nil {
 		return nil, nil, nil, fmt.Errorf("error generating nonce: %s", err.Error())
 	}
 
-	r1I := modExp(g, r, p)
-	r2I := modExp(h, r, p)
+	r1I := modExp(grp.G, r, grp.P)
+	r2I := modExp(grp.H, r, grp.P)
 
-	return r1I.Bytes(), r2I.Bytes(), r, nil
+	return grp.encode(r1I), grp.encode(r2I), grp.encodeScalar(r), nil
 }
 
 // GenerateChallenge generates a challenge for the Chaum–Pedersen protocol.
 // The challenge is derived from the prover's random commitments and is used
 // by the verifier to ensure the prover's knowledge of the secret without
 // revealing the secret itself.
-func GenerateChallenge(r1b, r2b []byte) *big.Int {
-	hash := sha256.New()
-	hash.Write(r1b)
-	hash.Write(r2b)
-	hashed := hash.Sum(nil)
-
-	// Convert the hash to a big.Int, then reduce modulo p
-	c := new(big.Int).SetBytes(hashed)
-	c.Mod(c, p)
+func (grp *Group) GenerateChallenge(r1b, r2b []byte) ([]byte, error) {
+	return grp.HashChallenge(append(append([]byte{}, r1b...), r2b...))
+}
+
+// HashChallenge derives a challenge by hashing msg and reducing the hash modulo q.
+func (grp *Group) HashChallenge(msg []byte) ([]byte, error) {
+	hashed := sha256.Sum256(msg)
+
+	// Convert the hash to a big.Int, then reduce modulo q
+	c := new(big.Int).SetBytes(hashed[:])
+	c.Mod(c, grp.Q)
 	if c.Sign() == 0 {
-		return nil // Challenge cannot be zero after modulo operation
+		return nil, fmt.Errorf("challenge cannot be zero") // Challenge cannot be zero after modulo operation
+	}
+	return grp.encodeScalar(c), nil
+}
+
+// RandomChallenge samples a challenge uniformly from [1, q) for the interactive Chaum–Pedersen protocol.
+func (grp *Group) RandomChallenge() ([]byte, error) {
+	c, err := generateNonce(grp.Q)
+	if err != nil {
+		return nil, fmt.Errorf("error generating challenge: %s", err.Error())
 	}
-	return c
+	return grp.encodeScalar(c), nil
 }
 
 // SolveChallenge computes the solution to a given challenge in the Chaum–Pedersen protocol.
 // The solution is a value that, when combined with the public commitments and the prover's
 // random commitments, will satisfy the verification equation without revealing the secret.
-func SolveChallenge(secret, r, c *big.Int) (*big.Int, error) {
-	s := new(big.Int).Sub(r, new(big.Int).Mul(c, secret))
-	s.Mod(s, new(big.Int).Sub(p, big.NewInt(1))) // Adjusted to ensure s is within Z_q
+func (grp *Group) SolveChallenge(secret, nonce, cb []byte) ([]byte, error) {
+	r := grp.decodeScalar(nonce, 1)
+	c := grp.decodeScalar(cb, 1)
+	if r == nil || c == nil {
+		return nil, fmt.Errorf("invalid nonce or challenge")
+	}
+	x := new(big.Int).SetBytes(secret)
+
+	s := new(big.Int).Sub(r, new(big.Int).Mul(c, x))
+	s.Mod(s, grp.Q) // exponents live in Z_q, the order of g and h
 
-	// note returns error for due elliptic curve implementation does
-	return s, nil
+	return grp.encodeScalar(s), nil
 }
 
 // Verify checks if the prover's response to a challenge in the Chaum–Pedersen protocol is correct.
 // It ensures that the commitments and the solution satisfy the verification equation,
 // confirming the prover's knowledge of the secret associated with the public commitments.
-func Verify(y1b, y2b, r1b, r2b []byte, s, c *big.Int) bool {
-	y1 := new(big.Int).SetBytes(y1b)
-	y2 := new(big.Int).SetBytes(y2b)
-	r1 := new(big.Int).SetBytes(r1b)
-	r2 := new(big.Int).SetBytes(r2b)
-
-	// Verify
-	gs := modExp(g, s, p)   // g^s mod p
-	y1c := modExp(y1, c, p) // y1^c mod p
-	r1Computed := new(big.Int).Mul(gs, y1c)
-	r1Computed.Mod(r1Computed, p) // (g^s * y1^c) mod p
-
-	hs := modExp(h, s, p)   // h^s mod p
-	y2c := modExp(y2, c, p) // y2^c mod p
-	r2Computed := new(big.Int).Mul(hs, y2c)
-	r2Computed.Mod(r2Computed, p) // (h^s * y2^c) mod p
-
-	// Compare the computed values with the prover's commitments
-	return r1.Cmp(r1Computed) == 0 && r2.Cmp(r2Computed) == 0
-}
-
-// oneStepCHExponentiation performs the Chaum-Pedersen protocol in one step
-func oneStepCHExponentiation(secret *big.Int) bool {
-	// GeneratePublicCommitments
-	y1 := modExp(g, secret, p)
-	y2 := modExp(h, secret, p)
-
-	// ProverCommitment
-	r, err := generateNonce(new(big.Int).Sub(p, big.NewInt(1))) // nonce should be less than p
-	if err != nil {
-		fmt.Println("Error generating nonce:", err)
+// Commitments that are not elements of the subgroup of order q are rejected.
+func (grp *Group) Verify(y1b, y2b, r1b, r2b, sb, cb []byte) bool {
+	s := grp.decodeScalar(sb, 0)
+	c := grp.decodeScalar(cb, 1)
+	if s == nil || c == nil {
 		return false
 	}
-
-	r1 := modExp(g, r, p)
-	r2 := modExp(h, r, p)
-
-	// GenerateChallenge
-	hash := sha256.New()
-	hash.Write(r1.Bytes())
-	hash.Write(r2.Bytes())
-	hashed := hash.Sum(nil)
-
-	// Convert the hash to a big.Int, then reduce modulo p
-	bigC := new(big.Int).SetBytes(hashed)
-	bigC.Mod(bigC, p)
-	if bigC.Sign() == 0 {
-		return false // Challenge cannot be zero after modulo operation
+	y1 := grp.decodeElement(y1b)
+	y2 := grp.decodeElement(y2b)
+	r1 := grp.decodeElement(r1b)
+	r2 := grp.decodeElement(r2b)
+	if y1 == nil || y2 == nil || r1 == nil || r2 == nil {
+		return false
 	}
 
-	// SolveChallenge
-	s := new(big.Int).Sub(r, new(big.Int).Mul(bigC, secret))
-	s.Mod(s, new(big.Int).Sub(p, big.NewInt(1))) // Adjusted to ensure s is within Z_q
-
 	// Verify
-	gs := modExp(g, s, p)      // g^s mod p
-	y1c := modExp(y1, bigC, p) // y1^c mod p
+	gs := modExp(grp.G, s, grp.P) // g^s mod p
+	y1c := modExp(y1, c, grp.P)   // y1^c mod p
 	r1Computed := new(big.Int).Mul(gs, y1c)
-	r1Computed.Mod(r1Computed, p) // (g^s * y1^c) mod p
+	r1Computed.Mod(r1Computed, grp.P) // (g^s * y1^c) mod p
 
-	hs := modExp(h, s, p)      // h^s mod p
-	y2c := modExp(y2, bigC, p) // y2^c mod p
+	hs := modExp(grp.H, s, grp.P) // h^s mod p
+	y2c := modExp(y2, c, grp.P)   // y2^c mod p
 	r2Computed := new(big.Int).Mul(hs, y2c)
-	r2Computed.Mod(r2Computed, p) // (h^s * y2^c) mod p
+	r2Computed.Mod(r2Computed, grp.P) // (h^s * y2^c) mod p
 
 	// Compare the computed values with the prover's commitments
 	return r1.Cmp(r1Computed) == 0 && r2.Cmp(r2Computed) == 0
@@ -1,16 +1,11 @@
-//go:build expo
-
 package zkp
 
 import (
+	"crypto/sha256"
 	"math/big"
 	"testing"
 )
 
-var (
-	secret = new(big.Int)
-)
-
 func TestOneStepCHExponentiation(t *testing.T) {
 	secret.SetString("929283747463652525354647586969473", 10)
 
@@ -28,12 +23,15 @@ func TestOneStepCHExponentiation(t *testing.T) {
 		},
 	}
 
-	for _, test := range tests {
-		t.Run(test.name, func(t *testing.T) {
-			if valid := oneStepCHExponentiation(test.input); !valid {
-				t.Fatalf("unable to verify")
-			}
-		})
+	for _, name := range Groups() {
+		grp, _ := GetGroup(name)
+		for _, test := range tests {
+			t.Run(name+" "+test.name, func(t *testing.T) {
+				if valid := grp.oneStepCHExponentiation(test.input); !valid {
+					t.Fatalf("unable to verify")
+				}
+			})
+		}
 	}
 }
 
@@ -54,55 +52,65 @@ func TestExponentiationFlow(t *testing.T) {
 		},
 	}
 
-	for _, test := range tests {
-		t.Run(test.name, func(t *testing.T) {
-			y1, y2, err := GeneratePublicCommitments(test.input)
-			if err != nil {
-				t.Fatalf("error generating public commitments: %s", err.Error())
-			}
-			r1, r2, r, err := ProverCommitment()
-			if err != nil {
-				t.Fatalf("error generating random commitments: %s", err.Error())
-			}
-			c := GenerateChallenge(r1, r2)
+	for _, name := range Groups() {
+		grp, _ := GetGroup(name)
+		for _, test := range tests {
+			t.Run(name+" "+test.name, func(t *testing.T) {
+				y1, y2, err := grp.GeneratePublicCommitments(test.input.Bytes())
+				if err != nil {
+					t.Fatalf("error generating public commitments: %s", err.Error())
+				}
+				r1, r2, r, err := grp.ProverCommitment()
+				if err != nil {
+					t.Fatalf("error generating random commitments: %s", err.Error())
+				}
+				c, err := grp.GenerateChallenge(r1, r2)
+				if err != nil {
+					t.Fatalf("error generating challenge: %s", err.Error())
+				}
 
-			s, err := SolveChallenge(test.input, r, c)
-			if err != nil {
-				t.Fatalf("error solving challenge: %s", err.Error())
-			}
+				s, err := grp.SolveChallenge(test.input.Bytes(), r, c)
+				if err != nil {
+					t.Fatalf("error solving challenge: %s", err.Error())
+				}
 
-			if valid := Verify(y1, y2, r1, r2, s, c); !valid {
-				t.Fatalf("unable to verify")
-			}
-		})
+				if valid := grp.Verify(y1, y2, r1, r2, s, c); !valid {
+					t.Fatalf("unable to verify")
+				}
+			})
+		}
 	}
 }
 
 func TestVerifyWithIncorrectChallenge(t *testing.T) {
 	// Set up the initial conditions
 	secret.SetString("929283747463652525354647586969473", 10)
+	grp, _ := GetGroup(DefaultGroup)
 
-	y1, y2, err := GeneratePublicCommitments(secret)
+	y1, y2, err := grp.GeneratePublicCommitments(secret.Bytes())
 	if err != nil {
 		t.Fatalf("error generating public commitments: %s", err.Error())
 	}
-	r1, r2, r, err := ProverCommitment()
+	r1, r2, r, err := grp.ProverCommitment()
 	if err != nil {
 		t.Fatalf("error generating prover commitments: %s", err.Error())
 	}
-	c := GenerateChallenge(r1, r2)
+	c, err := grp.GenerateChallenge(r1, r2)
+	if err != nil {
+		t.Fatalf("error generating challenge: %s", err.Error())
+	}
 
 	// Solve the challenge correctly
-	s, err := SolveChallenge(secret, r, c)
+	s, err := grp.SolveChallenge(secret.Bytes(), r, c)
 	if err != nil {
 		t.Fatalf("error solving challenge: %s", err.Error())
 	}
 
 	// Intentionally use an incorrect challenge for verification
-	incorrectChallenge := new(big.Int).SetInt64(111999)
+	incorrectChallenge := new(big.Int).SetInt64(111999).Bytes()
 
 	// Perform the verification with the incorrect challenge
-	valid := Verify(y1, y2, r1, r2, s, incorrectChallenge)
+	valid := grp.Verify(y1, y2, r1, r2, s, incorrectChallenge)
 	if valid {
 		t.Fatalf("Verification should fail with incorrect challenge")
 	}
@@ -111,16 +119,20 @@ func TestVerifyWithIncorrectChallenge(t *testing.T) {
 func TestTamperedCommitments(t *testing.T) {
 	// Set up the initial conditions
 	secret.SetString("929283747463652525354647586969473", 10)
+	grp, _ := GetGroup(DefaultGroup)
 
-	y1, y2, err := GeneratePublicCommitments(secret)
+	y1, y2, err := grp.GeneratePublicCommitments(secret.Bytes())
 	if err != nil {
 		t.Fatalf("error generating public commitments: %s", err.Error())
 	}
-	r1, r2, r, err := ProverCommitment()
+	r1, r2, r, err := grp.ProverCommitment()
 	if err != nil {
 		t.Fatalf("error generating prover commitments: %s", err.Error())
 	}
-	c := GenerateChallenge(r1, r2)
+	c, err := grp.GenerateChallenge(r1, r2)
+	if err != nil {
+		t.Fatalf("error generating challenge: %s", err.Error())
+	}
 
 	// Tamper with the commitments
 	tamperedR1 := make([]byte, len(r1))
@@ -131,14 +143,136 @@ func TestTamperedCommitments(t *testing.T) {
 	copy(tamperedR2, r2)
 	tamperedR2[0] ^= 0xFF // Flip some bits to tamper the data
 
-	s, err := SolveChallenge(secret, r, c)
+	s, err := grp.SolveChallenge(secret.Bytes(), r, c)
 	if err != nil {
 		t.Fatalf("error solving challenge: %s", err.Error())
 	}
 
 	// Perform the verification with the tampered commitments
-	valid := Verify(y1, y2, tamperedR1, tamperedR2, s, c)
+	valid := grp.Verify(y1, y2, tamperedR1, tamperedR2, s, c)
 	if valid {
 		t.Fatalf("Verification should fail with tampered commitments")
 	}
 }
+
+func TestGroups(t *testing.T) {
+	for _, name := range Groups() {
+		t.Run(name, func(t *testing.T) {
+			grp, err := GetGroup(name)
+			if err != nil {
+				t.Fatalf("error getting group: %s", err.Error())
+			}
+			if !grp.P.ProbablyPrime(0) || !grp.Q.ProbablyPrime(0) {
+				t.Fatalf("p and q must be prime")
+			}
+			if new(big.Int).Add(new(big.Int).Lsh(grp.Q, 1), one).Cmp(grp.P) != 0 {
+				t.Fatalf("p must be the safe prime 2q + 1")
+			}
+			// both generators must have order q
+			for _, gen := range []*big.Int{grp.G, grp.H} {
+				if gen.Cmp(one) <= 0 || modExp(gen, grp.Q, grp.P).Cmp(one) != 0 {
+					t.Fatalf("generator %s does not have order q", gen.String())
+				}
+			}
+			if grp.G.Cmp(grp.H) == 0 {
+				t.Fatalf("g and h must be independent")
+			}
+		})
+	}
+
+	if _, err := GetGroup("p23"); err == nil {
+		t.Fatalf("unknown group should fail")
+	}
+}
+
+func TestRejectElementsOutsideSubgroup(t *testing.T) {
+	secret.SetString("929283747463652525354647586969473", 10)
+	grp, _ := GetGroup(DefaultGroup)
+
+	y1, y2, err := grp.GeneratePublicCommitments(secret.Bytes())
+	if err != nil {
+		t.Fatalf("error generating public commitments: %s", err.Error())
+	}
+	r1, r2, r, err := grp.ProverCommitment()
+	if err != nil {
+		t.Fatalf("error generating prover commitments: %s", err.Error())
+	}
+	c, err := grp.GenerateChallenge(r1, r2)
+	if err != nil {
+		t.Fatalf("error generating challenge: %s", err.Error())
+	}
+	s, err := grp.SolveChallenge(secret.Bytes(), r, c)
+	if err != nil {
+		t.Fatalf("error solving challenge: %s", err.Error())
+	}
+
+	// p-1 has order 2, so it is not part of the subgroup of order q
+	minusOne := grp.encode(new(big.Int).Sub(grp.P, one))
+	tests := []struct {
+		name           string
+		y1, y2, r1, r2 []byte
+	}{
+		{name: "identity y1", y1: grp.encode(one), y2: y2, r1: r1, r2: r2},
+		{name: "order two y2", y1: y1, y2: minusOne, r1: r1, r2: r2},
+		{name: "zero r1", y1: y1, y2: y2, r1: nil, r2: r2},
+		{name: "p as r2", y1: y1, y2: y2, r1: r1, r2: grp.P.Bytes()},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if valid := grp.Verify(test.y1, test.y2, test.r1, test.r2, s, c); valid {
+				t.Fatalf("verification should fail")
+			}
+		})
+	}
+}
+
+// oneStepCHExponentiation performs the Chaum-Pedersen protocol in one step
+func (grp *Group) oneStepCHExponentiation(secret *big.Int) bool {
+	p, q, g, h := grp.P, grp.Q, grp.G, grp.H
+
+	// GeneratePublicCommitments
+	x := new(big.Int).Mod(secret, q)
+	y1 := modExp(g, x, p)
+	y2 := modExp(h, x, p)
+
+	// ProverCommitment
+	r, err := generateNonce(q) // nonce should be in Z_q
+	if err != nil {
+		return false
+	}
+
+	r1 := modExp(g, r, p)
+	r2 := modExp(h, r, p)
+
+	// GenerateChallenge
+	hash := sha256.New()
+	hash.Write(grp.encode(r1))
+	hash.Write(grp.encode(r2))
+	hashed := hash.Sum(nil)
+
+	// Convert the hash to a big.Int, then reduce modulo q
+	bigC := new(big.Int).SetBytes(hashed)
+	bigC.Mod(bigC, q)
+	if bigC.Sign() == 0 {
+		return false // Challenge cannot be zero after modulo operation
+	}
+
+	// SolveChallenge
+	s := new(big.Int).Sub(r, new(big.Int).Mul(bigC, x))
+	s.Mod(s, q)
+
+	// Verify
+	gs := modExp(g, s, p)      // g^s mod p
+	y1c := modExp(y1, bigC, p) // y1^c mod p
+	r1Computed := new(big.Int).Mul(gs, y1c)
+	r1Computed.Mod(r1Computed, p) // (g^s * y1^c) mod p
+
+	hs := modExp(h, s, p)      // h^s mod p
+	y2c := modExp(y2, bigC, p) // y2^c mod p
+	r2Computed := new(big.Int).Mul(hs, y2c)
+	r2Computed.Mod(r2Computed, p) // (h^s * y2^c) mod p
+
+	// Compare the computed values with the prover's commitments
+	return r1.Cmp(r1Computed) == 0 && r2.Cmp(r2Computed) == 0
+}
@@ -0,0 +1,188 @@
+package zkp
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"sort"
+)
+
+// Names of the supported groups. The modp groups are the MODP groups 14, 15 and 16
+// defined in RFC 3526, the ffdhe groups are the finite field groups defined in RFC 7919.
+const (
+	MODP2048  = "modp2048"
+	MODP3072  = "modp3072"
+	MODP4096  = "modp4096"
+	FFDHE2048 = "ffdhe2048"
+	FFDHE3072 = "ffdhe3072"
+	FFDHE4096 = "ffdhe4096"
+
+	// DefaultGroup is the group used when none has been configured.
+	DefaultGroup = MODP2048
+)
+
+// groupPrimes holds the hexadecimal safe primes p = 2q + 1 of every supported group.
+// All of them are congruent to 7 mod 8, which makes 2 a quadratic residue and therefore
+// a generator of the subgroup of prime order q.
+var groupPrimes = map[string]string{
+	MODP2048: "FFFFFFFFFFFFFFFFC90FDAA22168C234C4C6628B80DC1CD129024E088A67CC74" +
+		"020BBEA63B139B22514A08798E3404DDEF9519B3CD3A431B302B0A6DF25F1437" +
+		"4FE1356D6D51C245E485B576625E7EC6F44C42E9A637ED6B0BFF5CB6F406B7ED" +
+		"EE386BFB5A899FA5AE9F24117C4B1FE649286651ECE45B3DC2007CB8A163BF05" +
+		"98DA48361C55D39A69163FA8FD24CF5F83655D23DCA3AD961C62F356208552BB" +
+		"9ED529077096966D670C354E4ABC9804F1746C08CA18217C32905E462E36CE3B" +
+		"E39E772C180E86039B2783A2EC07A28FB5C55DF06F4C52C9DE2BCBF695581718" +
+		"3995497CEA956AE515D2261898FA051015728E5A8AACAA68FFFFFFFFFFFFFFFF",
+	MODP3072: "FFFFFFFFFFFFFFFFC90FDAA22168C234C4C6628B80DC1CD129024E088A67CC74" +
+		"020BBEA63B139B22514A08798E3404DDEF9519B3CD3A431B302B0A6DF25F1437" +
+		"4FE1356D6D51C245E485B576625E7EC6F44C42E9A637ED6B0BFF5CB6F406B7ED" +
+		"EE386BFB5A899FA5AE9F24117C4B1FE649286651ECE45B3DC2007CB8A163BF05" +
+		"98DA48361C55D39A69163FA8FD24CF5F83655D23DCA3AD961C62F356208552BB" +
+		"9ED529077096966D670C354E4ABC9804F1746C08CA18217C32905E462E36CE3B" +
+		"E39E772C180E86039B2783A2EC07A28FB5C55DF06F4C52C9DE2BCBF695581718" +
+		"3995497CEA956AE515D2261898FA051015728E5A8AAAC42DAD33170D04507A33" +
+		"A85521ABDF1CBA64ECFB850458DBEF0A8AEA71575D060C7DB3970F85A6E1E4C7" +
+		"ABF5AE8CDB0933D71E8C94E04A25619DCEE3D2261AD2EE6BF12FFA06D98A0864" +
+		"D87602733EC86A64521F2B18177B200CBBE117577A615D6C770988C0BAD946E2" +
+		"08E24FA074E5AB3143DB5BFCE0FD108E4B82D120A93AD2CAFFFFFFFFFFFFFFFF",
+	MODP4096: "FFFFFFFFFFFFFFFFC90FDAA22168C234C4C6628B80DC1CD129024E088A67CC74" +
+		"020BBEA63B139B22514A08798E3404DDEF9519B3CD3A431B302B0A6DF25F1437" +
+		"4FE1356D6D51C245E485B576625E7EC6F44C42E9A637ED6B0BFF5CB6F406B7ED" +
+		"EE386BFB5A899FA5AE9F24117C4B1FE649286651ECE45B3DC2007CB8A163BF05" +
+		"98DA48361C55D39A69163FA8FD24CF5F83655D23DCA3AD961C62F356208552BB" +
+		"9ED529077096966D670C354E4ABC9804F1746C08CA18217C32905E462E36CE3B" +
+		"E39E772C180E86039B2783A2EC07A28FB5C55DF06F4C52C9DE2BCBF695581718" +
+		"3995497CEA956AE515D2261898FA051015728E5A8AAAC42DAD33170D04507A33" +
+		"A85521ABDF1CBA64ECFB850458DBEF0A8AEA71575D060C7DB3970F85A6E1E4C7" +
+		"ABF5AE8CDB0933D71E8C94E04A25619DCEE3D2261AD2EE6BF12FFA06D98A0864" +
+		"D87602733EC86A64521F2B18177B200CBBE117577A615D6C770988C0BAD946E2" +
+		"08E24FA074E5AB3143DB5BFCE0FD108E4B82D120A92108011A723C12A787E6D7" +
+		"88719A10BDBA5B2699C327186AF4E23C1A946834B6150BDA2583E9CA2AD44CE8" +
+		"DBBBC2DB04DE8EF92E8EFC141FBECAA6287C59474E6BC05D99B2964FA090C3A2" +
+		"233BA186515BE7ED1F612970CEE2D7AFB81BDD762170481CD0069127D5B05AA9" +
+		"93B4EA988D8FDDC186FFB7DC90A6C08F4DF435C934063199FFFFFFFFFFFFFFFF",
+	FFDHE2048: "FFFFFFFFFFFFFFFFADF85458A2BB4A9AAFDC5620273D3CF1D8B9C583CE2D3695" +
+		"A9E13641146433FBCC939DCE249B3EF97D2FE363630C75D8F681B202AEC4617A" +
+		"D3DF1ED5D5FD65612433F51F5F066ED0856365553DED1AF3B557135E7F57C935" +
+		"984F0C70E0E68B77E2A689DAF3EFE8721DF158A136ADE73530ACCA4F483A797A" +
+		"BC0AB182B324FB61D108A94BB2C8E3FBB96ADAB760D7F4681D4F42A3DE394DF4" +
+		"AE56EDE76372BB190B07A7C8EE0A6D709E02FCE1CDF7E2ECC03404CD28342F61" +
+		"9172FE9CE98583FF8E4F1232EEF28183C3FE3B1B4C6FAD733BB5FCBC2EC22005" +
+		"C58EF1837D1683B2C6F34A26C1B2EFFA886B423861285C97FFFFFFFFFFFFFFFF",
+	FFDHE3072: "FFFFFFFFFFFFFFFFADF85458A2BB4A9AAFDC5620273D3CF1D8B9C583CE2D3695" +
+		"A9E13641146433FBCC939DCE249B3EF97D2FE363630C75D8F681B202AEC4617A" +
+		"D3DF1ED5D5FD65612433F51F5F066ED0856365553DED1AF3B557135E7F57C935" +
+		"984F0C70E0E68B77E2A689DAF3EFE8721DF158A136ADE73530ACCA4F483A797A" +
+		"BC0AB182B324FB61D108A94BB2C8E3FBB96ADAB760D7F4681D4F42A3DE394DF4" +
+		"AE56EDE76372BB190B07A7C8EE0A6D709E02FCE1CDF7E2ECC03404CD28342F61" +
+		"9172FE9CE98583FF8E4F1232EEF28183C3FE3B1B4C6FAD733BB5FCBC2EC22005" +
+		"C58EF1837D1683B2C6F34A26C1B2EFFA886B4238611FCFDCDE355B3B6519035B" +
+		"BC34F4DEF99C023861B46FC9D6E6C9077AD91D2691F7F7EE598CB0FAC186D91C" +
+		"AEFE130985139270B4130C93BC437944F4FD4452E2D74DD364F2E21E71F54BFF" +
+		"5CAE82AB9C9DF69EE86D2BC522363A0DABC521979B0DEADA1DBF9A42D5C4484E" +
+		"0ABCD06BFA53DDEF3C1B20EE3FD59D7C25E41D2B66C62E37FFFFFFFFFFFFFFFF",
+	FFDHE4096: "FFFFFFFFFFFFFFFFADF85458A2BB4A9AAFDC5620273D3CF1D8B9C583CE2D3695" +
+		"A9E13641146433FBCC939DCE249B3EF97D2FE363630C75D8F681B202AEC4617A" +
+		"D3DF1ED5D5FD65612433F51F5F066ED0856365553DED1AF3B557135E7F57C935" +
+		"984F0C70E0E68B77E2A689DAF3EFE8721DF158A136ADE73530ACCA4F483A797A" +
+		"BC0AB182B324FB61D108A94BB2C8E3FBB96ADAB760D7F4681D4F42A3DE394DF4" +
+		"AE56EDE76372BB190B07A7C8EE0A6D709E02FCE1CDF7E2ECC03404CD28342F61" +
+		"9172FE9CE98583FF8E4F1232EEF28183C3FE3B1B4C6FAD733BB5FCBC2EC22005" +
+		"C58EF1837D1683B2C6F34A26C1B2EFFA886B4238611FCFDCDE355B3B6519035B" +
+		"BC34F4DEF99C023861B46FC9D6E6C9077AD91D2691F7F7EE598CB0FAC186D91C" +
+		"AEFE130985139270B4130C93BC437944F4FD4452E2D74DD364F2E21E71F54BFF" +
+		"5CAE82AB9C9DF69EE86D2BC522363A0DABC521979B0DEADA1DBF9A42D5C4484E" +
+		"0ABCD06BFA53DDEF3C1B20EE3FD59D7C25E41D2B669E1EF16E6F52C3164DF4FB" +
+		"7930E9E4E58857B6AC7D5F42D69F6D187763CF1D5503400487F55BA57E31CC7A" +
+		"7135C886EFB4318AED6A1E012D9E6832A907600A918130C46DC778F971AD0038" +
+		"092999A333CB8B7A1A1DB93D7140003C2A4ECEA9F98D0ACC0A8291CDCEC97DCF" +
+		"8EC9B55A7F88A46B4DB5A851F44182E1C68A007E5E655F6AFFFFFFFFFFFFFFFF",
+}
+
+// groups is the registry of named groups, it is populated once at init.
+// Every group is also registered as a Protocol under its name.
+var groups = make(map[string]*Group, len(groupPrimes))
+
+func init() {
+	for name, hex := range groupPrimes {
+		groups[name] = newGroup(name, hex)
+		RegisterProtocol(groups[name])
+	}
+}
+
+// Group is a subgroup of prime order q of Z_p^*, where p = 2q + 1 is a safe prime.
+// G and H are two independent generators of the subgroup: G is the standard generator 2
+// and H is derived from a public seed, so nobody knows the discrete logarithm of H to base G.
+// Group implements Protocol using modular exponentiation.
+type Group struct {
+	name string
+	P    *big.Int // safe prime modulus
+	Q    *big.Int // order of the subgroup, (P-1)/2
+	G    *big.Int // generator g
+	H    *big.Int // generator h
+}
+
+// newGroup builds the group called name from its hexadecimal safe prime.
+func newGroup(name, hex string) *Group {
+	p, ok := new(big.Int).SetString(hex, 16)
+	if !ok {
+		panic(fmt.Sprintf("invalid prime for group %s", name))
+	}
+	q := new(big.Int).Rsh(p, 1)
+
+	return &Group{
+		name: name,
+		P:    p,
+		Q:    q,
+		G:    big.NewInt(2),
+		H:    deriveGenerator(p, "zkp-api/chaum-pedersen/"+name+"/h"),
+	}
+}
+
+// GetGroup returns the group registered under the given name.
+// Returns an error if there is no such group.
+func GetGroup(name string) (*Group, error) {
+	grp, ok := groups[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown group '%s'", name)
+	}
+	return grp, nil
+}
+
+// Groups returns the sorted names of all the registered groups.
+func Groups() []string {
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// deriveGenerator derives a generator of the subgroup of order q = (p-1)/2 from a public seed.
+// The seed is expanded with SHA-256 into an integer u that is reduced modulo p, the result is
+// h = u^2 mod p. Squaring maps u into the quadratic residues, which for a safe prime are exactly
+// the subgroup of order q, so any h other than 1 generates it. Since h comes out of a hash,
+// its discrete logarithm to any other generator is unknown.
+func deriveGenerator(p *big.Int, seed string) *big.Int {
+	// 16 extra bytes make the reduction modulo p statistically indistinguishable from uniform
+	size := (p.BitLen()+7)/8 + 16
+	one := big.NewInt(1)
+	for ctr := uint32(0); ; ctr++ {
+		buf := make([]byte, 0, size+sha256.Size)
+		for blk := uint32(0); len(buf) < size; blk++ {
+			d := sha256.New()
+			d.Write([]byte(seed))
+			d.Write(binary.BigEndian.AppendUint32(nil, ctr))
+			d.Write(binary.BigEndian.AppendUint32(nil, blk))
+			buf = d.Sum(buf)
+		}
+		u := new(big.Int).SetBytes(buf[:size])
+		u.Mod(u, p)
+		h := u.Mul(u, u).Mod(u, p)
+		if h.Cmp(one) > 0 {
+			return h
+		}
+	}
+}
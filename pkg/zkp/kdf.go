@@ -0,0 +1,89 @@
+package zkp
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+
+	"golang.org/x/crypto/argon2"
+)
+
+const (
+	// SaltSize is the size in bytes of the per user salts generated by NewSalt.
+	SaltSize = 16
+	// secretSize is the size in bytes of the secrets derived from passwords, every backend reduces
+	// them into a scalar of its group.
+	secretSize = 32
+
+	// MaxKDFTime, MaxKDFMemory and MaxKDFThreads bound the KDF parameters, the verifier hands the parameters of a user
+	// to whoever asks for them, so the prover would otherwise derive secrets with whatever costs were registered.
+	MaxKDFTime    = 10
+	MaxKDFMemory  = 1024 * 1024 // KiB, 1 GiB
+	MaxKDFThreads = 16
+)
+
+// KDFParams holds the Argon2id cost parameters a secret is derived with.
+// They are stored by the verifier along with the salt, so that the costs can be raised for new users
+// while the existing ones keep logging in with the parameters they registered with.
+type KDFParams struct {
+	Time    uint32 `yaml:"time"`    // number of passes over the memory
+	Memory  uint32 `yaml:"memory"`  // memory in KiB
+	Threads uint8  `yaml:"threads"` // degree of parallelism
+}
+
+// DefaultKDFParams are the Argon2id parameters recommended by RFC 9106 for memory constrained environments.
+var DefaultKDFParams = KDFParams{
+	Time:    3,
+	Memory:  64 * 1024,
+	Threads: 4,
+}
+
+// Validate checks that the parameters are accepted by Argon2id and within MaxKDFTime, MaxKDFMemory and MaxKDFThreads.
+// Returns an error otherwise.
+func (p KDFParams) Validate() error {
+	if p.Time < 1 || p.Time > MaxKDFTime {
+		return fmt.Errorf("kdf time must be between 1 and %d", MaxKDFTime)
+	}
+	if p.Threads < 1 || p.Threads > MaxKDFThreads {
+		return fmt.Errorf("kdf threads must be between 1 and %d", MaxKDFThreads)
+	}
+	if p.Memory < 8*uint32(p.Threads) {
+		return fmt.Errorf("kdf memory must be at least 8 KiB per thread")
+	}
+	if p.Memory > MaxKDFMemory {
+		return fmt.Errorf("kdf memory must be at most %d KiB", MaxKDFMemory)
+	}
+	return nil
+}
+
+// bytes encodes the parameters into a fixed size byte slice, to bind them into a transcript.
+func (p KDFParams) bytes() []byte {
+	b := binary.BigEndian.AppendUint32(nil, p.Time)
+	b = binary.BigEndian.AppendUint32(b, p.Memory)
+	return append(b, p.Threads)
+}
+
+// NewSalt returns a random salt of SaltSize bytes.
+func NewSalt() ([]byte, error) {
+	salt := make([]byte, SaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("error generating salt: %s", err.Error())
+	}
+	return salt, nil
+}
+
+// DeriveSecret derives the secret x of the Chaum–Pedersen protocol from a password using Argon2id.
+// The password can be any string, the per user salt makes the same password produce unrelated secrets
+// for different users. Returns an error if the password or the salt are empty or the parameters are not valid.
+func DeriveSecret(password string, salt []byte, params KDFParams) ([]byte, error) {
+	if password == "" {
+		return nil, fmt.Errorf("password cannot be empty")
+	}
+	if len(salt) == 0 {
+		return nil, fmt.Errorf("salt cannot be empty")
+	}
+	if err := params.Validate(); err != nil {
+		return nil, err
+	}
+	return argon2.IDKey([]byte(password), salt, params.Time, params.Memory, params.Threads, secretSize), nil
+}
@@ -0,0 +1,93 @@
+package zkp
+
+import (
+	"bytes"
+	"testing"
+)
+
+// testKDFParams keeps the tests fast, they are far below the costs that should be used in production.
+var testKDFParams = KDFParams{Time: 1, Memory: 64, Threads: 1}
+
+func TestDeriveSecret(t *testing.T) {
+	salt, err := NewSalt()
+	if err != nil {
+		t.Fatalf("error generating salt: %s", err.Error())
+	}
+	otherSalt, _ := NewSalt()
+
+	x, err := DeriveSecret("correct horse battery staple", salt, testKDFParams)
+	if err != nil {
+		t.Fatalf("error deriving secret: %s", err.Error())
+	}
+	if len(x) != secretSize {
+		t.Fatalf("secret is %d bytes long, expected %d", len(x), secretSize)
+	}
+
+	tests := []struct {
+		name     string
+		password string
+		salt     []byte
+		params   KDFParams
+		same     bool
+	}{
+		{"same inputs", "correct horse battery staple", salt, testKDFParams, true},
+		{"other password", "correct horse battery stapler", salt, testKDFParams, false},
+		{"other salt", "correct horse battery staple", otherSalt, testKDFParams, false},
+		{"other params", "correct horse battery staple", salt, KDFParams{Time: 2, Memory: 64, Threads: 1}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			y, err := DeriveSecret(tt.password, tt.salt, tt.params)
+			if err != nil {
+				t.Fatalf("error deriving secret: %s", err.Error())
+			}
+			if bytes.Equal(x, y) != tt.same {
+				t.Errorf("expected equal secrets: %v", tt.same)
+			}
+		})
+	}
+}
+
+func TestDeriveSecretInvalidInputs(t *testing.T) {
+	salt, _ := NewSalt()
+	tests := []struct {
+		name     string
+		password string
+		salt     []byte
+		params   KDFParams
+	}{
+		{"empty password", "", salt, testKDFParams},
+		{"empty salt", "password", nil, testKDFParams},
+		{"zero time", "password", salt, KDFParams{Time: 0, Memory: 64, Threads: 1}},
+		{"zero threads", "password", salt, KDFParams{Time: 1, Memory: 64, Threads: 0}},
+		{"not enough memory", "password", salt, KDFParams{Time: 1, Memory: 16, Threads: 4}},
+		{"too much time", "password", salt, KDFParams{Time: MaxKDFTime + 1, Memory: 64, Threads: 1}},
+		{"too many threads", "password", salt, KDFParams{Time: 1, Memory: 1024, Threads: MaxKDFThreads + 1}},
+		{"too much memory", "password", salt, KDFParams{Time: 1, Memory: 0xFFFFFFFF, Threads: 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := DeriveSecret(tt.password, tt.salt, tt.params); err == nil {
+				t.Errorf("expected an error")
+			}
+		})
+	}
+}
+
+// TestDerivedSecretProof checks that secrets derived from non numeric passwords work with every backend.
+func TestDerivedSecretProof(t *testing.T) {
+	salt, _ := NewSalt()
+	x, err := DeriveSecret("pässwörd 🔑", salt, testKDFParams)
+	if err != nil {
+		t.Fatalf("error deriving secret: %s", err.Error())
+	}
+	for _, name := range Protocols() {
+		t.Run(name, func(t *testing.T) {
+			p, _ := GetProtocol(name)
+			pr := honestProof(t, p, x)
+			if !p.Verify(pr.y1, pr.y2, pr.r1, pr.r2, pr.s, pr.c) {
+				t.Fatalf("unable to verify")
+			}
+		})
+	}
+}
@@ -0,0 +1,238 @@
+package zkp
+
+import (
+	"crypto/elliptic"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+)
+
+// P256 is the name the NIST P-256 backend is registered under.
+const P256 = "p256"
+
+func init() {
+	RegisterProtocol(newNIST())
+}
+
+// NIST implements Protocol over the NIST P-256 curve, whose group has prime order n and cofactor 1.
+// G is the standard base point and H is derived by hashing a public seed to the curve,
+// so nobody knows the discrete logarithm of H to base G.
+// Points are encoded in their 33 bytes SEC 1 compressed form and scalars as 32 bytes big-endian integers.
+type NIST struct {
+	curve  elliptic.Curve
+	Hx, Hy *big.Int
+}
+
+// newNIST builds the P-256 backend with its fixed generators.
+func newNIST() *NIST {
+	curve := elliptic.P256()
+	hx, hy := hashToNISTPoint(curve, "zkp-api/chaum-pedersen/"+P256+"/h")
+	return &NIST{
+		curve: curve,
+		Hx:    hx,
+		Hy:    hy,
+	}
+}
+
+// hashToNISTPoint maps a public seed to a point of the curve using try-and-increment:
+// SHA-256(seed || counter) is used as the x coordinate of a compressed point with even y
+// until it decodes to a point of the curve. Since the cofactor is 1 every point generates the group.
+func hashToNISTPoint(curve elliptic.Curve, seed string) (*big.Int, *big.Int) {
+	for ctr := uint32(0); ; ctr++ {
+		d := sha256.Sum256(binary.BigEndian.AppendUint32([]byte(seed), ctr))
+		x, y := elliptic.UnmarshalCompressed(curve, append([]byte{0x02}, d[:]...))
+		if x != nil {
+			return x, y
+		}
+	}
+}
+
+// Name returns the name the backend is registered under.
+func (n *NIST) Name() string {
+	return P256
+}
+
+// Parameters returns the compressed encodings of G and H.
+func (n *NIST) Parameters() []byte {
+	params := elliptic.MarshalCompressed(n.curve, n.curve.Params().Gx, n.curve.Params().Gy)
+	return append(params, elliptic.MarshalCompressed(n.curve, n.Hx, n.Hy)...)
+}
+
+// encodePoint serializes a point in its compressed form.
+// Returns an error for the point at infinity, which crypto/elliptic represents as (0, 0).
+func (n *NIST) encodePoint(x, y *big.Int) ([]byte, error) {
+	if x.Sign() == 0 && y.Sign() == 0 {
+		return nil, fmt.Errorf("point at infinity")
+	}
+	return elliptic.MarshalCompressed(n.curve, x, y), nil
+}
+
+// decodePoint deserializes a compressed point, UnmarshalCompressed checks that x is a canonical
+// field element and that the point is on the curve. Returns an error otherwise.
+func (n *NIST) decodePoint(b []byte) (*big.Int, *big.Int, error) {
+	x, y := elliptic.UnmarshalCompressed(n.curve, b)
+	if x == nil {
+		return nil, nil, fmt.Errorf("invalid compressed point")
+	}
+	return x, y, nil
+}
+
+// encodeScalar serializes a scalar as a 32 bytes big-endian integer.
+func (n *NIST) encodeScalar(s *big.Int) []byte {
+	return s.FillBytes(make([]byte, 32))
+}
+
+// decodeScalar deserializes a 32 bytes big-endian integer and checks that it is lower than n.
+func (n *NIST) decodeScalar(b []byte) (*big.Int, error) {
+	if len(b) != 32 {
+		return nil, fmt.Errorf("scalar must be 32 bytes long")
+	}
+	s := new(big.Int).SetBytes(b)
+	if s.Cmp(n.curve.Params().N) >= 0 {
+		return nil, fmt.Errorf("non canonical scalar encoding")
+	}
+	return s, nil
+}
+
+// elementRoundTrip decodes and encodes back a point, see codec.
+func (n *NIST) elementRoundTrip(b []byte) ([]byte, error) {
+	x, y, err := n.decodePoint(b)
+	if err != nil {
+		return nil, err
+	}
+	return n.encodePoint(x, y)
+}
+
+// scalarRoundTrip decodes and encodes back a scalar, see codec.
+func (n *NIST) scalarRoundTrip(b []byte) ([]byte, error) {
+	s, err := n.decodeScalar(b)
+	if err != nil {
+		return nil, err
+	}
+	return n.encodeScalar(s), nil
+}
+
+// secretScalar maps a secret to a scalar by reducing its SHA-256 hash modulo n.
+func (n *NIST) secretScalar(secret []byte) *big.Int {
+	scal := sha256.Sum256(secret)
+	x := new(big.Int).SetBytes(scal[:])
+	return x.Mod(x, n.curve.Params().N)
+}
+
+// GeneratePublicCommitments generates the public commitments xG and xH for a given secret value.
+func (n *NIST) GeneratePublicCommitments(secret []byte) (y1, y2 []byte, err error) {
+	x := n.secretScalar(secret)
+	if x.Sign() == 0 {
+		return nil, nil, fmt.Errorf("secret maps to the zero scalar")
+	}
+	xb := n.encodeScalar(x)
+	if y1, err = n.encodePoint(n.curve.ScalarBaseMult(xb)); err != nil {
+		return nil, nil, err
+	}
+	if y2, err = n.encodePoint(n.curve.ScalarMult(n.Hx, n.Hy, xb)); err != nil {
+		return nil, nil, err
+	}
+	return y1, y2, nil
+}
+
+// ProverCommitment generates a Chaum-Pedersen proof commitment kG and kH for a random scalar k.
+func (n *NIST) ProverCommitment() (r1, r2, nonce []byte, err error) {
+	k, err := generateNonce(n.curve.Params().N) // nonce in [1, n)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("error generating nonce: %s", err.Error())
+	}
+	nonce = n.encodeScalar(k)
+	if r1, err = n.encodePoint(n.curve.ScalarBaseMult(nonce)); err != nil {
+		return nil, nil, nil, err
+	}
+	if r2, err = n.encodePoint(n.curve.ScalarMult(n.Hx, n.Hy, nonce)); err != nil {
+		return nil, nil, nil, err
+	}
+	return r1, r2, nonce, nil
+}
+
+// GenerateChallenge derives the challenge scalar from the prover's commitments kG and kH.
+func (n *NIST) GenerateChallenge(r1, r2 []byte) ([]byte, error) {
+	return n.HashChallenge(append(append([]byte{}, r1...), r2...))
+}
+
+// HashChallenge derives a challenge scalar by hashing msg, the hash is reduced modulo n.
+func (n *NIST) HashChallenge(msg []byte) ([]byte, error) {
+	hash := sha256.Sum256(msg)
+	c := new(big.Int).SetBytes(hash[:])
+	c.Mod(c, n.curve.Params().N)
+	if c.Sign() == 0 {
+		return nil, fmt.Errorf("challenge cannot be zero")
+	}
+	return n.encodeScalar(c), nil
+}
+
+// RandomChallenge samples a challenge scalar uniformly from [1, n) for the interactive protocol.
+func (n *NIST) RandomChallenge() ([]byte, error) {
+	c, err := generateNonce(n.curve.Params().N)
+	if err != nil {
+		return nil, fmt.Errorf("error generating challenge: %s", err.Error())
+	}
+	return n.encodeScalar(c), nil
+}
+
+// SolveChallenge computes the response s = k - cx to the challenge c.
+func (n *NIST) SolveChallenge(secret, nonce, c []byte) ([]byte, error) {
+	k, err := n.decodeScalar(nonce)
+	if err != nil {
+		return nil, fmt.Errorf("invalid nonce: %s", err.Error())
+	}
+	cS, err := n.decodeScalar(c)
+	if err != nil {
+		return nil, fmt.Errorf("invalid challenge: %s", err.Error())
+	}
+	x := n.secretScalar(secret)
+
+	s := new(big.Int).Sub(k, new(big.Int).Mul(cS, x))
+	s.Mod(s, n.curve.Params().N)
+	return n.encodeScalar(s), nil
+}
+
+// Verify checks that kG == sG + c(xG) and kH == sH + c(xH), any invalid point or scalar encoding fails the verification.
+func (n *NIST) Verify(y1, y2, r1, r2, s, c []byte) bool {
+	xGx, xGy, err := n.decodePoint(y1)
+	if err != nil {
+		return false
+	}
+	xHx, xHy, err := n.decodePoint(y2)
+	if err != nil {
+		return false
+	}
+	if _, _, err = n.decodePoint(r1); err != nil {
+		return false
+	}
+	if _, _, err = n.decodePoint(r2); err != nil {
+		return false
+	}
+	if _, err = n.decodeScalar(s); err != nil {
+		return false
+	}
+	cS, err := n.decodeScalar(c)
+	if err != nil || cS.Sign() == 0 {
+		return false
+	}
+
+	// Compute sG + c(xG)
+	sGx, sGy := n.curve.ScalarBaseMult(s)
+	cxGx, cxGy := n.curve.ScalarMult(xGx, xGy, c)
+	a, err := n.encodePoint(n.curve.Add(sGx, sGy, cxGx, cxGy))
+	if err != nil {
+		return false
+	}
+	// Compute sH + c(xH)
+	sHx, sHy := n.curve.ScalarMult(n.Hx, n.Hy, s)
+	cxHx, cxHy := n.curve.ScalarMult(xHx, xHy, c)
+	b, err := n.encodePoint(n.curve.Add(sHx, sHy, cxHx, cxHy))
+	if err != nil {
+		return false
+	}
+
+	// Compare the encodings, both are canonical so equal encodings mean equal points
+	return string(a) == string(r1) && string(b) == string(r2)
+}
@@ -0,0 +1,81 @@
+package zkp
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Protocol is a backend of the Chaum–Pedersen protocol: two generators g and h of a prime order group
+// and the operations of the prover and the verifier over them.
+// Every input and output is serialized as bytes using the backend own encoding, so that values can be
+// sent over the wire and stored without the callers knowing which backend produced them.
+type Protocol interface {
+	// Name returns the name the backend is registered under.
+	Name() string
+	// GeneratePublicCommitments computes the public commitments y1 = g^x and y2 = h^x of the secret x.
+	GeneratePublicCommitments(secret []byte) (y1, y2 []byte, err error)
+	// ProverCommitment picks a random nonce k and computes the commitments r1 = g^k and r2 = h^k.
+	ProverCommitment() (r1, r2, nonce []byte, err error)
+	// Parameters returns the canonical encoding of the group and generators g and h, to be bound into transcripts.
+	Parameters() []byte
+	// GenerateChallenge derives the challenge c from the prover commitments r1 and r2 (Fiat–Shamir),
+	// it is only sound in a non-interactive setting since the prover can compute c on its own.
+	GenerateChallenge(r1, r2 []byte) ([]byte, error)
+	// HashChallenge derives a non zero challenge c by hashing msg into a scalar, see Transcript.
+	HashChallenge(msg []byte) ([]byte, error)
+	// RandomChallenge samples a non zero challenge c uniformly at random for the interactive protocol.
+	RandomChallenge() ([]byte, error)
+	// SolveChallenge computes the answer s = k - c*x to the challenge c.
+	SolveChallenge(secret, nonce, c []byte) ([]byte, error)
+	// Verify checks that r1 = g^s * y1^c and r2 = h^s * y2^c.
+	Verify(y1, y2, r1, r2, s, c []byte) bool
+}
+
+// codec is implemented by every backend to expose its wire encoding: decoding validates an element
+// or a scalar, rejecting the identity, small order elements and non canonical encodings, and encoding
+// the result back gives the canonical form. The conformance tests rely on it to check every backend.
+type codec interface {
+	elementRoundTrip(b []byte) ([]byte, error)
+	scalarRoundTrip(b []byte) ([]byte, error)
+}
+
+var (
+	protocolsMu sync.RWMutex
+	protocols   = make(map[string]Protocol)
+)
+
+// RegisterProtocol makes a backend available under its name.
+// It panics if a backend with the same name is already registered, since that is a programming error.
+func RegisterProtocol(p Protocol) {
+	protocolsMu.Lock()
+	defer protocolsMu.Unlock()
+	if _, dup := protocols[p.Name()]; dup {
+		panic(fmt.Sprintf("zkp: protocol %s registered twice", p.Name()))
+	}
+	protocols[p.Name()] = p
+}
+
+// GetProtocol returns the backend registered under the given name.
+// Returns an error if there is no such backend.
+func GetProtocol(name string) (Protocol, error) {
+	protocolsMu.RLock()
+	defer protocolsMu.RUnlock()
+	p, ok := protocols[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown protocol '%s'", name)
+	}
+	return p, nil
+}
+
+// Protocols returns the sorted names of all the registered backends.
+func Protocols() []string {
+	protocolsMu.RLock()
+	defer protocolsMu.RUnlock()
+	names := make([]string, 0, len(protocols))
+	for name := range protocols {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,97 @@
+package zkp
+
+import (
+	"math/big"
+	"testing"
+)
+
+var (
+	secret = new(big.Int)
+)
+
+func TestProtocolRegistry(t *testing.T) {
+	secret.SetString("929283747463652525354647586969473", 10)
+
+	for _, name := range Protocols() {
+		t.Run(name, func(t *testing.T) {
+			p, err := GetProtocol(name)
+			if err != nil {
+				t.Fatalf("error getting protocol: %s", err.Error())
+			}
+			if p.Name() != name {
+				t.Fatalf("protocol registered as %s reports name %s", name, p.Name())
+			}
+
+			y1, y2, err := p.GeneratePublicCommitments(secret.Bytes())
+			if err != nil {
+				t.Fatalf("error generating public commitments: %s", err.Error())
+			}
+			r1, r2, k, err := p.ProverCommitment()
+			if err != nil {
+				t.Fatalf("error generating prover commitments: %s", err.Error())
+			}
+			c, err := p.GenerateChallenge(r1, r2)
+			if err != nil {
+				t.Fatalf("error generating challenge: %s", err.Error())
+			}
+			s, err := p.SolveChallenge(secret.Bytes(), k, c)
+			if err != nil {
+				t.Fatalf("error solving challenge: %s", err.Error())
+			}
+			if valid := p.Verify(y1, y2, r1, r2, s, c); !valid {
+				t.Fatalf("unable to verify")
+			}
+		})
+	}
+
+	if _, err := GetProtocol("p23"); err == nil {
+		t.Fatalf("unknown protocol should fail")
+	}
+}
+
+func TestCrossBackendProofs(t *testing.T) {
+	secret.SetString("929283747463652525354647586969473", 10)
+
+	// a proof produced by one backend must never verify under another one
+	for _, name := range Protocols() {
+		prover, _ := GetProtocol(name)
+		y1, y2, err := prover.GeneratePublicCommitments(secret.Bytes())
+		if err != nil {
+			t.Fatalf("error generating public commitments: %s", err.Error())
+		}
+		r1, r2, k, err := prover.ProverCommitment()
+		if err != nil {
+			t.Fatalf("error generating prover commitments: %s", err.Error())
+		}
+		c, err := prover.GenerateChallenge(r1, r2)
+		if err != nil {
+			t.Fatalf("error generating challenge: %s", err.Error())
+		}
+		s, err := prover.SolveChallenge(secret.Bytes(), k, c)
+		if err != nil {
+			t.Fatalf("error solving challenge: %s", err.Error())
+		}
+
+		for _, other := range Protocols() {
+			if other == name {
+				continue
+			}
+			t.Run(name+" verified by "+other, func(t *testing.T) {
+				verifier, _ := GetProtocol(other)
+				if valid := verifier.Verify(y1, y2, r1, r2, s, c); valid {
+					t.Fatalf("proof should not verify on another backend")
+				}
+			})
+		}
+	}
+}
+
+func TestRegisterProtocolTwice(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("registering a protocol twice should panic")
+		}
+	}()
+	grp, _ := GetGroup(DefaultGroup)
+	RegisterProtocol(grp)
+}
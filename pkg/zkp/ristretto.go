@@ -0,0 +1,198 @@
+package zkp
+
+import (
+	"crypto/rand"
+	"crypto/sha512"
+	"fmt"
+
+	"github.com/gtank/ristretto255"
+)
+
+// Ristretto255 is the name the ristretto255 backend is registered under.
+const Ristretto255 = "ristretto255"
+
+func init() {
+	RegisterProtocol(newRistretto())
+}
+
+// Ristretto implements Protocol over the ristretto255 prime order group built on top of Curve25519.
+// Unlike Edwards25519 there are no small order elements, every valid encoding is an element of the
+// prime order group. G is the standard generator and H is obtained with the ristretto255 hash-to-group
+// map applied to SHA-512 of a public seed, so nobody knows the discrete logarithm of H to base G.
+// Elements and scalars are encoded in 32 bytes.
+type Ristretto struct {
+	G, H *ristretto255.Element
+}
+
+// newRistretto builds the ristretto255 backend with its fixed generators.
+func newRistretto() *Ristretto {
+	seed := sha512.Sum512([]byte("zkp-api/chaum-pedersen/" + Ristretto255 + "/h"))
+	return &Ristretto{
+		G: ristretto255.NewElement().Base(),
+		H: ristretto255.NewElement().FromUniformBytes(seed[:]),
+	}
+}
+
+// Name returns the name the backend is registered under.
+func (rt *Ristretto) Name() string {
+	return Ristretto255
+}
+
+// Parameters returns the encodings of G and H.
+func (rt *Ristretto) Parameters() []byte {
+	return rt.H.Encode(rt.G.Encode(nil))
+}
+
+// decodeElement deserializes a canonical element encoding, rejecting the identity.
+func (rt *Ristretto) decodeElement(b []byte) (*ristretto255.Element, error) {
+	e := ristretto255.NewElement()
+	if err := e.Decode(b); err != nil {
+		return nil, err
+	}
+	if e.Equal(ristretto255.NewElement().Zero()) == 1 {
+		return nil, fmt.Errorf("identity element")
+	}
+	return e, nil
+}
+
+// decodeScalar deserializes a canonical 32 bytes little-endian scalar.
+func (rt *Ristretto) decodeScalar(b []byte) (*ristretto255.Scalar, error) {
+	if len(b) != 32 {
+		return nil, fmt.Errorf("scalar must be 32 bytes long")
+	}
+	s := ristretto255.NewScalar()
+	if err := s.Decode(b); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// elementRoundTrip decodes and encodes back an element, see codec.
+func (rt *Ristretto) elementRoundTrip(b []byte) ([]byte, error) {
+	e, err := rt.decodeElement(b)
+	if err != nil {
+		return nil, err
+	}
+	return e.Encode(nil), nil
+}
+
+// scalarRoundTrip decodes and encodes back a scalar, see codec.
+func (rt *Ristretto) scalarRoundTrip(b []byte) ([]byte, error) {
+	s, err := rt.decodeScalar(b)
+	if err != nil {
+		return nil, err
+	}
+	return s.Encode(nil), nil
+}
+
+// randomScalar picks a non zero scalar uniformly at random by reducing 64 random bytes modulo the group order.
+func (rt *Ristretto) randomScalar() (*ristretto255.Scalar, error) {
+	s := ristretto255.NewScalar()
+	for s.Equal(ristretto255.NewScalar().Zero()) == 1 {
+		var b [64]byte
+		if _, err := rand.Read(b[:]); err != nil {
+			return nil, err
+		}
+		s.FromUniformBytes(b[:])
+	}
+	return s, nil
+}
+
+// secretScalar maps a secret to a scalar by reducing its SHA-512 hash modulo the group order.
+func (rt *Ristretto) secretScalar(secret []byte) *ristretto255.Scalar {
+	d := sha512.Sum512(secret)
+	return ristretto255.NewScalar().FromUniformBytes(d[:])
+}
+
+// GeneratePublicCommitments generates the public commitments xG and xH for a given secret value.
+func (rt *Ristretto) GeneratePublicCommitments(secret []byte) (y1, y2 []byte, err error) {
+	x := rt.secretScalar(secret)
+	if x.Equal(ristretto255.NewScalar().Zero()) == 1 {
+		return nil, nil, fmt.Errorf("secret maps to the zero scalar")
+	}
+	y1 = ristretto255.NewElement().ScalarMult(x, rt.G).Encode(nil)
+	y2 = ristretto255.NewElement().ScalarMult(x, rt.H).Encode(nil)
+	return y1, y2, nil
+}
+
+// ProverCommitment generates a Chaum-Pedersen proof commitment kG and kH for a random scalar k.
+func (rt *Ristretto) ProverCommitment() (r1, r2, nonce []byte, err error) {
+	k, err := rt.randomScalar()
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("error generating nonce: %s", err.Error())
+	}
+	r1 = ristretto255.NewElement().ScalarMult(k, rt.G).Encode(nil)
+	r2 = ristretto255.NewElement().ScalarMult(k, rt.H).Encode(nil)
+	return r1, r2, k.Encode(nil), nil
+}
+
+// GenerateChallenge derives the challenge scalar from the prover's commitments kG and kH.
+func (rt *Ristretto) GenerateChallenge(r1, r2 []byte) ([]byte, error) {
+	return rt.HashChallenge(append(append([]byte{}, r1...), r2...))
+}
+
+// HashChallenge derives a challenge scalar by reducing the SHA-512 hash of msg modulo the group order.
+func (rt *Ristretto) HashChallenge(msg []byte) ([]byte, error) {
+	hash := sha512.Sum512(msg)
+	c := ristretto255.NewScalar().FromUniformBytes(hash[:])
+	if c.Equal(ristretto255.NewScalar().Zero()) == 1 {
+		return nil, fmt.Errorf("challenge cannot be zero")
+	}
+	return c.Encode(nil), nil
+}
+
+// RandomChallenge samples a non zero challenge scalar uniformly at random for the interactive protocol.
+func (rt *Ristretto) RandomChallenge() ([]byte, error) {
+	c, err := rt.randomScalar()
+	if err != nil {
+		return nil, fmt.Errorf("error generating challenge: %s", err.Error())
+	}
+	return c.Encode(nil), nil
+}
+
+// SolveChallenge computes the response s = k - cx to the challenge c.
+func (rt *Ristretto) SolveChallenge(secret, nonce, c []byte) ([]byte, error) {
+	k, err := rt.decodeScalar(nonce)
+	if err != nil {
+		return nil, fmt.Errorf("invalid nonce: %s", err.Error())
+	}
+	cS, err := rt.decodeScalar(c)
+	if err != nil {
+		return nil, fmt.Errorf("invalid challenge: %s", err.Error())
+	}
+	x := rt.secretScalar(secret)
+
+	cx := ristretto255.NewScalar().Multiply(cS, x)
+	return ristretto255.NewScalar().Subtract(k, cx).Encode(nil), nil
+}
+
+// Verify checks that kG == sG + c(xG) and kH == sH + c(xH), any invalid element or scalar encoding fails the verification.
+func (rt *Ristretto) Verify(y1, y2, r1, r2, s, c []byte) bool {
+	var err error
+	elements := make([]*ristretto255.Element, 4)
+	for i, b := range [][]byte{y1, y2, r1, r2} {
+		if elements[i], err = rt.decodeElement(b); err != nil {
+			return false
+		}
+	}
+	xG, xH, kG, kH := elements[0], elements[1], elements[2], elements[3]
+	sS, err := rt.decodeScalar(s)
+	if err != nil {
+		return false
+	}
+	cS, err := rt.decodeScalar(c)
+	if err != nil || cS.Equal(ristretto255.NewScalar().Zero()) == 1 {
+		return false
+	}
+
+	a := ristretto255.NewElement().Add(
+		ristretto255.NewElement().ScalarMult(sS, rt.G),
+		ristretto255.NewElement().ScalarMult(cS, xG),
+	)
+	b := ristretto255.NewElement().Add(
+		ristretto255.NewElement().ScalarMult(sS, rt.H),
+		ristretto255.NewElement().ScalarMult(cS, xH),
+	)
+
+	return kG.Equal(a) == 1 && kH.Equal(b) == 1
+}
@@ -0,0 +1,288 @@
+package zkp
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+)
+
+// Secp256k1 is the name the secp256k1 backend is registered under.
+const Secp256k1 = "secp256k1"
+
+func init() {
+	RegisterProtocol(newKoblitz())
+}
+
+// Koblitz implements Protocol over the secp256k1 curve, whose group has prime order n and cofactor 1.
+// G is the standard base point and H is derived by hashing a public seed to the curve,
+// so nobody knows the discrete logarithm of H to base G.
+// Points are encoded in their 33 bytes SEC 1 compressed form, the same one secp256k1 public keys use,
+// and scalars as 32 bytes big-endian integers.
+type Koblitz struct {
+	G, H btcec.JacobianPoint
+}
+
+// newKoblitz builds the secp256k1 backend with its fixed generators.
+func newKoblitz() *Koblitz {
+	k := &Koblitz{
+		H: hashToKoblitzPoint("zkp-api/chaum-pedersen/" + Secp256k1 + "/h"),
+	}
+	var one btcec.ModNScalar
+	one.SetInt(1)
+	btcec.ScalarBaseMultNonConst(&one, &k.G)
+	k.G.ToAffine()
+	return k
+}
+
+// hashToKoblitzPoint maps a public seed to a point of the curve using try-and-increment:
+// SHA-256(seed || counter) is used as the x coordinate of a compressed point with even y
+// until it decodes to a point of the curve. Since the cofactor is 1 every point generates the group.
+func hashToKoblitzPoint(seed string) btcec.JacobianPoint {
+	for ctr := uint32(0); ; ctr++ {
+		d := sha256.Sum256(binary.BigEndian.AppendUint32([]byte(seed), ctr))
+		pk, err := btcec.ParsePubKey(append([]byte{0x02}, d[:]...))
+		if err != nil {
+			continue // not the x coordinate of a point, try the next counter
+		}
+		var p btcec.JacobianPoint
+		pk.AsJacobian(&p)
+		return p
+	}
+}
+
+// Name returns the name the backend is registered under.
+func (k *Koblitz) Name() string {
+	return Secp256k1
+}
+
+// Parameters returns the compressed encodings of G and H.
+func (k *Koblitz) Parameters() []byte {
+	g, h := k.G, k.H
+	gb, _ := k.encodePoint(&g)
+	hb, _ := k.encodePoint(&h)
+	return append(gb, hb...)
+}
+
+// isInfinity reports whether p is the point at infinity, the identity of the group.
+func isInfinity(p *btcec.JacobianPoint) bool {
+	return (p.X.IsZero() && p.Y.IsZero()) || p.Z.IsZero()
+}
+
+// encodePoint serializes a point in its compressed form.
+// Returns an error for the point at infinity, which has no compressed encoding.
+func (k *Koblitz) encodePoint(p *btcec.JacobianPoint) ([]byte, error) {
+	if isInfinity(p) {
+		return nil, fmt.Errorf("point at infinity")
+	}
+	p.ToAffine()
+	return btcec.NewPublicKey(&p.X, &p.Y).SerializeCompressed(), nil
+}
+
+// decodePoint deserializes a compressed point, checking that x is a canonical field element
+// and that the point is on the curve. Returns an error otherwise.
+func (k *Koblitz) decodePoint(b []byte) (*btcec.JacobianPoint, error) {
+	if len(b) != btcec.PubKeyBytesLenCompressed || !btcec.IsCompressedPubKey(b) {
+		return nil, fmt.Errorf("point must be in compressed form")
+	}
+	pk, err := btcec.ParsePubKey(b)
+	if err != nil {
+		return nil, err
+	}
+	var p btcec.JacobianPoint
+	pk.AsJacobian(&p)
+	return &p, nil
+}
+
+// encodeScalar serializes a scalar as a 32 bytes big-endian integer.
+func (k *Koblitz) encodeScalar(s *btcec.ModNScalar) []byte {
+	b := s.Bytes()
+	return b[:]
+}
+
+// decodeScalar deserializes a 32 bytes big-endian integer and checks that it is lower than n.
+// Returns an error otherwise.
+func (k *Koblitz) decodeScalar(b []byte) (*btcec.ModNScalar, error) {
+	if len(b) != 32 {
+		return nil, fmt.Errorf("scalar must be 32 bytes long")
+	}
+	var s btcec.ModNScalar
+	if overflow := s.SetByteSlice(b); overflow {
+		return nil, fmt.Errorf("non canonical scalar encoding")
+	}
+	return &s, nil
+}
+
+// elementRoundTrip decodes and encodes back a point, see codec.
+func (k *Koblitz) elementRoundTrip(b []byte) ([]byte, error) {
+	p, err := k.decodePoint(b)
+	if err != nil {
+		return nil, err
+	}
+	return k.encodePoint(p)
+}
+
+// scalarRoundTrip decodes and encodes back a scalar, see codec.
+func (k *Koblitz) scalarRoundTrip(b []byte) ([]byte, error) {
+	s, err := k.decodeScalar(b)
+	if err != nil {
+		return nil, err
+	}
+	return k.encodeScalar(s), nil
+}
+
+// randomScalar picks a non zero scalar uniformly at random, rejecting values over the group order.
+func (k *Koblitz) randomScalar() (*btcec.ModNScalar, error) {
+	var s btcec.ModNScalar
+	for {
+		var b [32]byte
+		if _, err := rand.Read(b[:]); err != nil {
+			return nil, err
+		}
+		if overflow := s.SetBytes(&b); overflow == 0 && !s.IsZero() {
+			return &s, nil
+		}
+	}
+}
+
+// secretScalar maps a secret to a scalar by hashing it, the hash is reduced modulo n.
+// The resulting scalar is the private key whose public key is y1.
+func (k *Koblitz) secretScalar(secret []byte) *btcec.ModNScalar {
+	scal := sha256.Sum256(secret)
+	var x btcec.ModNScalar
+	x.SetByteSlice(scal[:])
+	return &x
+}
+
+// GeneratePublicCommitments generates the public commitments xG and xH for a given secret value.
+func (k *Koblitz) GeneratePublicCommitments(secret []byte) (y1, y2 []byte, err error) {
+	x := k.secretScalar(secret)
+	if x.IsZero() {
+		return nil, nil, fmt.Errorf("secret maps to the zero scalar")
+	}
+	var xG, xH btcec.JacobianPoint
+	btcec.ScalarBaseMultNonConst(x, &xG)
+	btcec.ScalarMultNonConst(x, &k.H, &xH)
+
+	if y1, err = k.encodePoint(&xG); err != nil {
+		return nil, nil, err
+	}
+	if y2, err = k.encodePoint(&xH); err != nil {
+		return nil, nil, err
+	}
+	return y1, y2, nil
+}
+
+// ProverCommitment generates a Chaum-Pedersen proof commitment kG and kH for a random scalar k.
+func (k *Koblitz) ProverCommitment() (r1, r2, nonce []byte, err error) {
+	kS, err := k.randomScalar()
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("error generating nonce: %s", err.Error())
+	}
+	var kG, kH btcec.JacobianPoint
+	btcec.ScalarBaseMultNonConst(kS, &kG)
+	btcec.ScalarMultNonConst(kS, &k.H, &kH)
+
+	if r1, err = k.encodePoint(&kG); err != nil {
+		return nil, nil, nil, err
+	}
+	if r2, err = k.encodePoint(&kH); err != nil {
+		return nil, nil, nil, err
+	}
+	return r1, r2, k.encodeScalar(kS), nil
+}
+
+// GenerateChallenge derives the challenge scalar from the prover's commitments kG and kH.
+func (k *Koblitz) GenerateChallenge(r1, r2 []byte) ([]byte, error) {
+	return k.HashChallenge(append(append([]byte{}, r1...), r2...))
+}
+
+// HashChallenge derives a challenge scalar by hashing msg, the hash is reduced modulo n.
+func (k *Koblitz) HashChallenge(msg []byte) ([]byte, error) {
+	hash := sha256.Sum256(msg)
+	// SetByteSlice reduces the hash modulo n
+	var c btcec.ModNScalar
+	c.SetByteSlice(hash[:])
+	if c.IsZero() {
+		return nil, fmt.Errorf("challenge cannot be zero")
+	}
+	return k.encodeScalar(&c), nil
+}
+
+// RandomChallenge samples a non zero challenge scalar uniformly at random for the interactive protocol.
+func (k *Koblitz) RandomChallenge() ([]byte, error) {
+	c, err := k.randomScalar()
+	if err != nil {
+		return nil, fmt.Errorf("error generating challenge: %s", err.Error())
+	}
+	return k.encodeScalar(c), nil
+}
+
+// SolveChallenge computes the response s = k - cx to the challenge c.
+func (k *Koblitz) SolveChallenge(secret, nonce, c []byte) ([]byte, error) {
+	kS, err := k.decodeScalar(nonce)
+	if err != nil {
+		return nil, fmt.Errorf("invalid nonce: %s", err.Error())
+	}
+	cS, err := k.decodeScalar(c)
+	if err != nil {
+		return nil, fmt.Errorf("invalid challenge: %s", err.Error())
+	}
+	x := k.secretScalar(secret)
+
+	// Compute the response s = k - cx
+	var s btcec.ModNScalar
+	s.Mul2(cS, x).Negate().Add(kS)
+
+	return k.encodeScalar(&s), nil
+}
+
+// Verify checks that kG == sG + c(xG) and kH == sH + c(xH), any invalid point or scalar encoding fails the verification.
+func (k *Koblitz) Verify(y1, y2, r1, r2, s, c []byte) bool {
+	xG, err := k.decodePoint(y1)
+	if err != nil {
+		return false
+	}
+	xH, err := k.decodePoint(y2)
+	if err != nil {
+		return false
+	}
+	if _, err = k.decodePoint(r1); err != nil {
+		return false
+	}
+	if _, err = k.decodePoint(r2); err != nil {
+		return false
+	}
+	sS, err := k.decodeScalar(s)
+	if err != nil {
+		return false
+	}
+	cS, err := k.decodeScalar(c)
+	if err != nil || cS.IsZero() {
+		return false
+	}
+
+	// Compute sG + c(xG)
+	var sG, cxG, a btcec.JacobianPoint
+	btcec.ScalarBaseMultNonConst(sS, &sG)
+	btcec.ScalarMultNonConst(cS, xG, &cxG)
+	btcec.AddNonConst(&sG, &cxG, &a)
+	// Compute sH + c(xH)
+	var sH, cxH, b btcec.JacobianPoint
+	btcec.ScalarMultNonConst(sS, &k.H, &sH)
+	btcec.ScalarMultNonConst(cS, xH, &cxH)
+	btcec.AddNonConst(&sH, &cxH, &b)
+
+	// Compare the encodings, both are canonical so equal encodings mean equal points
+	aB, err := k.encodePoint(&a)
+	if err != nil {
+		return false
+	}
+	bB, err := k.encodePoint(&b)
+	if err != nil {
+		return false
+	}
+	return string(aB) == string(r1) && string(bB) == string(r2)
+}
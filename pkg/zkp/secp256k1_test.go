@@ -0,0 +1,196 @@
+package zkp
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"math/big"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+)
+
+func TestSecp256k1Flow(t *testing.T) {
+	secret.SetString("929283747463652525354647586969473", 10)
+
+	tests := []struct {
+		name  string
+		input *big.Int
+	}{
+		{
+			name:  "verify - very big.Int set by String ",
+			input: secret,
+		},
+		{
+			name:  "verify - big.Int set by int64",
+			input: new(big.Int).SetInt64(12345),
+		},
+	}
+
+	kc := newKoblitz()
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			xg, xh, err := kc.GeneratePublicCommitments(test.input.Bytes())
+			if err != nil {
+				t.Fatalf("error generating public commitments: %s", err.Error())
+			}
+
+			kg, kh, k, err := kc.ProverCommitment()
+			if err != nil {
+				t.Fatalf("error generating prover commitments: %s", err.Error())
+			}
+
+			c, err := kc.GenerateChallenge(kg, kh)
+			if err != nil {
+				t.Fatalf("error generating challenge: %s", err.Error())
+			}
+
+			s, err := kc.SolveChallenge(test.input.Bytes(), k, c)
+			if err != nil {
+				t.Fatalf("error solving challenge: %s", err.Error())
+			}
+
+			if valid := kc.Verify(xg, xh, kg, kh, s, c); !valid {
+				t.Fatalf("unable to verify")
+			}
+		})
+	}
+}
+
+func TestSecp256k1PublicKeyInterop(t *testing.T) {
+	secret.SetString("929283747463652525354647586969473", 10)
+	kc := newKoblitz()
+
+	y1, _, err := kc.GeneratePublicCommitments(secret.Bytes())
+	if err != nil {
+		t.Fatalf("error generating public commitments: %s", err.Error())
+	}
+
+	// y1 must be the compressed public key of the private key x
+	x := kc.encodeScalar(kc.secretScalar(secret.Bytes()))
+	_, pub := btcec.PrivKeyFromBytes(x)
+	if !bytes.Equal(y1, pub.SerializeCompressed()) {
+		t.Fatalf("y1 is not the compressed public key of x")
+	}
+	if _, err := btcec.ParsePubKey(y1); err != nil {
+		t.Fatalf("y1 is not a valid public key: %s", err.Error())
+	}
+}
+
+func TestSecp256k1Generators(t *testing.T) {
+	kc, other := newKoblitz(), newKoblitz()
+	g, _ := kc.encodePoint(&kc.G)
+	h, _ := kc.encodePoint(&kc.H)
+	otherH, _ := other.encodePoint(&other.H)
+	// h must be the same on every instance, otherwise prover and verifier could not agree
+	if !bytes.Equal(h, otherH) {
+		t.Fatalf("generators must be deterministic")
+	}
+	if bytes.Equal(g, h) {
+		t.Fatalf("g and h must be independent")
+	}
+	gx := btcec.Params().Gx.FillBytes(make([]byte, 32))
+	if !bytes.Equal(g[1:], gx) {
+		t.Fatalf("g must be the standard base point")
+	}
+}
+
+func TestSecp256k1FailProveCommitmentFlow(t *testing.T) {
+	secret.SetString("929283747463652525354647586969473", 10)
+	kc := newKoblitz()
+
+	xg, xh, err := kc.GeneratePublicCommitments(secret.Bytes())
+	if err != nil {
+		t.Fatalf("error generating public commitments: %s", err.Error())
+	}
+
+	// commitments of an unrelated nonce, answer computed with another one
+	kg, kh, _, err := kc.ProverCommitment()
+	if err != nil {
+		t.Fatalf("error generating prover commitments: %s", err.Error())
+	}
+	_, _, k, err := kc.ProverCommitment()
+	if err != nil {
+		t.Fatalf("error generating prover commitments: %s", err.Error())
+	}
+
+	c, err := kc.GenerateChallenge(kg, kh)
+	if err != nil {
+		t.Fatalf("error generating challenge: %s", err.Error())
+	}
+	s, err := kc.SolveChallenge(secret.Bytes(), k, c)
+	if err != nil {
+		t.Fatalf("error solving challenge: %s", err.Error())
+	}
+
+	if valid := kc.Verify(xg, xh, kg, kh, s, c); valid {
+		t.Fatalf("test should fail")
+	}
+}
+
+func TestSecp256k1FailVerificationBySolveChallengeFlow(t *testing.T) {
+	secret.SetString("929283747463652525354647586969473", 10)
+	kc := newKoblitz()
+
+	xg, xh, err := kc.GeneratePublicCommitments(secret.Bytes())
+	if err != nil {
+		t.Fatalf("error generating public commitments: %s", err.Error())
+	}
+	kg, kh, k, err := kc.ProverCommitment()
+	if err != nil {
+		t.Fatalf("error generating prover commitments: %s", err.Error())
+	}
+	c, err := kc.GenerateChallenge(kg, kh)
+	if err != nil {
+		t.Fatalf("error generating challenge: %s", err.Error())
+	}
+
+	// solve the challenge with a secret unrelated to the public commitments
+	s, err := kc.SolveChallenge([]byte("not the secret"), k, c)
+	if err != nil {
+		t.Fatalf("error solving challenge: %s", err.Error())
+	}
+
+	if valid := kc.Verify(xg, xh, kg, kh, s, c); valid {
+		t.Fatalf("test should fail")
+	}
+}
+
+func TestSecp256k1FailVerifyFlow(t *testing.T) {
+	secret.SetString("929283747463652525354647586969473", 10)
+	kc := newKoblitz()
+
+	xg, xh, err := kc.GeneratePublicCommitments(secret.Bytes())
+	if err != nil {
+		t.Fatalf("error generating public commitments: %s", err.Error())
+	}
+	kg, kh, k, err := kc.ProverCommitment()
+	if err != nil {
+		t.Fatalf("error generating prover commitments: %s", err.Error())
+	}
+	c, err := kc.GenerateChallenge(kg, kh)
+	if err != nil {
+		t.Fatalf("error generating challenge: %s", err.Error())
+	}
+	s, err := kc.SolveChallenge(secret.Bytes(), k, c)
+	if err != nil {
+		t.Fatalf("error solving challenge: %s", err.Error())
+	}
+
+	// change params to fail verification step
+	zz := sha256.Sum256([]byte("intentional mismatch"))
+	if valid := kc.Verify(xg, xh, kg, kh, s, zz[:]); valid {
+		t.Fatalf("test should fail")
+	}
+
+	// uncompressed encodings are rejected even if they represent the right point
+	pk, _ := btcec.ParsePubKey(kg)
+	if valid := kc.Verify(xg, xh, pk.SerializeUncompressed(), kh, s, c); valid {
+		t.Fatalf("uncompressed points should be rejected")
+	}
+
+	// n, the group order, is not a canonical scalar
+	n := btcec.Params().N.FillBytes(make([]byte, 32))
+	if valid := kc.Verify(xg, xh, kg, kh, n, c); valid {
+		t.Fatalf("non canonical scalars should be rejected")
+	}
+}
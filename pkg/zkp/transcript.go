@@ -0,0 +1,83 @@
+package zkp
+
+import "encoding/binary"
+
+const (
+	// LoginContext is the context string of the non-interactive login transcripts, it separates their challenges
+	// from the ones of any other protocol or version that hashes the same values.
+	LoginContext = "zkp-api/chaum-pedersen/login/v1"
+	// UpdateContext is the context string of the transcripts of the proofs authorizing a credential rotation,
+	// so that a login proof can never be used to rotate the credentials and the other way around.
+	UpdateContext = "zkp-api/chaum-pedersen/update-commitments/v1"
+	// ChallengeContext is the context string of the transcripts of the challenges the verifier derives, instead of
+	// sampling them, for the pending challenges of the non-interactive mode.
+	ChallengeContext = "zkp-api/chaum-pedersen/challenge/v1"
+)
+
+// Transcript accumulates the values a non-interactive challenge is bound to.
+// Every value is appended along with a label and both are prefixed with their length, so that two different
+// sequences of values can never produce the same transcript.
+type Transcript struct {
+	buf []byte
+}
+
+// NewTranscript starts a transcript for the given context string.
+func NewTranscript(context string) *Transcript {
+	t := &Transcript{}
+	t.Append("context", []byte(context))
+	return t
+}
+
+// Append adds a labelled value to the transcript.
+func (t *Transcript) Append(label string, value []byte) {
+	t.buf = binary.BigEndian.AppendUint32(t.buf, uint32(len(label)))
+	t.buf = append(t.buf, label...)
+	t.buf = binary.BigEndian.AppendUint64(t.buf, uint64(len(value)))
+	t.buf = append(t.buf, value...)
+}
+
+// Bytes returns the serialized transcript.
+func (t *Transcript) Bytes() []byte {
+	return t.buf
+}
+
+// LoginChallenge computes the challenge of the non-interactive login, which both the prover and the verifier derive
+// from a transcript binding the backend and its parameters, the user name, the single use nonce issued by the verifier,
+// the public commitments y1, y2 and the prover commitments r1, r2.
+func LoginChallenge(p Protocol, user string, nonce, y1, y2, r1, r2 []byte) ([]byte, error) {
+	t := proofTranscript(LoginContext, p, user, nonce, y1, y2, r1, r2)
+	return p.HashChallenge(t.Bytes())
+}
+
+// PendingChallenge computes the challenge of a pending challenge in non-interactive mode from a transcript binding the
+// same values as the login transcript, with the auth id of the pending challenge as the single use nonce.
+func PendingChallenge(p Protocol, user, authID string, y1, y2, r1, r2 []byte) ([]byte, error) {
+	t := proofTranscript(ChallengeContext, p, user, []byte(authID), y1, y2, r1, r2)
+	return p.HashChallenge(t.Bytes())
+}
+
+// UpdateChallenge computes the challenge of the proof of knowledge of the current secret that authorizes replacing
+// the public commitments y1, y2 of a user. On top of the values of the login transcript it binds the new salt,
+// KDF parameters and public commitments, so that the proof cannot authorize any other credentials.
+func UpdateChallenge(p Protocol, user string, nonce, y1, y2, r1, r2, salt []byte, kdf KDFParams, newY1, newY2 []byte) ([]byte, error) {
+	t := proofTranscript(UpdateContext, p, user, nonce, y1, y2, r1, r2)
+	t.Append("new salt", salt)
+	t.Append("new kdf", kdf.bytes())
+	t.Append("new y1", newY1)
+	t.Append("new y2", newY2)
+	return p.HashChallenge(t.Bytes())
+}
+
+// proofTranscript starts a transcript for the given context with the values every non-interactive proof is bound to.
+func proofTranscript(context string, p Protocol, user string, nonce, y1, y2, r1, r2 []byte) *Transcript {
+	t := NewTranscript(context)
+	t.Append("protocol", []byte(p.Name()))
+	t.Append("parameters", p.Parameters())
+	t.Append("user", []byte(user))
+	t.Append("nonce", nonce)
+	t.Append("y1", y1)
+	t.Append("y2", y2)
+	t.Append("r1", r1)
+	t.Append("r2", r2)
+	return t
+}
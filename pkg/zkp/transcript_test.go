@@ -0,0 +1,133 @@
+package zkp
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestTranscriptIsUnambiguous(t *testing.T) {
+	// the same bytes split differently between values must give different transcripts
+	a := NewTranscript("ctx")
+	a.Append("user", []byte("jo"))
+	a.Append("nonce", []byte("nabc"))
+	b := NewTranscript("ctx")
+	b.Append("user", []byte("jon"))
+	b.Append("nonce", []byte("abc"))
+	if bytes.Equal(a.Bytes(), b.Bytes()) {
+		t.Fatalf("transcripts of different values are equal")
+	}
+
+	c := NewTranscript("other ctx")
+	c.Append("user", []byte("jo"))
+	c.Append("nonce", []byte("nabc"))
+	if bytes.Equal(a.Bytes(), c.Bytes()) {
+		t.Fatalf("transcripts of different contexts are equal")
+	}
+}
+
+func TestLoginChallenge(t *testing.T) {
+	nonce := []byte("verifier nonce")
+	for _, name := range Protocols() {
+		t.Run(name, func(t *testing.T) {
+			p, _ := GetProtocol(name)
+			pr := honestProof(t, p, []byte("secret"))
+			other := honestProof(t, p, []byte("another secret"))
+
+			c, err := LoginChallenge(p, "jon", nonce, pr.y1, pr.y2, pr.r1, pr.r2)
+			if err != nil {
+				t.Fatalf("error computing challenge: %s", err.Error())
+			}
+			s, err := p.SolveChallenge([]byte("secret"), pr.nonce, c)
+			if err != nil {
+				t.Fatalf("error solving challenge: %s", err.Error())
+			}
+			if !p.Verify(pr.y1, pr.y2, pr.r1, pr.r2, s, c) {
+				t.Fatalf("unable to verify")
+			}
+			if fs, _ := p.GenerateChallenge(pr.r1, pr.r2); bytes.Equal(fs, c) {
+				t.Fatalf("login challenge is not bound to the transcript")
+			}
+
+			// changing any value of the transcript changes the challenge
+			tests := []struct {
+				name                  string
+				user                  string
+				nonce, y1, y2, r1, r2 []byte
+			}{
+				{"user", "jon2", nonce, pr.y1, pr.y2, pr.r1, pr.r2},
+				{"nonce", "jon", []byte("another nonce"), pr.y1, pr.y2, pr.r1, pr.r2},
+				{"y1", "jon", nonce, other.y1, pr.y2, pr.r1, pr.r2},
+				{"y2", "jon", nonce, pr.y1, other.y2, pr.r1, pr.r2},
+				{"r1", "jon", nonce, pr.y1, pr.y2, other.r1, pr.r2},
+				{"r2", "jon", nonce, pr.y1, pr.y2, pr.r1, other.r2},
+			}
+			for _, tt := range tests {
+				oc, err := LoginChallenge(p, tt.user, tt.nonce, tt.y1, tt.y2, tt.r1, tt.r2)
+				if err != nil {
+					t.Fatalf("error computing challenge: %s", err.Error())
+				}
+				if bytes.Equal(oc, c) {
+					t.Errorf("%s is not bound to the challenge", tt.name)
+				}
+			}
+		})
+	}
+}
+
+func TestPendingChallenge(t *testing.T) {
+	p, _ := GetProtocol(Ristretto255)
+	pr := honestProof(t, p, []byte("secret"))
+
+	c, err := PendingChallenge(p, "jon", "auth id", pr.y1, pr.y2, pr.r1, pr.r2)
+	if err != nil {
+		t.Fatalf("error computing challenge: %s", err.Error())
+	}
+	if fs, _ := p.GenerateChallenge(pr.r1, pr.r2); bytes.Equal(fs, c) {
+		t.Fatalf("pending challenge is not bound to the transcript")
+	}
+	if lc, _ := LoginChallenge(p, "jon", []byte("auth id"), pr.y1, pr.y2, pr.r1, pr.r2); bytes.Equal(lc, c) {
+		t.Fatalf("pending challenge equals the login challenge of the same values")
+	}
+	if oc, _ := PendingChallenge(p, "jon", "other auth id", pr.y1, pr.y2, pr.r1, pr.r2); bytes.Equal(oc, c) {
+		t.Fatalf("auth id is not bound to the challenge")
+	}
+}
+
+func TestUpdateChallenge(t *testing.T) {
+	p, _ := GetProtocol(Ristretto255)
+	nonce := []byte("verifier nonce")
+	salt := []byte("new salt of user")
+	pr := honestProof(t, p, []byte("secret"))
+	next := honestProof(t, p, []byte("new secret"))
+	other := honestProof(t, p, []byte("another secret"))
+
+	c, err := UpdateChallenge(p, "jon", nonce, pr.y1, pr.y2, pr.r1, pr.r2, salt, testKDFParams, next.y1, next.y2)
+	if err != nil {
+		t.Fatalf("error computing challenge: %s", err.Error())
+	}
+	if lc, _ := LoginChallenge(p, "jon", nonce, pr.y1, pr.y2, pr.r1, pr.r2); bytes.Equal(lc, c) {
+		t.Fatalf("update challenge equals the login challenge of the same values")
+	}
+
+	// changing any of the new credentials changes the challenge
+	tests := []struct {
+		name         string
+		salt         []byte
+		kdf          KDFParams
+		newY1, newY2 []byte
+	}{
+		{"salt", []byte("other salt of us"), testKDFParams, next.y1, next.y2},
+		{"kdf", salt, KDFParams{Time: 2, Memory: 64, Threads: 1}, next.y1, next.y2},
+		{"new y1", salt, testKDFParams, other.y1, next.y2},
+		{"new y2", salt, testKDFParams, next.y1, other.y2},
+	}
+	for _, tt := range tests {
+		oc, err := UpdateChallenge(p, "jon", nonce, pr.y1, pr.y2, pr.r1, pr.r2, tt.salt, tt.kdf, tt.newY1, tt.newY2)
+		if err != nil {
+			t.Fatalf("error computing challenge: %s", err.Error())
+		}
+		if bytes.Equal(oc, c) {
+			t.Errorf("%s is not bound to the challenge", tt.name)
+		}
+	}
+}
@@ -16,14 +16,15 @@ API_ENDPOINT="http://127.0.0.1:8080"
 
 # The user name and password
 USERNAME="jon"
-PASSWORD="12345098764363749966845241634859694732"
+PASSWORD="correct horse battery staple"
 
 # Function to perform login
 login() {
     curl --location "$API_ENDPOINT/login" \
     --header 'Content-Type: application/json' \
     --data '{
-        "userName": "'$USERNAME'"
+        "userName": "'$USERNAME'",
+        "password": "'"$PASSWORD"'"
     }'
 }
 
@@ -33,7 +34,7 @@ register() {
     --header 'Content-Type: application/json' \
     --data '{
         "userName": "'$USERNAME'",
-        "password": "'$PASSWORD'"
+        "password": "'"$PASSWORD"'"
     }'
 }
 